@@ -0,0 +1,97 @@
+package dcr_sdk
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+)
+
+// CertificateSource returns the PEM-encoded mTLS client certificate and key.
+type CertificateSource func() (certPEM, keyPEM []byte, err error)
+
+// FileCertificateSource returns a CertificateSource that reads the certificate and the key from files.
+// keyFile defaults to certFile, which then holds both.
+func FileCertificateSource(certFile, keyFile string) CertificateSource {
+	if keyFile == "" {
+		keyFile = certFile
+	}
+	return func() ([]byte, []byte, error) {
+		certPEM, err := os.ReadFile(certFile)
+		if err != nil {
+			return nil, nil, fmt.Errorf("read client certificate: %w", err)
+		}
+		keyPEM := certPEM
+		if keyFile != certFile {
+			if keyPEM, err = os.ReadFile(keyFile); err != nil {
+				return nil, nil, fmt.Errorf("read client key: %w", err)
+			}
+		}
+		return certPEM, keyPEM, nil
+	}
+}
+
+// CertificateReloader serves the mTLS client certificate from a CertificateSource with
+// tls.Config.GetClientCertificate and replaces it on Reload.
+//
+// Every certificate is validated with mtls.CheckTLS like in NewMTLSClientConfig. An invalid replacement
+// is rejected and the current certificate is kept. It implements client.CertificateReloader.
+type CertificateReloader struct {
+	cfg MTLSConfig
+
+	current atomic.Pointer[tls.Certificate]
+
+	// mu serializes reloads; certPEM and keyPEM are the source of the current certificate.
+	mu      sync.Mutex
+	certPEM []byte
+	keyPEM  []byte
+}
+
+// NewCertificateReloader loads the certificate from cfg.CertificateSource. It returns an error if the source
+// fails or the certificate is invalid.
+func NewCertificateReloader(cfg MTLSConfig) (*CertificateReloader, error) {
+	if cfg.CertificateSource == nil {
+		return nil, fmt.Errorf("certificate source is required")
+	}
+	r := &CertificateReloader{cfg: cfg}
+	if _, err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// GetClientCertificate returns the current certificate. It is meant for tls.Config.GetClientCertificate.
+func (r *CertificateReloader) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	return r.current.Load(), nil
+}
+
+// Leaf returns the current certificate, e.g. to monitor its expiry.
+func (r *CertificateReloader) Leaf() *x509.Certificate {
+	return r.current.Load().Leaf
+}
+
+// Reload loads the certificate from the source and reports whether it has changed. If the source fails
+// or the new certificate is invalid, Reload returns an error and the current certificate is kept.
+func (r *CertificateReloader) Reload() (bool, error) {
+	certPEM, keyPEM, err := r.cfg.CertificateSource()
+	if err != nil {
+		return false, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if bytes.Equal(certPEM, r.certPEM) && bytes.Equal(keyPEM, r.keyPEM) {
+		return false, nil
+	}
+	cert, err := loadClientCertificate(certPEM, keyPEM, r.cfg)
+	if err != nil {
+		return false, err
+	}
+	r.current.Store(cert)
+	r.certPEM, r.keyPEM = certPEM, keyPEM
+	return true, nil
+}
@@ -0,0 +1,170 @@
+package dcr_sdk
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/mygaru/dcr-sdk/internal/testcloud"
+	"github.com/mygaru/dcr-sdk/pkg/client"
+	"github.com/mygaru/dcr-sdk/pkg/serverauth"
+	"gitlab.adtelligent.com/awesome/mtls"
+)
+
+func TestCertificateReloaderKeepsCertificateOnInvalidReplacement(t *testing.T) {
+	ca, err := mtls.GenerateCA(mtls.GenerateCAConfig{CN: "dcr-sdk-test-client-ca"})
+	if err != nil {
+		t.Fatalf("generate client CA: %v", err)
+	}
+	first := generateTestClientCertificate(t, ca)
+	second := generateTestClientCertificate(t, ca)
+	untrusted, err := mtls.GenerateSelfSigned(mtls.GenerateConfig{CN: "dcr-sdk-client", UUID: uuid.NewString()})
+	if err != nil {
+		t.Fatalf("generate self-signed certificate: %v", err)
+	}
+
+	var current atomic.Pointer[mtls.Certificate]
+	current.Store(first)
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.Cert)
+	reloader, err := NewCertificateReloader(MTLSConfig{
+		ClientCertRoots:   roots,
+		CertificateSource: testCertificateSource(&current),
+	})
+	if err != nil {
+		t.Fatalf("create reloader: %v", err)
+	}
+
+	if changed, err := reloader.Reload(); changed || err != nil {
+		t.Fatalf("expected an unchanged certificate, got %v, %v", changed, err)
+	}
+
+	current.Store(untrusted)
+	if changed, err := reloader.Reload(); changed || err == nil {
+		t.Fatalf("expected the untrusted certificate to be rejected, got %v, %v", changed, err)
+	}
+	if got := mtls.GetUUID(reloader.Leaf()); got != mtls.GetUUID(first.Cert) {
+		t.Fatalf("expected the first certificate to be kept, got %s", got)
+	}
+
+	current.Store(second)
+	if changed, err := reloader.Reload(); !changed || err != nil {
+		t.Fatalf("expected the certificate to change, got %v, %v", changed, err)
+	}
+	cert, err := reloader.GetClientCertificate(nil)
+	if err != nil || mtls.GetUUID(cert.Leaf) != mtls.GetUUID(second.Cert) {
+		t.Fatalf("expected the second certificate to be served, got %v", err)
+	}
+}
+
+func TestNewWithMTLSPresentsReloadedCertificate(t *testing.T) {
+	ca, err := mtls.GenerateCA(mtls.GenerateCAConfig{CN: "dcr-sdk-test-client-ca"})
+	if err != nil {
+		t.Fatalf("generate client CA: %v", err)
+	}
+	first := generateTestClientCertificate(t, ca)
+	second := generateTestClientCertificate(t, ca)
+
+	serverCA, err := mtls.GenerateCA(mtls.GenerateCAConfig{CN: "dcr-sdk-test-server-ca"})
+	if err != nil {
+		t.Fatalf("generate server CA: %v", err)
+	}
+	serverTLSCert, err := newTestServerTLSCertificate(serverCA)
+	if err != nil {
+		t.Fatalf("generate server TLS certificate: %v", err)
+	}
+	clientRoots := x509.NewCertPool()
+	clientRoots.AddCert(ca.Cert)
+	serverRoots := x509.NewCertPool()
+	serverRoots.AddCert(serverCA.Cert)
+
+	var mu sync.Mutex
+	presented := make(map[string]int)
+	server := startTestCloud(t, testcloud.Config{
+		TLSConfig: serverauth.NewTLSConfig(&tls.Config{
+			Certificates: []tls.Certificate{serverTLSCert},
+			MinVersion:   tls.VersionTLS12,
+			VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+				leaf, err := x509.ParseCertificate(rawCerts[0])
+				if err != nil {
+					return err
+				}
+				mu.Lock()
+				presented[mtls.GetUUID(leaf)]++
+				mu.Unlock()
+				return nil
+			},
+		}, serverauth.MTLSConfig{Roots: clientRoots}),
+	})
+	presentedBy := func(cert *mtls.Certificate) int {
+		mu.Lock()
+		defer mu.Unlock()
+		return presented[mtls.GetUUID(cert.Cert)]
+	}
+
+	var current atomic.Pointer[mtls.Certificate]
+	current.Store(first)
+	rpc, err := NewWithMTLS(&client.Configuration{
+		Addrs:                          server.Addr(),
+		MaximumSimultaneousConnections: 2,
+		CertificateReload: client.CertificateReloadPolicy{
+			Interval:        10 * time.Millisecond,
+			RecycleDuration: 20 * time.Millisecond,
+		},
+	}, MTLSConfig{
+		CertificateSource: testCertificateSource(&current),
+		ServerRootCAs:     serverRoots,
+		ServerName:        "127.0.0.1",
+		ClientCertRoots:   clientRoots,
+	})
+	if err != nil {
+		t.Fatalf("create mTLS client: %v", err)
+	}
+	defer rpc.Close()
+
+	for i := 0; i < 2; i++ {
+		if _, _, err := rpc.Target(testTargetRequest()); err != nil {
+			t.Fatalf("target: %v", err)
+		}
+	}
+	if got := presentedBy(first); got != 2 {
+		t.Fatalf("expected both connections to present the first certificate, got %d", got)
+	}
+
+	current.Store(second)
+	deadline := time.Now().Add(2 * time.Second)
+	for presentedBy(second) < 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected both connections to present the reloaded certificate, got %d", presentedBy(second))
+		}
+		_, _, _ = rpc.Target(testTargetRequest())
+		time.Sleep(5 * time.Millisecond)
+	}
+	if _, _, err := rpc.Target(testTargetRequest()); err != nil {
+		t.Fatalf("target with the reloaded certificate: %v", err)
+	}
+}
+
+func generateTestClientCertificate(t *testing.T, ca *mtls.Certificate) *mtls.Certificate {
+	t.Helper()
+	cert, err := mtls.Generate(mtls.GenerateConfig{
+		CN:   "dcr-sdk-client",
+		UUID: uuid.NewString(),
+		CA:   ca,
+	})
+	if err != nil {
+		t.Fatalf("generate client certificate: %v", err)
+	}
+	return cert
+}
+
+func testCertificateSource(current *atomic.Pointer[mtls.Certificate]) CertificateSource {
+	return func() ([]byte, []byte, error) {
+		cert := current.Load()
+		return cert.CertPEM, cert.KeyPEM, nil
+	}
+}
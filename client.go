@@ -0,0 +1,43 @@
+package dcr_sdk
+
+import (
+	"context"
+
+	base "github.com/mygaru/dcr-sdk/gen/base1"
+	"github.com/mygaru/dcr-sdk/pkg/client"
+)
+
+// Client is the DCR client API used by applications.
+//
+// *client.ShardedClient returned by the constructors implements Client. Application code can depend on
+// Client instead and use the in-memory fake from pkg/dcrfake in its unit tests.
+type Client interface {
+	// Target verifies segments, frequency capping and identification for the users in req.
+	Target(req *base.TargetRequest) (*base.TargetResponse, base.RPCServerResponseCode, error)
+
+	// TargetContext is like Target, but the request is bound to ctx.
+	TargetContext(ctx context.Context, req *base.TargetRequest) (*base.TargetResponse, base.RPCServerResponseCode, error)
+
+	// Report reports an event for the tracking id returned by Target.
+	Report(req *base.ReportRequest) (base.RPCServerResponseCode, error)
+
+	// ReportContext is like Report, but the request is bound to ctx.
+	ReportContext(ctx context.Context, req *base.ReportRequest) (base.RPCServerResponseCode, error)
+
+	// IsValidTrackingID reports whether trackingId can be routed by Report.
+	IsValidTrackingID(trackingId []byte) bool
+
+	// PendingRequests returns the number of requests waiting for a response.
+	PendingRequests() int
+
+	// Reconnects returns the number of connections dialed so far.
+	Reconnects() int
+
+	// Close releases the client immediately.
+	Close() error
+
+	// Shutdown waits for the in-flight calls and then releases the client.
+	Shutdown(ctx context.Context) error
+}
+
+var _ Client = (*client.ShardedClient)(nil)
@@ -21,6 +21,7 @@ var (
 	clientCAPath = flag.String("clientCA", "", "PEM-encoded CA used to verify mTLS client certificates")
 	clientIssuer = flag.String("clientIssuer", "", "PEM-encoded issuer certificate used for OCSP checks")
 	requireOCSP  = flag.Bool("requireOCSP", false, "require good OCSP status for mTLS client certificates")
+	minSDK       = flag.String("minSDKVersion", "", "minimum SDK version accepted in hello requests; empty accepts any version")
 )
 
 func main() {
@@ -33,9 +34,10 @@ func main() {
 
 	log.Printf("Starting test-cloud RPC server at %q", *listenAddr)
 	err = testcloud.ListenAndServe(testcloud.Config{
-		ListenAddr: *listenAddr,
-		ServerID:   uint16(*serverID),
-		TLSConfig:  tlsConfig,
+		ListenAddr:    *listenAddr,
+		ServerID:      uint16(*serverID),
+		TLSConfig:     tlsConfig,
+		MinSDKVersion: *minSDK,
 	})
 	if err != nil {
 		log.Fatalf("test-cloud: serve failed on %q: %v", *listenAddr, err)
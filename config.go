@@ -0,0 +1,431 @@
+package dcr_sdk
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/mygaru/dcr-sdk/pkg/client"
+	"gopkg.in/yaml.v3"
+)
+
+// Mode selects the constructor NewFromConfig creates the client with.
+type Mode string
+
+const (
+	// ModePlain creates the client with New, without TLS. It is meant for tests and debugging and
+	// is only used when selected explicitly.
+	ModePlain Mode = "plain"
+
+	// ModeTLS creates the client with NewWithTLS and authenticates it with the JWT token.
+	ModeTLS Mode = "tls"
+
+	// ModeMTLS creates the client with NewWithMTLS from the certificate files.
+	ModeMTLS Mode = "mtls"
+)
+
+// Config is a client configuration loaded by ParseDSN, LoadConfigFile or LoadConfigFromEnv.
+//
+// Every source uses the same settings. In a DSN they are query parameters, in a file they are top-level keys
+// and in the environment they are upper-case variables with the DCR_ prefix, e.g. dialTimeout is DCR_DIAL_TIMEOUT:
+//
+//	addrs            Configuration.Addrs; the host part of a DSN
+//	mode             plain, tls or mtls; the scheme of a DSN: dcr://, dcr+tls:// or dcr+mtls://
+//	timeout          Configuration.MaxRequestDuration, e.g. 80ms
+//	dialTimeout      Configuration.MaxDialDuration
+//	dnsRefresh       Configuration.DNSRefreshInterval
+//	conns            Configuration.MaximumSimultaneousConnections
+//	pending          Configuration.MaxPendingRequests
+//	readBuffer       Configuration.ReadBufferSize
+//	writeBuffer      Configuration.WriteBufferSize
+//	authOnDial       Configuration.AuthOnDial
+//	token            Configuration.JwtToken
+//	tokenFile        file holding the JWT token; it is read again when it changes, see client.FileTokenSource
+//	allowPlainToken  AllowPlainToken; allows token or tokenFile in plain mode
+//	cert             PEM file with the mTLS client certificate; it may also hold the key
+//	key              PEM file with the mTLS client key; defaults to cert
+//	ca               PEM file with the CAs of the server certificate; the system roots by default
+//	clientCA         PEM file with the CAs used to validate the client certificate; the system roots by default
+//	serverName       name used to verify the server certificate
+//	certReload       Configuration.CertificateReload.Interval; cert and key are read again at this interval
+type Config struct {
+	// Client is the transport configuration.
+	Client client.Configuration
+
+	// Mode selects the constructor. If empty, ModeMTLS is used when CertFile is set and ModeTLS otherwise.
+	Mode Mode
+
+	// AllowPlainToken allows a token or token file in ModePlain, where it is sent unencrypted.
+	// Validate rejects the combination otherwise.
+	AllowPlainToken bool
+
+	// TokenFile holds the JWT token. NewFromConfig sets Client.TokenSource to a client.FileTokenSource for it,
+	// so the token is read again when the file changes.
+	TokenFile string
+
+	// CertFile and KeyFile hold the PEM-encoded mTLS client certificate and key. KeyFile defaults to CertFile.
+	// They are read again every Client.CertificateReload.Interval, see FileCertificateSource.
+	CertFile string
+	KeyFile  string
+
+	// ServerCAFile holds the PEM-encoded CAs of the server certificate. The system roots are used if empty.
+	ServerCAFile string
+
+	// ClientCAFile holds the PEM-encoded CAs used to validate the client certificate before it is used.
+	ClientCAFile string
+
+	// ServerName is used to verify the server certificate.
+	ServerName string
+}
+
+// configSettings maps the setting names to their setters.
+var configSettings = map[string]func(cfg *Config, value string) error{
+	"addrs":       func(cfg *Config, v string) error { cfg.Client.Addrs = v; return nil },
+	"mode":        func(cfg *Config, v string) error { cfg.Mode = Mode(strings.ToLower(v)); return nil },
+	"timeout":     durationSetting(func(cfg *Config) *time.Duration { return &cfg.Client.MaxRequestDuration }),
+	"dialTimeout": durationSetting(func(cfg *Config) *time.Duration { return &cfg.Client.MaxDialDuration }),
+	"dnsRefresh":  durationSetting(func(cfg *Config) *time.Duration { return &cfg.Client.DNSRefreshInterval }),
+	"conns":       intSetting(func(cfg *Config) *int { return &cfg.Client.MaximumSimultaneousConnections }),
+	"pending":     intSetting(func(cfg *Config) *int { return &cfg.Client.MaxPendingRequests }),
+	"readBuffer":  intSetting(func(cfg *Config) *int { return &cfg.Client.ReadBufferSize }),
+	"writeBuffer": intSetting(func(cfg *Config) *int { return &cfg.Client.WriteBufferSize }),
+	"authOnDial": func(cfg *Config, v string) (err error) {
+		cfg.Client.AuthOnDial, err = strconv.ParseBool(v)
+		return err
+	},
+	"token":     func(cfg *Config, v string) error { cfg.Client.JwtToken = []byte(v); return nil },
+	"tokenFile": func(cfg *Config, v string) error { cfg.TokenFile = v; return nil },
+	"allowPlainToken": func(cfg *Config, v string) (err error) {
+		cfg.AllowPlainToken, err = strconv.ParseBool(v)
+		return err
+	},
+	"cert":       func(cfg *Config, v string) error { cfg.CertFile = v; return nil },
+	"key":        func(cfg *Config, v string) error { cfg.KeyFile = v; return nil },
+	"ca":         func(cfg *Config, v string) error { cfg.ServerCAFile = v; return nil },
+	"clientCA":   func(cfg *Config, v string) error { cfg.ClientCAFile = v; return nil },
+	"serverName": func(cfg *Config, v string) error { cfg.ServerName = v; return nil },
+	"certReload": durationSetting(func(cfg *Config) *time.Duration { return &cfg.Client.CertificateReload.Interval }),
+}
+
+func durationSetting(field func(cfg *Config) *time.Duration) func(cfg *Config, value string) error {
+	return func(cfg *Config, v string) error {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return err
+		}
+		*field(cfg) = d
+		return nil
+	}
+}
+
+func intSetting(field func(cfg *Config) *int) func(cfg *Config, value string) error {
+	return func(cfg *Config, v string) error {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return err
+		}
+		*field(cfg) = n
+		return nil
+	}
+}
+
+// set applies the setting name.
+func (cfg *Config) set(name, value string) error {
+	setter, ok := configSettings[name]
+	if !ok {
+		return fmt.Errorf("unknown setting %q", name)
+	}
+	if err := setter(cfg, strings.TrimSpace(value)); err != nil {
+		return fmt.Errorf("invalid %s %q: %w", name, value, err)
+	}
+	return nil
+}
+
+// ParseDSN parses a connection string such as
+//
+//	dcr+mtls://cloud.mygaru.com:7937?timeout=80ms&conns=64&cert=/etc/dcr/client.pem
+//
+// The host part is Configuration.Addrs and may list several shards in the ParseShards syntax.
+// See Config for the supported parameters.
+func ParseDSN(dsn string) (*Config, error) {
+	scheme, rest, ok := strings.Cut(dsn, "://")
+	if !ok {
+		return nil, fmt.Errorf("invalid dsn: missing scheme")
+	}
+
+	cfg := &Config{}
+	switch scheme {
+	case "dcr":
+		cfg.Mode = ModePlain
+	case "dcr+tls":
+		cfg.Mode = ModeTLS
+	case "dcr+mtls":
+		cfg.Mode = ModeMTLS
+	default:
+		return nil, fmt.Errorf("invalid dsn: unsupported scheme %q, want dcr, dcr+tls or dcr+mtls", scheme)
+	}
+
+	addrs, rawQuery, _ := strings.Cut(rest, "?")
+	cfg.Client.Addrs = strings.TrimSuffix(addrs, "/")
+
+	query, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return nil, fmt.Errorf("invalid dsn: %w", err)
+	}
+	for _, name := range sortedKeys(query) {
+		if name == "addrs" || name == "mode" {
+			return nil, fmt.Errorf("invalid dsn: %s is set by the dsn itself", name)
+		}
+		if len(query[name]) > 1 {
+			return nil, fmt.Errorf("%w: invalid dsn: %s is set %d times", client.ErrInvalidConfiguration, name, len(query[name]))
+		}
+		if err := cfg.set(name, query.Get(name)); err != nil {
+			return nil, fmt.Errorf("invalid dsn: %w", err)
+		}
+	}
+	return cfg, nil
+}
+
+// LoadConfigFile loads a configuration from a JSON or YAML file with the settings as top-level keys.
+// Files with the .json extension are parsed as JSON and the others as YAML.
+func LoadConfigFile(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read config file: %w", err)
+	}
+
+	var settings map[string]any
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		err = json.Unmarshal(data, &settings)
+	} else {
+		err = yaml.Unmarshal(data, &settings)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parse config file %q: %w", path, err)
+	}
+
+	cfg := &Config{}
+	for _, name := range sortedKeys(settings) {
+		value, err := settingValue(settings[name])
+		if err != nil {
+			return nil, fmt.Errorf("parse config file %q: %s: %w", path, name, err)
+		}
+		if err := cfg.set(name, value); err != nil {
+			return nil, fmt.Errorf("parse config file %q: %w", path, err)
+		}
+	}
+	return cfg, nil
+}
+
+// settingValue converts a scalar decoded from a config file into its string form.
+// A list is joined with commas, so addrs may be written as a list of shards.
+func settingValue(v any) (string, error) {
+	switch v := v.(type) {
+	case string:
+		return v, nil
+	case bool, int, int64, uint64, float64:
+		return fmt.Sprint(v), nil
+	case []any:
+		items := make([]string, 0, len(v))
+		for _, item := range v {
+			s, err := settingValue(item)
+			if err != nil {
+				return "", err
+			}
+			items = append(items, s)
+		}
+		return strings.Join(items, ","), nil
+	default:
+		return "", fmt.Errorf("unsupported value of type %T", v)
+	}
+}
+
+// LoadConfigFromEnv loads a configuration from the environment. The base configuration is
+// the DSN in DCR_DSN or the file in DCR_CONFIG_FILE, if any, and every DCR_* setting overrides it.
+func LoadConfigFromEnv() (*Config, error) {
+	return loadConfigFromEnv(os.LookupEnv)
+}
+
+func loadConfigFromEnv(lookup func(string) (string, bool)) (*Config, error) {
+	cfg := &Config{}
+	dsn, hasDSN := lookup("DCR_DSN")
+	file, hasFile := lookup("DCR_CONFIG_FILE")
+	switch {
+	case hasDSN && hasFile:
+		return nil, fmt.Errorf("DCR_DSN and DCR_CONFIG_FILE must not be set together")
+	case hasDSN:
+		var err error
+		if cfg, err = ParseDSN(dsn); err != nil {
+			return nil, fmt.Errorf("DCR_DSN: %w", err)
+		}
+	case hasFile:
+		var err error
+		if cfg, err = LoadConfigFile(file); err != nil {
+			return nil, fmt.Errorf("DCR_CONFIG_FILE: %w", err)
+		}
+	}
+
+	if err := cfg.applyEnv(lookup); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// ApplyEnv overrides cfg with the DCR_* settings from the environment.
+func (cfg *Config) ApplyEnv() error {
+	return cfg.applyEnv(os.LookupEnv)
+}
+
+func (cfg *Config) applyEnv(lookup func(string) (string, bool)) error {
+	for _, name := range sortedKeys(configSettings) {
+		env := envName(name)
+		if value, ok := lookup(env); ok {
+			if err := cfg.set(name, value); err != nil {
+				return fmt.Errorf("%s: %w", env, err)
+			}
+		}
+	}
+	return nil
+}
+
+// envName returns the environment variable of the setting name, e.g. DCR_DIAL_TIMEOUT for dialTimeout.
+func envName(name string) string {
+	var sb strings.Builder
+	sb.WriteString("DCR_")
+	for _, r := range name {
+		if unicode.IsUpper(r) {
+			sb.WriteByte('_')
+		}
+		sb.WriteRune(unicode.ToUpper(r))
+	}
+	return sb.String()
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// mode returns the effective Mode of cfg.
+func (cfg *Config) mode() Mode {
+	if cfg.Mode != "" {
+		return cfg.Mode
+	}
+	if cfg.CertFile != "" {
+		return ModeMTLS
+	}
+	return ModeTLS
+}
+
+// Validate reports the problems that would prevent NewFromConfig from creating the client.
+func (cfg *Config) Validate() error {
+	var errs []error
+	hasToken := len(cfg.Client.JwtToken) > 0 || cfg.TokenFile != ""
+	hasCert := cfg.CertFile != "" || cfg.KeyFile != ""
+	switch mode := cfg.mode(); mode {
+	case ModePlain, ModeTLS:
+		if mode == ModePlain && hasToken && !cfg.AllowPlainToken {
+			errs = append(errs, fmt.Errorf("token and tokenFile are sent unencrypted in %s mode; "+
+				"use %s or set allowPlainToken", ModePlain, ModeTLS))
+		}
+		if hasCert {
+			errs = append(errs, fmt.Errorf("cert and key are only used in %s mode, got %s", ModeMTLS, mode))
+		}
+	case ModeMTLS:
+		if cfg.CertFile == "" {
+			errs = append(errs, fmt.Errorf("cert is required in %s mode", ModeMTLS))
+		}
+		if hasToken {
+			errs = append(errs, fmt.Errorf("token and tokenFile are not used in %s mode, "+
+				"which authenticates with the client certificate", ModeMTLS))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("unknown mode %q, want %s, %s or %s", cfg.Mode, ModePlain, ModeTLS, ModeMTLS))
+	}
+	if len(cfg.Client.JwtToken) > 0 && cfg.TokenFile != "" {
+		errs = append(errs, fmt.Errorf("token and tokenFile must not be set together"))
+	}
+	var err error
+	if len(errs) > 0 {
+		err = fmt.Errorf("%w: %w", client.ErrInvalidConfiguration, errors.Join(errs...))
+	}
+	return errors.Join(err, cfg.Client.Validate())
+}
+
+// NewFromConfig validates cfg, reads the files it refers to and creates the client like New, NewWithTLS
+// or NewWithMTLS depending on the mode, checking its authentication as NewValidated does.
+func NewFromConfig(cfg *Config) (*client.ShardedClient, error) {
+	if cfg == nil {
+		return nil, fmt.Errorf("configuration is required")
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	clientCfg := cfg.Client
+	if cfg.TokenFile != "" {
+		tokens := client.NewFileTokenSource(cfg.TokenFile)
+		if _, err := tokens.Token(context.Background()); err != nil {
+			return nil, err
+		}
+		clientCfg.TokenSource = tokens
+	}
+
+	serverRoots, err := loadCertPool(cfg.ServerCAFile)
+	if err != nil {
+		return nil, fmt.Errorf("load server CA: %w", err)
+	}
+
+	switch cfg.mode() {
+	case ModeTLS:
+		return client.NewValidatedClient(&clientCfg, &tls.Config{
+			RootCAs:    serverRoots,
+			ServerName: cfg.ServerName,
+			MinVersion: tls.VersionTLS12,
+		})
+	case ModeMTLS:
+		clientRoots, err := loadCertPool(cfg.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("load client CA: %w", err)
+		}
+		return newWithMTLS(&clientCfg, MTLSConfig{
+			CertificateSource: FileCertificateSource(cfg.CertFile, cfg.KeyFile),
+			ServerRootCAs:     serverRoots,
+			ServerName:        cfg.ServerName,
+			ClientCertRoots:   clientRoots,
+		}, client.NewValidatedClient)
+	default:
+		return client.NewValidatedClient(&clientCfg, nil)
+	}
+}
+
+// loadCertPool reads the PEM-encoded certificates in path into a pool. It returns nil if path is empty.
+func loadCertPool(path string) (*x509.CertPool, error) {
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificates found in %q", path)
+	}
+	return pool, nil
+}
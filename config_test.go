@@ -0,0 +1,282 @@
+package dcr_sdk
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	base "github.com/mygaru/dcr-sdk/gen/base1"
+	"github.com/mygaru/dcr-sdk/internal/testcloud"
+	"github.com/mygaru/dcr-sdk/pkg/client"
+	"github.com/mygaru/dcr-sdk/pkg/serverauth"
+	"gitlab.adtelligent.com/awesome/mtls"
+)
+
+func TestParseDSN(t *testing.T) {
+	cfg, err := ParseDSN("dcr+mtls://1024@dcr-a:7937;weight=2,dcr-b:7937?timeout=80ms&conns=64&cert=/etc/dcr/client.pem&authOnDial=true")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if cfg.Mode != ModeMTLS {
+		t.Fatalf("unexpected mode: %s", cfg.Mode)
+	}
+	if cfg.Client.Addrs != "1024@dcr-a:7937;weight=2,dcr-b:7937" {
+		t.Fatalf("unexpected addrs: %s", cfg.Client.Addrs)
+	}
+	if cfg.Client.MaxRequestDuration != 80*time.Millisecond || cfg.Client.MaximumSimultaneousConnections != 64 || !cfg.Client.AuthOnDial {
+		t.Fatalf("unexpected client configuration: %+v", cfg.Client)
+	}
+	if cfg.CertFile != "/etc/dcr/client.pem" {
+		t.Fatalf("unexpected cert file: %s", cfg.CertFile)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("unexpected validation error: %s", err)
+	}
+
+	for _, dsn := range []string{
+		"cloud.mygaru.com:7937",
+		"http://cloud.mygaru.com:7937",
+		"dcr://cloud.mygaru.com:7937?timeout=fast",
+		"dcr://cloud.mygaru.com:7937?retries=3",
+		"dcr://cloud.mygaru.com:7937?mode=tls",
+	} {
+		if _, err := ParseDSN(dsn); err == nil {
+			t.Fatalf("expected %q to be rejected", dsn)
+		}
+	}
+}
+
+func TestLoadConfigFile(t *testing.T) {
+	dir := t.TempDir()
+	yamlPath := filepath.Join(dir, "dcr.yaml")
+	writeTestFile(t, yamlPath, []byte(`
+mode: tls
+addrs:
+  - 1024@dcr-a:7937
+  - dcr-b:7937
+timeout: 80ms
+conns: 64
+serverName: cloud.mygaru.com
+`))
+	cfg, err := LoadConfigFile(yamlPath)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if cfg.Mode != ModeTLS || cfg.Client.Addrs != "1024@dcr-a:7937,dcr-b:7937" || cfg.Client.MaximumSimultaneousConnections != 64 ||
+		cfg.Client.MaxRequestDuration != 80*time.Millisecond || cfg.ServerName != "cloud.mygaru.com" {
+		t.Fatalf("unexpected yaml config: %+v", cfg)
+	}
+
+	jsonPath := filepath.Join(dir, "dcr.json")
+	writeTestFile(t, jsonPath, []byte(`{"addrs": "dcr-a:7937", "conns": 8, "authOnDial": true}`))
+	cfg, err = LoadConfigFile(jsonPath)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if cfg.Client.Addrs != "dcr-a:7937" || cfg.Client.MaximumSimultaneousConnections != 8 || !cfg.Client.AuthOnDial {
+		t.Fatalf("unexpected json config: %+v", cfg)
+	}
+
+	writeTestFile(t, jsonPath, []byte(`{"retry": {"maxAttempts": 3}}`))
+	if _, err := LoadConfigFile(jsonPath); err == nil {
+		t.Fatalf("expected unknown settings to be rejected")
+	}
+}
+
+func TestLoadConfigFromEnvOverridesDSN(t *testing.T) {
+	env := map[string]string{
+		"DCR_DSN":          "dcr://dcr-a:7937?conns=64&timeout=80ms",
+		"DCR_CONNS":        "16",
+		"DCR_DIAL_TIMEOUT": "1s",
+	}
+	cfg, err := loadConfigFromEnv(func(name string) (string, bool) {
+		v, ok := env[name]
+		return v, ok
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if cfg.Client.Addrs != "dcr-a:7937" || cfg.Client.MaximumSimultaneousConnections != 16 ||
+		cfg.Client.MaxRequestDuration != 80*time.Millisecond || cfg.Client.MaxDialDuration != time.Second {
+		t.Fatalf("unexpected config: %+v", cfg.Client)
+	}
+
+	env["DCR_CONNS"] = "many"
+	if _, err := loadConfigFromEnv(func(name string) (string, bool) {
+		v, ok := env[name]
+		return v, ok
+	}); err == nil {
+		t.Fatalf("expected a malformed override to be rejected")
+	}
+}
+
+func TestNewFromConfigCreatesPlainClient(t *testing.T) {
+	server := startTestCloud(t, testcloud.Config{})
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	writeTestFile(t, tokenFile, []byte(uuid.NewString()+"\n"))
+
+	cfg, err := ParseDSN("dcr://" + server.Addr() + "?conns=1&allowPlainToken=true&tokenFile=" + tokenFile)
+	if err != nil {
+		t.Fatalf("parse dsn: %v", err)
+	}
+	rpc, err := NewFromConfig(cfg)
+	if err != nil {
+		t.Fatalf("create client: %v", err)
+	}
+	defer rpc.Close()
+
+	if _, sc, err := rpc.Target(testTargetRequest()); err != nil || sc != base.RPCServerResponseCode_OK {
+		t.Fatalf("expected target to succeed, got %s, %v", sc, err)
+	}
+}
+
+func TestConfigModeDefaultsToTLS(t *testing.T) {
+	for _, tc := range []struct {
+		cfg  Config
+		mode Mode
+	}{
+		{Config{}, ModeTLS},
+		{Config{CertFile: "/etc/dcr/client.pem"}, ModeMTLS},
+		{Config{Mode: ModePlain}, ModePlain},
+	} {
+		if got := tc.cfg.mode(); got != tc.mode {
+			t.Fatalf("%+v: expected mode %s, got %s", tc.cfg, tc.mode, got)
+		}
+	}
+}
+
+func TestConfigRejectsTokenInPlainMode(t *testing.T) {
+	for _, dsn := range []string{
+		"dcr://dcr-a:7937?token=secret",
+		"dcr://dcr-a:7937?tokenFile=/etc/dcr/token",
+	} {
+		cfg, err := ParseDSN(dsn)
+		if err != nil {
+			t.Fatalf("%q: unexpected error: %s", dsn, err)
+		}
+		if err := cfg.Validate(); err == nil {
+			t.Fatalf("%q: expected a token in plain mode to be rejected", dsn)
+		}
+		cfg.AllowPlainToken = true
+		if err := cfg.Validate(); err != nil {
+			t.Fatalf("%q: expected allowPlainToken to allow the token, got %s", dsn, err)
+		}
+	}
+
+	cfg, err := ParseDSN("dcr+tls://dcr-a:7937?token=secret")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("unexpected validation error: %s", err)
+	}
+}
+
+func TestParseDSNRejectsDuplicateParameters(t *testing.T) {
+	for _, dsn := range []string{
+		"dcr+tls://dcr-a:7937?timeout=80ms&timeout=1s",
+		"dcr+tls://dcr-a:7937?token=old&conns=4&token=new",
+	} {
+		if _, err := ParseDSN(dsn); !errors.Is(err, client.ErrInvalidConfiguration) {
+			t.Fatalf("%q: expected ErrInvalidConfiguration, got %v", dsn, err)
+		}
+	}
+}
+
+func TestConfigRejectsCredentialsOfAnotherMode(t *testing.T) {
+	for _, dsn := range []string{
+		"dcr+mtls://dcr-a:7937?cert=/etc/dcr/client.pem&token=secret",
+		"dcr+mtls://dcr-a:7937?cert=/etc/dcr/client.pem&tokenFile=/etc/dcr/token",
+		"dcr+tls://dcr-a:7937?token=secret&cert=/etc/dcr/client.pem",
+		"dcr+tls://dcr-a:7937?token=secret&key=/etc/dcr/client.key",
+	} {
+		cfg, err := ParseDSN(dsn)
+		if err != nil {
+			t.Fatalf("%q: unexpected error: %s", dsn, err)
+		}
+		if err := cfg.Validate(); !errors.Is(err, client.ErrInvalidConfiguration) {
+			t.Fatalf("%q: expected ErrInvalidConfiguration, got %v", dsn, err)
+		}
+	}
+}
+
+func TestNewFromConfigCreatesMTLSClient(t *testing.T) {
+	ca, err := mtls.GenerateCA(mtls.GenerateCAConfig{CN: "dcr-sdk-test-client-ca"})
+	if err != nil {
+		t.Fatalf("generate client CA: %v", err)
+	}
+	clientCert, err := mtls.Generate(mtls.GenerateConfig{
+		CN:   "dcr-sdk-client",
+		UUID: uuid.NewString(),
+		CA:   ca,
+	})
+	if err != nil {
+		t.Fatalf("generate client certificate: %v", err)
+	}
+	serverCA, err := mtls.GenerateCA(mtls.GenerateCAConfig{CN: "dcr-sdk-test-server-ca"})
+	if err != nil {
+		t.Fatalf("generate server CA: %v", err)
+	}
+	serverTLSCert, err := newTestServerTLSCertificate(serverCA)
+	if err != nil {
+		t.Fatalf("generate server TLS certificate: %v", err)
+	}
+
+	clientRoots := x509.NewCertPool()
+	clientRoots.AddCert(ca.Cert)
+	server := startTestCloud(t, testcloud.Config{
+		TLSConfig: serverauth.NewTLSConfig(&tls.Config{
+			Certificates: []tls.Certificate{serverTLSCert},
+			MinVersion:   tls.VersionTLS12,
+		}, serverauth.MTLSConfig{Roots: clientRoots}),
+	})
+
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "client.pem")
+	writeTestFile(t, certFile, append(append([]byte(nil), clientCert.CertPEM...), clientCert.KeyPEM...))
+	caFile := filepath.Join(dir, "server-ca.pem")
+	writeTestFile(t, caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: serverCA.Cert.Raw}))
+	clientCAFile := filepath.Join(dir, "client-ca.pem")
+	writeTestFile(t, clientCAFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Cert.Raw}))
+
+	cfg, err := ParseDSN("dcr+mtls://" + server.Addr() + "?conns=1&serverName=127.0.0.1&cert=" + certFile +
+		"&ca=" + caFile + "&clientCA=" + clientCAFile)
+	if err != nil {
+		t.Fatalf("parse dsn: %v", err)
+	}
+	rpc, err := NewFromConfig(cfg)
+	if err != nil {
+		t.Fatalf("create client: %v", err)
+	}
+	defer rpc.Close()
+
+	if _, sc, err := rpc.Target(testTargetRequest()); err != nil || sc != base.RPCServerResponseCode_OK {
+		t.Fatalf("expected target to succeed, got %s, %v", sc, err)
+	}
+}
+
+func TestNewFromConfigRejectsInvalidConfig(t *testing.T) {
+	for _, cfg := range []*Config{
+		nil,
+		{Mode: ModeMTLS},
+		{Mode: "ssl"},
+		{Client: client.Configuration{Addrs: "dcr-a:7937;weight=0x"}},
+	} {
+		if _, err := NewFromConfig(cfg); err == nil {
+			t.Fatalf("expected %+v to be rejected", cfg)
+		}
+	}
+}
+
+func writeTestFile(t *testing.T, path string, data []byte) {
+	t.Helper()
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatalf("write %s: %v", path, err)
+	}
+}
@@ -10,6 +10,7 @@ require (
 	github.com/valyala/fasthttp v1.70.0
 	gitlab.adtelligent.com/awesome/mtls v0.0.0-20260617143813-675d16f39e3d
 	google.golang.org/protobuf v1.36.11
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
@@ -5,6 +5,7 @@ import (
 	"encoding/binary"
 	"fmt"
 	"net"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -29,6 +30,11 @@ type Config struct {
 	AuthStatusCode base.RPCServerResponseCode
 	// TargetStatus lets tests force Target failures. UNKNOWN means OK.
 	TargetStatus base.RPCServerResponseCode
+	// MinSDKVersion is announced in contract.Hello responses. Hello requests with an older SDK version
+	// are answered with OUTDATED. Empty accepts any version.
+	MinSDKVersion string
+	// DisableHello makes the server answer contract.Hello with INVALID_REQUEST like servers that predate it.
+	DisableHello bool
 	// ReportBuffer controls how many Report requests are retained for tests. Defaults to 8.
 	ReportBuffer int
 }
@@ -41,6 +47,7 @@ type Server struct {
 	done    chan error
 	reports chan *base.ReportRequest
 	counter atomic.Uint64
+	revoked sync.Map
 }
 
 // Start starts a test-cloud RPC server in a goroutine.
@@ -115,6 +122,27 @@ func (s *Server) Reports() <-chan *base.ReportRequest {
 	return s.reports
 }
 
+// RevokeToken makes the server reject token like an expired JWT: contract.Auth with it and the requests over
+// the connections authenticated with it are answered with UNAUTHORIZED.
+func (s *Server) RevokeToken(token string) error {
+	payerID, err := uuid.Parse(token)
+	if err != nil {
+		return fmt.Errorf("invalid test JWT UUID: %w", err)
+	}
+	s.revoked.Store(payerID, struct{}{})
+	return nil
+}
+
+// authorized reports whether the connection of ctx is authenticated with a token that hasn't been revoked.
+func (s *Server) authorized(ctx *contract.RequestCtx) bool {
+	payerID, ok := serverauth.GetUUID(ctx.Conn())
+	if !ok {
+		return false
+	}
+	_, revoked := s.revoked.Load(payerID)
+	return !revoked
+}
+
 // Close stops a server started with Start.
 func (s *Server) Close() error {
 	if s == nil || s.ln == nil {
@@ -157,6 +185,8 @@ func (s *Server) handle(ctxv fastrpc.HandlerCtx) fastrpc.HandlerCtx {
 	ctx := ctxv.(*contract.RequestCtx)
 
 	switch ctx.Request.GetName() {
+	case contract.Hello:
+		s.handleHello(ctx)
 	case contract.Auth:
 		s.handleAuth(ctx)
 	case contract.Target:
@@ -169,6 +199,30 @@ func (s *Server) handle(ctxv fastrpc.HandlerCtx) fastrpc.HandlerCtx {
 	return ctxv
 }
 
+func (s *Server) handleHello(ctx *contract.RequestCtx) {
+	if s.cfg.DisableHello {
+		writeError(ctx, base.RPCServerResponseCode_INVALID_REQUEST, fmt.Errorf("unsupported request name: %s", ctx.Request.GetName()))
+		return
+	}
+
+	hello := contract.HelloResponse{
+		ServerID:      s.cfg.ServerID,
+		Capabilities:  contract.CapabilityJWTAuth,
+		MinSDKVersion: s.cfg.MinSDKVersion,
+	}
+	if s.cfg.TLSConfig != nil {
+		hello.Capabilities |= contract.CapabilityMTLSAuth
+	}
+
+	statusCode := base.RPCServerResponseCode_OK
+	if s.cfg.MinSDKVersion != "" && contract.CompareVersions(string(ctx.Request.Value()), s.cfg.MinSDKVersion) < 0 {
+		statusCode = base.RPCServerResponseCode_OUTDATED
+	}
+
+	ctx.Response.SetStatusCode(statusCode)
+	ctx.Response.SwapValue(hello.AppendTo(ctx.Response.SwapValue(nil)))
+}
+
 func (s *Server) handleAuth(ctx *contract.RequestCtx) {
 	if _, ok := serverauth.GetUUID(ctx.Conn()); ok {
 		writeError(ctx, base.RPCServerResponseCode_INVALID_REQUEST, fmt.Errorf("connection is already authenticated"))
@@ -192,6 +246,10 @@ func (s *Server) handleAuth(ctx *contract.RequestCtx) {
 		writeError(ctx, base.RPCServerResponseCode_UNAUTHORIZED, fmt.Errorf("invalid test JWT UUID: %w", err))
 		return
 	}
+	if _, revoked := s.revoked.Load(payerID); revoked {
+		writeError(ctx, base.RPCServerResponseCode_UNAUTHORIZED, fmt.Errorf("test JWT is revoked"))
+		return
+	}
 	if err := serverauth.SetUUID(ctx.Conn(), payerID); err != nil {
 		writeError(ctx, base.RPCServerResponseCode_TECH_ERROR, err)
 		return
@@ -205,7 +263,7 @@ func (s *Server) handleAuth(ctx *contract.RequestCtx) {
 }
 
 func (s *Server) handleTarget(ctx *contract.RequestCtx) {
-	if _, ok := serverauth.GetUUID(ctx.Conn()); !ok {
+	if !s.authorized(ctx) {
 		writeError(ctx, base.RPCServerResponseCode_UNAUTHORIZED, fmt.Errorf("unauthorized"))
 		return
 	}
@@ -237,7 +295,7 @@ func (s *Server) handleTarget(ctx *contract.RequestCtx) {
 }
 
 func (s *Server) handleReport(ctx *contract.RequestCtx) {
-	if _, ok := serverauth.GetUUID(ctx.Conn()); !ok {
+	if !s.authorized(ctx) {
 		writeError(ctx, base.RPCServerResponseCode_UNAUTHORIZED, fmt.Errorf("unauthorized"))
 		return
 	}
@@ -257,7 +315,7 @@ func (s *Server) handleReport(ctx *contract.RequestCtx) {
 
 func (s *Server) nextTrackingID() []byte {
 	n := s.counter.Add(1)
-	return []byte(fmt.Sprintf("%04X%012X", s.cfg.ServerID, n))
+	return contract.NewTrackingID(s.cfg.ServerID, n).AppendTo(nil)
 }
 
 func writeProto(ctx *contract.RequestCtx, statusCode base.RPCServerResponseCode, msg proto.Message) {
@@ -0,0 +1,104 @@
+package client
+
+import (
+	"math/rand/v2"
+	"sync/atomic"
+	"time"
+)
+
+// Endpoint is a shard or a single connection of a shard that a Balancer picks from.
+type Endpoint interface {
+	// PendingRequests returns the number of requests sent to the endpoint and still waiting for a response.
+	PendingRequests() int
+
+	// Latency returns the moving average of recent request latencies, or zero if there were no requests yet.
+	Latency() time.Duration
+}
+
+// Balancer picks the shard for every Target request and the connection for every request to a shard.
+//
+// A separate Balancer is created for the shards and for the connections of every shard.
+// Pick is called concurrently and must return an index in [0, len(endpoints)).
+// endpoints is never empty and must not be modified.
+type Balancer interface {
+	Pick(endpoints []Endpoint) int
+}
+
+// NewRoundRobinBalancer returns a Balancer that picks endpoints in turn. It is the default balancer.
+func NewRoundRobinBalancer() Balancer {
+	return &roundRobinBalancer{}
+}
+
+type roundRobinBalancer struct {
+	n atomic.Uint64
+}
+
+func (b *roundRobinBalancer) Pick(endpoints []Endpoint) int {
+	n := b.n.Add(1)
+	return int(n % uint64(len(endpoints)))
+}
+
+// NewP2CBalancer returns a power-of-two-choices Balancer.
+//
+// It picks two random endpoints and uses the one with the lower expected cost,
+// i.e. (pending requests + 1) * recent latency. Unless both endpoints have latency samples,
+// only their pending requests are compared. Either way, connections that are stuck reconnecting
+// or already have MaxPendingRequests in flight are avoided.
+func NewP2CBalancer() Balancer {
+	return &p2cBalancer{}
+}
+
+type p2cBalancer struct{}
+
+func (b *p2cBalancer) Pick(endpoints []Endpoint) int {
+	n := len(endpoints)
+	if n == 1 {
+		return 0
+	}
+	i := rand.IntN(n)
+	j := rand.IntN(n - 1)
+	if j >= i {
+		j++
+	}
+
+	pi, pj := endpoints[i].PendingRequests(), endpoints[j].PendingRequests()
+	li, lj := endpoints[i].Latency(), endpoints[j].Latency()
+	if li > 0 && lj > 0 {
+		if float64(pj+1)*float64(lj) < float64(pi+1)*float64(li) {
+			return j
+		}
+		return i
+	}
+	if pj < pi {
+		return j
+	}
+	return i
+}
+
+// ewma is an exponentially weighted moving average of latencies, safe for concurrent use.
+type ewma struct {
+	v atomic.Int64
+}
+
+// ewmaWeight is the reciprocal of the weight of a new sample.
+const ewmaWeight = 8
+
+func (e *ewma) update(d time.Duration) {
+	if d <= 0 {
+		d = 1
+	}
+	for {
+		old := e.v.Load()
+		next := int64(d)
+		if old > 0 {
+			next = old + (int64(d)-old)/ewmaWeight
+		}
+		if e.v.CompareAndSwap(old, max(next, 1)) {
+			return
+		}
+	}
+}
+
+func (e *ewma) value() time.Duration {
+	return time.Duration(e.v.Load())
+}
@@ -0,0 +1,104 @@
+package client
+
+import (
+	"testing"
+	"time"
+)
+
+type testEndpoint struct {
+	pending int
+	latency time.Duration
+}
+
+func (e *testEndpoint) PendingRequests() int   { return e.pending }
+func (e *testEndpoint) Latency() time.Duration { return e.latency }
+
+func TestRoundRobinBalancerPicksInTurn(t *testing.T) {
+	b := NewRoundRobinBalancer()
+	endpoints := []Endpoint{&testEndpoint{}, &testEndpoint{}, &testEndpoint{}}
+
+	seen := make(map[int]int)
+	for i := 0; i < 9; i++ {
+		seen[b.Pick(endpoints)]++
+	}
+	for i := range endpoints {
+		if seen[i] != 3 {
+			t.Fatalf("expected every endpoint to be picked 3 times, got %v", seen)
+		}
+	}
+}
+
+func TestP2CBalancerAvoidsLoadedEndpoint(t *testing.T) {
+	b := NewP2CBalancer()
+	endpoints := []Endpoint{
+		&testEndpoint{pending: 8},
+		&testEndpoint{pending: 0},
+	}
+	for i := 0; i < 100; i++ {
+		if idx := b.Pick(endpoints); idx != 1 {
+			t.Fatalf("expected the endpoint without pending requests, got %d", idx)
+		}
+	}
+}
+
+func TestP2CBalancerAvoidsSlowEndpoint(t *testing.T) {
+	b := NewP2CBalancer()
+	endpoints := []Endpoint{
+		&testEndpoint{pending: 1, latency: time.Millisecond},
+		&testEndpoint{pending: 1, latency: 100 * time.Millisecond},
+	}
+	for i := 0; i < 100; i++ {
+		if idx := b.Pick(endpoints); idx != 0 {
+			t.Fatalf("expected the faster endpoint, got %d", idx)
+		}
+	}
+}
+
+func TestP2CBalancerSpreadsEqualLoad(t *testing.T) {
+	b := NewP2CBalancer()
+	endpoints := []Endpoint{&testEndpoint{}, &testEndpoint{}, &testEndpoint{}, &testEndpoint{}}
+
+	seen := make(map[int]int)
+	for i := 0; i < 400; i++ {
+		seen[b.Pick(endpoints)]++
+	}
+	if len(seen) != len(endpoints) {
+		t.Fatalf("expected all endpoints to be picked, got %v", seen)
+	}
+}
+
+func TestEWMAConvergesToRecentLatency(t *testing.T) {
+	var e ewma
+	e.update(100 * time.Millisecond)
+	if got := e.value(); got != 100*time.Millisecond {
+		t.Fatalf("expected the first sample to be used as is, got %s", got)
+	}
+	for i := 0; i < 100; i++ {
+		e.update(time.Millisecond)
+	}
+	if got := e.value(); got > 2*time.Millisecond {
+		t.Fatalf("expected the average to converge to 1ms, got %s", got)
+	}
+}
+
+func TestShardedClientUsesConfiguredBalancer(t *testing.T) {
+	sc := NewClient(&Configuration{
+		Addrs:                          "127.0.0.1:1,127.0.0.1:2",
+		DisableAuth:                    true,
+		MaximumSimultaneousConnections: 2,
+		DNSRefreshInterval:             -1,
+		Balancer:                       NewP2CBalancer,
+	}, nil)
+
+	if _, ok := sc.balancer.(*p2cBalancer); !ok {
+		t.Fatalf("expected the shard balancer to be P2C, got %T", sc.balancer)
+	}
+	for _, shard := range sc.shardSet().clients {
+		if _, ok := shard.balancer.(*p2cBalancer); !ok {
+			t.Fatalf("expected the connection balancer to be P2C, got %T", shard.balancer)
+		}
+		if len(shard.endpoints) != len(shard.clients) {
+			t.Fatalf("expected an endpoint per connection")
+		}
+	}
+}
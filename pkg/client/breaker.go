@@ -0,0 +1,248 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	base "github.com/mygaru/dcr-sdk/gen/base1"
+	"github.com/mygaru/dcr-sdk/pkg/contract"
+)
+
+const (
+	defaultBreakerMinRequests  = 20
+	defaultBreakerWindow       = time.Second
+	defaultBreakerOpenDuration = 5 * time.Second
+)
+
+// BreakerState is the state of a shard circuit breaker.
+type BreakerState int32
+
+const (
+	// BreakerClosed means the shard receives its share of Target requests.
+	BreakerClosed BreakerState = iota
+	// BreakerOpen means the shard is out of rotation for Target requests.
+	BreakerOpen
+	// BreakerHalfOpen means a single probe Target is allowed to check whether the shard recovered.
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	default:
+		return fmt.Sprintf("BreakerState(%d)", int32(s))
+	}
+}
+
+// BreakerPolicy configures the circuit breaker of every shard.
+//
+// The breaker counts timeouts and transport errors of the shard over a sliding window.
+// When their share of requests reaches FailureRatio, the breaker opens and Target skips the shard.
+// After OpenDuration a single probe Target is sent to the shard: the breaker closes if it succeeds
+// and opens again otherwise. A probe canceled by the caller or by a winning hedged request, or cut off
+// by the caller's deadline, is inconclusive, and another probe is allowed. If all shards are open,
+// Target falls back to plain round-robin.
+//
+// Report is always sent to the shard that issued the tracking id, regardless of its breaker state.
+type BreakerPolicy struct {
+	// FailureRatio is the share of failed requests in a window that opens the breaker.
+	// It must be in (0, 1]. Zero disables circuit breaking.
+	FailureRatio float64
+
+	// MinRequests is the minimum number of requests in a window required to open the breaker.
+	// By default 20.
+	MinRequests int
+
+	// Window is the interval over which the failure ratio is computed.
+	// By default 1s.
+	Window time.Duration
+
+	// OpenDuration is how long the breaker stays open before a probe is sent.
+	// By default 5s.
+	OpenDuration time.Duration
+
+	// OnStateChange is called on every breaker state change of the shard with address addr.
+	// It is called synchronously from the request goroutine and must not block.
+	OnStateChange func(addr string, from, to BreakerState)
+}
+
+func normalizeBreakerPolicy(p BreakerPolicy) BreakerPolicy {
+	if p.FailureRatio <= 0 {
+		return BreakerPolicy{}
+	}
+	if p.FailureRatio > 1 {
+		p.FailureRatio = 1
+	}
+	if p.MinRequests <= 0 {
+		p.MinRequests = defaultBreakerMinRequests
+	}
+	if p.Window <= 0 {
+		p.Window = defaultBreakerWindow
+	}
+	if p.OpenDuration <= 0 {
+		p.OpenDuration = defaultBreakerOpenDuration
+	}
+	return p
+}
+
+// breaker is the circuit breaker of a single clientsGroup.
+type breaker struct {
+	policy  BreakerPolicy
+	addr    string
+	shard   [contract.MaxRequestIdentifier + 1]*metricsGroup
+	metrics *breakerMetrics
+
+	// now is used instead of time.Now in tests.
+	now func() time.Time
+
+	state atomic.Int32
+
+	// probe is the token of the probe Target sent while half-open, or 0 if there is none.
+	// probeSeq issues the tokens.
+	probe    atomic.Uint64
+	probeSeq atomic.Uint64
+
+	// nextCheck is the unix nano time of the next window evaluation or of the transition to half-open.
+	nextCheck atomic.Int64
+
+	mu sync.Mutex
+	// requests and failures are the counter values at the start of the current window.
+	requests uint64
+	failures uint64
+}
+
+func newBreaker(policy BreakerPolicy, addr string, shard [contract.MaxRequestIdentifier + 1]*metricsGroup, registry *metricsRegistry) *breaker {
+	b := &breaker{
+		policy: policy,
+		addr:   addr,
+		shard:  shard,
+		now:    time.Now,
+	}
+	b.metrics = newBreakerMetrics(registry, addr, func() float64 {
+		return float64(b.state.Load())
+	})
+	b.requests, b.failures = b.counters()
+	b.nextCheck.Store(b.now().Add(policy.Window).UnixNano())
+	return b
+}
+
+// allow reports whether a Target may be sent to the shard. While the breaker is half-open,
+// the Target allowed is the probe, and allow returns a non-zero token for it that must be passed to observe
+// or release.
+func (b *breaker) allow() (ok bool, probe uint64) {
+	now := b.now()
+	if now.UnixNano() >= b.nextCheck.Load() && b.mu.TryLock() {
+		b.checkLocked(now)
+		b.mu.Unlock()
+	}
+
+	switch BreakerState(b.state.Load()) {
+	case BreakerClosed:
+		return true, 0
+	case BreakerHalfOpen:
+		token := b.probeSeq.Add(1)
+		if b.probe.CompareAndSwap(0, token) {
+			return true, token
+		}
+		return false, 0
+	default:
+		return false, 0
+	}
+}
+
+// release gives up the probe with token probe without sending it, so another probe is allowed.
+func (b *breaker) release(probe uint64) {
+	if probe != 0 {
+		b.probe.CompareAndSwap(probe, 0)
+	}
+}
+
+// observe completes the Target allowed with token probe. Only the probe sent while the breaker
+// is half-open changes its state; other Targets, including the ones sent before the breaker opened, are ignored.
+func (b *breaker) observe(ctx context.Context, probe uint64, statusCode base.RPCServerResponseCode, err error) {
+	if probe == 0 || b.probe.Load() != probe {
+		return
+	}
+	if err != nil && isContextError(err) && ctx.Err() != nil {
+		// The caller or the winning hedged request has cut the probe short, so it says nothing about the shard.
+		b.release(probe)
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if BreakerState(b.state.Load()) != BreakerHalfOpen || b.probe.Load() != probe {
+		return
+	}
+	now := b.now()
+	if err != nil && statusCode == base.RPCServerResponseCode_NETWORK_ERROR {
+		b.openLocked(now)
+	} else {
+		b.requests, b.failures = b.counters()
+		b.nextCheck.Store(now.Add(b.policy.Window).UnixNano())
+		b.setStateLocked(BreakerClosed)
+	}
+	b.probe.Store(0)
+}
+
+func (b *breaker) checkLocked(now time.Time) {
+	if now.UnixNano() < b.nextCheck.Load() {
+		return
+	}
+
+	switch BreakerState(b.state.Load()) {
+	case BreakerClosed:
+		requests, failures := b.counters()
+		dr, df := requests-b.requests, failures-b.failures
+		b.requests, b.failures = requests, failures
+		if dr >= uint64(b.policy.MinRequests) && float64(df) >= b.policy.FailureRatio*float64(dr) {
+			b.openLocked(now)
+			return
+		}
+		b.nextCheck.Store(now.Add(b.policy.Window).UnixNano())
+	case BreakerOpen:
+		b.probe.Store(0)
+		b.nextCheck.Store(now.Add(b.policy.OpenDuration).UnixNano())
+		b.setStateLocked(BreakerHalfOpen)
+	case BreakerHalfOpen:
+		// The probe is still running or was never sent; allow another one.
+		b.probe.Store(0)
+		b.nextCheck.Store(now.Add(b.policy.OpenDuration).UnixNano())
+	}
+}
+
+func (b *breaker) openLocked(now time.Time) {
+	b.nextCheck.Store(now.Add(b.policy.OpenDuration).UnixNano())
+	b.setStateLocked(BreakerOpen)
+}
+
+func (b *breaker) setStateLocked(to BreakerState) {
+	from := BreakerState(b.state.Swap(int32(to)))
+	if from == to {
+		return
+	}
+	b.metrics.transition(to)
+	if b.policy.OnStateChange != nil {
+		b.policy.OnStateChange(b.addr, from, to)
+	}
+}
+
+// counters returns the total number of requests and of timeouts and transport errors of the shard.
+func (b *breaker) counters() (requests, failures uint64) {
+	for _, m := range b.shard {
+		if m == nil {
+			continue
+		}
+		requests += m.request.Get()
+		failures += m.timeout.Get() + m.error.Get()
+	}
+	return requests, failures
+}
@@ -0,0 +1,186 @@
+package client
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	base "github.com/mygaru/dcr-sdk/gen/base1"
+	"github.com/mygaru/dcr-sdk/pkg/contract"
+)
+
+func TestBreakerOpensAndRecovers(t *testing.T) {
+	var transitions []BreakerState
+	b, now := newTestBreaker("breaker-recovers", BreakerPolicy{
+		FailureRatio: 0.5,
+		MinRequests:  10,
+		Window:       time.Second,
+		OpenDuration: 5 * time.Second,
+		OnStateChange: func(addr string, from, to BreakerState) {
+			transitions = append(transitions, to)
+		},
+	})
+
+	m := b.shard[contract.Target]
+	m.request.Add(10)
+	m.timeout.Add(3)
+	m.error.Add(3)
+	*now = now.Add(time.Second)
+	if ok, _ := b.allow(); ok {
+		t.Fatalf("expected the breaker to open after 60%% failures")
+	}
+
+	*now = now.Add(5 * time.Second)
+	ok, probe := b.allow()
+	if !ok || probe == 0 {
+		t.Fatalf("expected a probe to be allowed after OpenDuration")
+	}
+	if ok, _ := b.allow(); ok {
+		t.Fatalf("expected a single probe while half-open")
+	}
+
+	b.observe(context.Background(), probe, base.RPCServerResponseCode_OK, nil)
+	if ok, _ := b.allow(); !ok {
+		t.Fatalf("expected the breaker to close after a successful probe")
+	}
+
+	want := []BreakerState{BreakerOpen, BreakerHalfOpen, BreakerClosed}
+	if len(transitions) != len(want) {
+		t.Fatalf("expected transitions %v, got %v", want, transitions)
+	}
+	for i := range want {
+		if transitions[i] != want[i] {
+			t.Fatalf("expected transitions %v, got %v", want, transitions)
+		}
+	}
+}
+
+func TestBreakerReopensOnFailedProbe(t *testing.T) {
+	b, now := newTestBreaker("breaker-reopens", BreakerPolicy{
+		FailureRatio: 0.5,
+		MinRequests:  4,
+		Window:       time.Second,
+		OpenDuration: 5 * time.Second,
+	})
+
+	m := b.shard[contract.Report]
+	m.request.Add(4)
+	m.timeout.Add(4)
+	*now = now.Add(time.Second)
+	b.allow()
+
+	*now = now.Add(5 * time.Second)
+	ok, probe := b.allow()
+	if !ok {
+		t.Fatalf("expected a probe to be allowed after OpenDuration")
+	}
+	b.observe(context.Background(), probe, base.RPCServerResponseCode_NETWORK_ERROR, errTestAttempt)
+	if ok, _ := b.allow(); ok {
+		t.Fatalf("expected the breaker to open again after a failed probe")
+	}
+	if got := BreakerState(b.state.Load()); got != BreakerOpen {
+		t.Fatalf("expected open state, got %s", got)
+	}
+}
+
+func TestBreakerIgnoresLowTraffic(t *testing.T) {
+	b, now := newTestBreaker("breaker-low-traffic", BreakerPolicy{
+		FailureRatio: 0.5,
+		MinRequests:  10,
+	})
+
+	m := b.shard[contract.Target]
+	m.request.Add(5)
+	m.timeout.Add(5)
+	*now = now.Add(time.Second)
+	if ok, _ := b.allow(); !ok {
+		t.Fatalf("expected the breaker to stay closed below MinRequests")
+	}
+}
+
+func TestBreakerCountsOnlyTheProbe(t *testing.T) {
+	b, now := newTestBreaker("breaker-probe-only", BreakerPolicy{
+		FailureRatio: 0.5,
+		MinRequests:  4,
+		Window:       time.Second,
+		OpenDuration: 5 * time.Second,
+	})
+
+	m := b.shard[contract.Target]
+	m.request.Add(4)
+	m.timeout.Add(4)
+	*now = now.Add(time.Second)
+	b.allow()
+	*now = now.Add(5 * time.Second)
+	_, probe := b.allow()
+
+	// A Target sent before the breaker opened completes while the probe is running.
+	b.observe(context.Background(), 0, base.RPCServerResponseCode_NETWORK_ERROR, errTestAttempt)
+	if got := BreakerState(b.state.Load()); got != BreakerHalfOpen {
+		t.Fatalf("expected a Target other than the probe to be ignored, got %s", got)
+	}
+
+	// The probe loses to a hedged request and is canceled.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	b.observe(ctx, probe, base.RPCServerResponseCode_NETWORK_ERROR, ctx.Err())
+	if got := BreakerState(b.state.Load()); got != BreakerHalfOpen {
+		t.Fatalf("expected a canceled probe to be inconclusive, got %s", got)
+	}
+
+	ok, probe := b.allow()
+	if !ok {
+		t.Fatalf("expected another probe after an inconclusive one")
+	}
+	b.observe(context.Background(), probe, base.RPCServerResponseCode_OK, nil)
+	if got := BreakerState(b.state.Load()); got != BreakerClosed {
+		t.Fatalf("expected the breaker to close after a successful probe, got %s", got)
+	}
+}
+
+func TestTargetSkipsShardWithOpenBreaker(t *testing.T) {
+	var badHits, goodHits atomic.Int64
+	bad := startTestServer(t, func(ctx *contract.RequestCtx) {
+		badHits.Add(1)
+		writeTestTargetResponse(ctx, "0400000000000001")
+	})
+	good := startTestServer(t, func(ctx *contract.RequestCtx) {
+		goodHits.Add(1)
+		writeTestTargetResponse(ctx, "0401000000000001")
+	})
+
+	sc := NewClient(&Configuration{
+		Addrs:                          bad + "," + good,
+		DisableAuth:                    true,
+		MaximumSimultaneousConnections: 1,
+		DNSRefreshInterval:             -1,
+		Breaker:                        BreakerPolicy{FailureRatio: 0.5, MinRequests: 1, OpenDuration: time.Hour},
+	}, nil)
+
+	b := sc.shardSet().clients[0].breaker
+	b.mu.Lock()
+	b.openLocked(time.Now())
+	b.mu.Unlock()
+
+	for i := 0; i < 6; i++ {
+		if _, _, err := sc.Target(testTargetRequest()); err != nil {
+			t.Fatalf("target %d: %v", i, err)
+		}
+	}
+	if got := badHits.Load(); got != 0 {
+		t.Fatalf("expected no requests to the shard with an open breaker, got %d", got)
+	}
+	if got := goodHits.Load(); got != 6 {
+		t.Fatalf("expected all requests on the healthy shard, got %d", got)
+	}
+}
+
+func newTestBreaker(addr string, policy BreakerPolicy) (*breaker, *time.Time) {
+	now := time.Now()
+	registry := newMetricsRegistry(MetricsConfig{})
+	b := newBreaker(normalizeBreakerPolicy(policy), addr, buildShardMetrics(registry, addr), registry)
+	b.now = func() time.Time { return now }
+	b.nextCheck.Store(now.Add(b.policy.Window).UnixNano())
+	return b, &now
+}
@@ -0,0 +1,153 @@
+package client
+
+import (
+	"log/slog"
+	"time"
+)
+
+const (
+	defaultCertificateReloadInterval = time.Minute
+	defaultRecycleDuration           = time.Minute
+)
+
+// CertificateReloader reloads the mTLS client certificate presented by the tls.Config of the client,
+// typically through tls.Config.GetClientCertificate. dcr.CertificateReloader implements it.
+type CertificateReloader interface {
+	// Reload loads the certificate again and reports whether it has changed.
+	// On error the current certificate must be kept.
+	Reload() (changed bool, err error)
+}
+
+// CertificateReloadPolicy configures the hot reload of the mTLS client certificate.
+//
+// Connections present the client certificate only when they are dialed, so after the certificate
+// has changed the open connections are recycled with ShardedClient.RecycleConnections.
+type CertificateReloadPolicy struct {
+	// Reloader is polled every Interval. The reload is disabled if Reloader is nil.
+	Reloader CertificateReloader
+
+	// Interval is how often Reloader is polled.
+	// By default 1 minute.
+	Interval time.Duration
+
+	// RecycleDuration is the time over which the open connections are recycled once the certificate has changed.
+	// By default 1 minute.
+	RecycleDuration time.Duration
+}
+
+func normalizeCertificateReloadPolicy(p CertificateReloadPolicy) CertificateReloadPolicy {
+	if p.Reloader == nil {
+		return CertificateReloadPolicy{}
+	}
+	if p.Interval <= 0 {
+		p.Interval = defaultCertificateReloadInterval
+	}
+	if p.RecycleDuration <= 0 {
+		p.RecycleDuration = defaultRecycleDuration
+	}
+	return p
+}
+
+// reloadCertificates polls the certificate reloader until the client is closed.
+func (sc *ShardedClient) reloadCertificates(policy CertificateReloadPolicy) {
+	ticker := time.NewTicker(policy.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-sc.done:
+			return
+		}
+
+		changed, err := policy.Reloader.Reload()
+		if err != nil {
+			sc.log.log(slog.LevelWarn, "certificate reload failed", "", slog.Any("error", err))
+			continue
+		}
+		if changed {
+			sc.log.log(slog.LevelInfo, "certificate reloaded", "", slog.Duration("recycle_duration", policy.RecycleDuration))
+			sc.RecycleConnections(policy.RecycleDuration)
+		}
+	}
+}
+
+// RecycleConnections closes the connections open now one by one, evenly spread over d, so they are dialed
+// again without a reconnect storm, e.g. to present a renewed client certificate. A connection with requests
+// in flight is closed by the request that drains it, but after MaxRequestDuration at the latest,
+// and doesn't hold up the connections after it.
+//
+// RecycleConnections returns immediately. A later call replaces the recycling started by an earlier one.
+func (sc *ShardedClient) RecycleConnections(d time.Duration) {
+	var conns []*trackedConn
+	for _, shard := range sc.shardSet().clients {
+		conns = append(conns, shard.dialer.openConns()...)
+	}
+
+	stop := make(chan struct{})
+	sc.recycleMu.Lock()
+	if sc.recycleStop != nil {
+		close(sc.recycleStop)
+	}
+	sc.recycleStop = stop
+	sc.recycleMu.Unlock()
+
+	go sc.recycle(conns, d, stop)
+}
+
+func (sc *ShardedClient) recycle(conns []*trackedConn, d time.Duration, stop <-chan struct{}) {
+	if len(conns) == 0 {
+		return
+	}
+	ticker := time.NewTicker(max(d/time.Duration(len(conns)), 1))
+	defer ticker.Stop()
+
+	for _, conn := range conns {
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		case <-sc.done:
+			return
+		}
+
+		if conn.dialer.isOpen(conn) {
+			sc.recycleConn(conn)
+		}
+	}
+}
+
+// recycleConn closes conn now if it is idle. Otherwise it leaves conn to the request that drains it,
+// see client.closeRecycledConn, and closes it after MaxRequestDuration if that doesn't happen.
+func (sc *ShardedClient) recycleConn(conn *trackedConn) {
+	if conn.owner == nil || conn.isIdle() {
+		conn.closeRecycled(sc.log)
+		return
+	}
+
+	conn.owner.recycling.Store(conn)
+	if conn.isIdle() {
+		// The last request completed before the connection was marked.
+		conn.owner.closeRecycledConn()
+		return
+	}
+	time.AfterFunc(sc.MaxRequestDuration, func() {
+		if conn.owner.recycling.CompareAndSwap(conn, nil) {
+			conn.closeRecycled(sc.log)
+		}
+	})
+}
+
+func (c *trackedConn) closeRecycled(log *eventLogger) {
+	log.log(slog.LevelInfo, "connection recycled", c.dialer.addr, slog.String("remote_addr", c.addr))
+	_ = c.Close()
+}
+
+// closeRecycledConn closes the connection marked by ShardedClient.recycleConn once no request is in flight over it.
+func (c *client) closeRecycledConn() {
+	conn := c.recycling.Load()
+	if conn == nil || !conn.isIdle() || !c.recycling.CompareAndSwap(conn, nil) {
+		return
+	}
+	conn.closeRecycled(c.log)
+}
@@ -0,0 +1,142 @@
+package client
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	base "github.com/mygaru/dcr-sdk/gen/base1"
+	"github.com/mygaru/dcr-sdk/pkg/contract"
+)
+
+type testCertificateReloader struct {
+	changed atomic.Bool
+	reloads atomic.Int64
+}
+
+func (r *testCertificateReloader) Reload() (bool, error) {
+	r.reloads.Add(1)
+	return r.changed.Swap(false), nil
+}
+
+func TestCertificateReloadRecyclesConnections(t *testing.T) {
+	addr := startTestServer(t, func(ctx *contract.RequestCtx) {
+		ctx.Response.SetStatusCode(base.RPCServerResponseCode_OK)
+	})
+	reloader := &testCertificateReloader{}
+	sc := NewClient(&Configuration{
+		Addrs:                          addr,
+		DisableAuth:                    true,
+		MaximumSimultaneousConnections: 2,
+		DNSRefreshInterval:             -1,
+		CertificateReload: CertificateReloadPolicy{
+			Reloader:        reloader,
+			Interval:        10 * time.Millisecond,
+			RecycleDuration: 20 * time.Millisecond,
+		},
+	}, nil)
+	defer sc.Close()
+
+	for i := 0; i < 2; i++ {
+		if _, _, err := sc.Target(testTargetRequest()); err != nil {
+			t.Fatalf("target: %v", err)
+		}
+	}
+	if got := sc.Reconnects(); got != 2 {
+		t.Fatalf("expected 2 dials, got %d", got)
+	}
+
+	// Unchanged certificates don't recycle connections.
+	for reloader.reloads.Load() < 3 {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if got := len(sc.shardSet().clients[0].dialer.openConns()); got != 2 {
+		t.Fatalf("expected the connections to stay open, got %d open", got)
+	}
+
+	reloader.changed.Store(true)
+	deadline := time.Now().Add(2 * time.Second)
+	for len(sc.shardSet().clients[0].dialer.openConns()) != 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the connections to be recycled")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, _, err := sc.Target(testTargetRequest()); err != nil {
+			t.Fatalf("target after recycling: %v", err)
+		}
+	}
+	if got := sc.Reconnects(); got != 4 {
+		t.Fatalf("expected the connections to be dialed again, got %d dials", got)
+	}
+}
+
+func TestRecycleDoesNotWaitForBusyConnection(t *testing.T) {
+	release := make(chan struct{})
+	var blocked atomic.Bool
+	addr := startTestServer(t, func(ctx *contract.RequestCtx) {
+		if ctx.Request.GetName() == contract.Target && blocked.Load() {
+			<-release
+		}
+		ctx.Response.SetStatusCode(base.RPCServerResponseCode_OK)
+	})
+	sc := NewClient(&Configuration{
+		Addrs:                          addr,
+		DisableAuth:                    true,
+		MaximumSimultaneousConnections: 2,
+		MaxRequestDuration:             5 * time.Second,
+		DNSRefreshInterval:             -1,
+	}, nil)
+	defer sc.Close()
+
+	if err := sc.Warmup(context.Background()); err != nil {
+		t.Fatalf("warmup: %v", err)
+	}
+
+	blocked.Store(true)
+	errCh := make(chan error, 1)
+	go func() {
+		_, _, err := sc.Target(testTargetRequest())
+		errCh <- err
+	}()
+	dialer := sc.shardSet().clients[0].dialer
+	var busy, idle *trackedConn
+	for busy == nil {
+		for _, conn := range dialer.openConns() {
+			if conn.isIdle() {
+				idle = conn
+			} else {
+				busy = conn
+			}
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// The busy connection comes first, so the idle one is only recycled in time if the schedule doesn't wait for it.
+	go sc.recycle([]*trackedConn{busy, idle}, 20*time.Millisecond, make(chan struct{}))
+	deadline := time.Now().Add(time.Second)
+	for dialer.isOpen(idle) {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the idle connection to be recycled while the busy one has a request in flight")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if !dialer.isOpen(busy) {
+		t.Fatalf("expected the busy connection to stay open until its request completes")
+	}
+
+	close(release)
+	if err := <-errCh; err != nil {
+		t.Fatalf("expected the request in flight to complete, got %v", err)
+	}
+	deadline = time.Now().Add(time.Second)
+	for dialer.isOpen(busy) {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the busy connection to be recycled once drained")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
@@ -1,9 +1,11 @@
 package client
 
 import (
+	"context"
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"log/slog"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -15,31 +17,81 @@ import (
 	"google.golang.org/protobuf/proto"
 )
 
-var ErrorUnauthorized = errors.New("unauthorized")
-
 type client struct {
 
-	// JwtToken is a byte slice used for storing JSON Web Token (JWT) data associated with the client.
-	JwtToken []byte
+	// tokens provides the JWT token for contract.Auth.
+	tokens TokenSource
 
 	// disableAuth skips the legacy contract.Auth request for mTLS-authenticated connections.
 	disableAuth bool
 
+	// authOnDial is set from Configuration.AuthOnDial.
+	authOnDial bool
+
+	// pinnedServerID is the server ID from ShardConfig.ServerID; zero if not pinned.
+	pinnedServerID uint16
+
+	// onServerID is called with the connection when its server ID changes; it may be nil.
+	onServerID func(c *client)
+
+	// shard is the shard the connection belongs to; it is nil for connections created outside NewClient.
+	shard *clientsGroup
+
+	// routedServerID is the server ID the connection is listed under in the routing table. Guarded by routes.mu.
+	routedServerID uint16
+
+	// serverInfo is the last HelloResponse received over the connection.
+	serverInfo atomic.Pointer[contract.HelloResponse]
+
 	// maxRequestDuration specifies the maximum duration allowed for a single request to complete before timing out.
 	maxRequestDuration time.Duration
 
 	// metricGroups is an array of metricsGroup pointers, indexed by request identifiers, for tracking metrics of RPC calls.
 	metricGroups [contract.MaxRequestIdentifier + 1]*metricsGroup
 
+	// addr is the address of the shard the client belongs to.
+	addr string
+
 	// single connection RPC client
 	c *fastrpc.Client
 
+	// interceptors run in order around every unary RPC sent over c.
+	interceptors []UnaryInterceptor
+
+	// log writes connection, auth and request failure events; it may be nil.
+	log *eventLogger
+
+	// traces are the ClientTraces of the requests in flight over c.
+	traces traceSet
+
+	// remoteAddr is the resolved address c was last dialed to.
+	remoteAddr atomic.Pointer[string]
+
+	// conn is the connection c was last dialed to; nil before the first dial or without a dnsDialer.
+	conn atomic.Pointer[trackedConn]
+
+	// latency is the moving average of request latencies over this connection.
+	latency ewma
+
+	// shardLatency is the moving average of request latencies of the shard the client belongs to; it may be nil.
+	shardLatency *ewma
+
+	// recycling is the connection RecycleConnections closes once its requests in flight complete; nil if none.
+	recycling atomic.Pointer[trackedConn]
+
 	connGen atomic.Uint64
 	mu      sync.Mutex
 
 	authedGen uint64
 	authId    uuid.UUID
-	serverID  uint16
+
+	// authSeq counts the successful contract.Auth exchanges, so a request rejected with UNAUTHORIZED
+	// can tell whether the connection has been authenticated again since it was sent.
+	authSeq atomic.Uint64
+
+	// serverID is the ID of the server behind the connection, learned by the connection handshake
+	// or from the first tracking id it issued. It is written while requests are in flight.
+	serverID atomic.Uint32
 }
 
 func (c *client) ensureAuthForCurrentConn() error {
@@ -51,15 +103,46 @@ func (c *client) ensureAuthForCurrentConn() error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	return c.ensureAuthForCurrentConnLocked()
+	return c.ensureAuthForCurrentConnLocked(context.Background())
 }
 
-func (c *client) ensureAuthForCurrentConnLocked() error {
+// ensureAuthForCurrentConnLocked runs the connection handshake unless it is done for the current connection:
+// the contract.Hello exchange followed by contract.Auth unless auth is disabled.
+func (c *client) ensureAuthForCurrentConnLocked(ctx context.Context) error {
 	gen := c.connGen.Load()
 	if gen > 0 && gen == atomic.LoadUint64(&c.authedGen) {
 		return nil
 	}
 
+	if err := c.hello(ctx); err != nil {
+		c.log.log(slog.LevelWarn, "hello failed", c.addr,
+			slog.Uint64("conn_gen", gen),
+			slog.Any("error", err))
+		return err
+	}
+
+	if !c.disableAuth {
+		err := c.auth(ctx)
+		if err != nil {
+			c.log.log(slog.LevelWarn, "auth failed", c.addr,
+				slog.Int("server_id", int(c.GetServerID())),
+				slog.Uint64("conn_gen", gen),
+				slog.Any("error", err))
+			return err
+		}
+		c.log.log(slog.LevelDebug, "authenticated", c.addr,
+			slog.Int("server_id", int(c.GetServerID())),
+			slog.Uint64("conn_gen", gen))
+		c.authSeq.Add(1)
+	}
+
+	atomic.StoreUint64(&c.authedGen, c.connGen.Load())
+	return nil
+}
+
+// auth runs the contract.Auth exchange through the interceptors with ctx marked
+// by withInternalCall. ctx only carries values; the exchange is bounded by maxRequestDuration.
+func (c *client) auth(ctx context.Context) error {
 	req := contract.AcquireRequest()
 	resp := contract.AcquireResponse()
 	defer func() {
@@ -67,20 +150,30 @@ func (c *client) ensureAuthForCurrentConnLocked() error {
 		contract.ReleaseResponse(resp)
 	}()
 
+	token, err := c.tokens.Token(ctx)
+	if err != nil {
+		return fmt.Errorf("auth is failed: cannot get token: %w", err)
+	}
+
 	req.SetName(contract.Auth)
-	req.Append(c.JwtToken)
+	req.Append(token)
 
-	err := c.c.DoDeadline(req, resp, time.Now().Add(c.maxRequestDuration))
+	statusCode, err := intercept(withInternalCall(ctx), c.interceptors, contract.Auth, nil, nil, func(context.Context, contract.RPCRegister, proto.Message, proto.Message) (base.RPCServerResponseCode, error) {
+		if err := c.c.DoDeadline(req, resp, time.Now().Add(c.maxRequestDuration)); err != nil {
+			return base.RPCServerResponseCode_NETWORK_ERROR, err
+		}
+		return resp.GetStatusCode(), nil
+	})
 	if err != nil {
-		return fmt.Errorf("auth is failed: %v", err)
+		return fmt.Errorf("auth is failed: %w", err)
 	}
 
-	if resp.GetStatusCode() == base.RPCServerResponseCode_UNAUTHORIZED {
-		return ErrorUnauthorized
+	if statusCode == base.RPCServerResponseCode_UNAUTHORIZED {
+		return ErrUnauthorized
 	}
 
-	if resp.GetStatusCode() != base.RPCServerResponseCode_OK {
-		return fmt.Errorf("auth is failed, err = response status code is not RPCServerResponseCode_OK, got = %s", resp.GetStatusCode().String())
+	if statusCode != base.RPCServerResponseCode_OK {
+		return fmt.Errorf("auth is failed, err = response status code is not RPCServerResponseCode_OK, got = %s", statusCode.String())
 	}
 
 	if len(resp.Value()) != 18 {
@@ -94,39 +187,60 @@ func (c *client) ensureAuthForCurrentConnLocked() error {
 		return fmt.Errorf("auth is failed, err = parse uid from response is failed: %v", err)
 	}
 
-	atomic.StoreUint64(&c.authedGen, c.connGen.Load())
 	c.authId = uid
-	c.serverID = binary.LittleEndian.Uint16(buf[:2])
+	c.setServerID(binary.LittleEndian.Uint16(buf[:2]))
 
 	return nil
 }
 
-func (c *client) ensureAuthForCurrentConnDeadline() error {
+// ensureAuthForCurrentConnDeadline authenticates the current connection if needed,
+// giving up when the request deadline derived from ctx expires or ctx is canceled.
+// If another handshake over the connection is running, it fails fast with fastrpc.ErrTimeout,
+// unless AuthOnDial is set, in which case it waits for that handshake.
+// An auth exchange that is already running keeps running in the background after the caller gives up.
+func (c *client) ensureAuthForCurrentConnDeadline(ctx context.Context) error {
 	if c.isAuthForCurrentConn() {
 		return nil
 	}
 
-	if !c.mu.TryLock() {
+	locked := c.mu.TryLock()
+	if !locked && !c.authOnDial {
 		return fastrpc.ErrTimeout
 	}
 
 	errCh := make(chan error, 1)
 	go func() {
+		if !locked {
+			// With AuthOnDial the handshake runs right after every dial, so the request waits for it
+			// instead of failing fast.
+			c.mu.Lock()
+		}
 		defer c.mu.Unlock()
-		errCh <- c.ensureAuthForCurrentConnLocked()
+		errCh <- c.ensureAuthForCurrentConnLocked(context.WithoutCancel(ctx))
 	}()
 
-	timer := time.NewTimer(c.maxRequestDuration)
+	timer := time.NewTimer(time.Until(c.requestDeadline(ctx)))
 	defer timer.Stop()
 
 	select {
 	case err := <-errCh:
 		return err
 	case <-timer.C:
-		return fastrpc.ErrTimeout
+		return timeoutError(ctx)
+	case <-ctx.Done():
+		return ctx.Err()
 	}
 }
 
+// requestDeadline returns the deadline for a request issued with ctx.
+// A deadline set on ctx overrides maxRequestDuration.
+func (c *client) requestDeadline(ctx context.Context) time.Time {
+	if deadline, ok := ctx.Deadline(); ok {
+		return deadline
+	}
+	return time.Now().Add(c.maxRequestDuration)
+}
+
 func (c *client) isAuthForCurrentConn() bool {
 	gen := c.connGen.Load()
 	return gen > 0 && gen == atomic.LoadUint64(&c.authedGen)
@@ -134,7 +248,7 @@ func (c *client) isAuthForCurrentConn() bool {
 
 // GetServerID returns the identifier of the server currently associated with the client.
 func (c *client) GetServerID() uint16 {
-	return c.serverID
+	return uint16(c.serverID.Load())
 }
 
 // GetUUID returns the UUID associated with the current authenticated client connection.
@@ -142,6 +256,16 @@ func (c *client) GetUUID() uuid.UUID {
 	return c.authId
 }
 
+// PendingRequests returns the number of requests sent over the connection and still waiting for a response.
+func (c *client) PendingRequests() int {
+	return c.c.PendingRequests()
+}
+
+// Latency returns the moving average of request latencies over the connection.
+func (c *client) Latency() time.Duration {
+	return c.latency.value()
+}
+
 // Reconnects returns the number of reconnections the client has performed by reading the connection generation counter.
 func (c *client) Reconnects() uint64 {
 	return c.connGen.Load()
@@ -150,43 +274,168 @@ func (c *client) Reconnects() uint64 {
 // doUnary sends a unary RPC request with a given proto message and request identifier.
 //
 // Timing model:
-//   - If the underlying fastrpc connection is already open and authenticated for
-//     the current connection generation, the call goes straight to
-//     fastrpc.Client.DoDeadline with deadline = now + maxRequestDuration.
-//     fastrpc does not enforce this deadline with a per-request timer; it relies
-//     on an internal stale-request checker. Because of that, the observed call
-//     duration may exceed maxRequestDuration by up to the checker wake-up delay
-//     (currently up to about 1s in fastrpc).
+//   - The request deadline is taken from ctx when it has one, otherwise it is
+//     now + maxRequestDuration.
 //   - If the connection is new or was reconnected, doUnary must authenticate the
 //     connection first. That path may include TCP dial and the fastrpc protocol
 //     handshake (fastrpc has its own 3s handshake deadline). To prevent callers
 //     from waiting for that slow path, doUnary wraps authentication in its own
-//     timer and returns after maxRequestDuration with NETWORK_ERROR/timeout if
+//     timer and returns at the request deadline with NETWORK_ERROR/timeout if
 //     auth doesn't finish in time.
 //   - Only one goroutine is allowed to run the auth/reconnect path for a client.
 //     Concurrent callers that arrive while auth is already in progress fail fast
-//     with NETWORK_ERROR/timeout instead of each waiting for maxRequestDuration.
-//   - After successful authentication, the main RPC request is still handled by
-//     fastrpc.DoDeadline as described in the first case, so the strict SDK-level
-//     timeout currently applies to the auth/reconnect path only, not to the main
-//     request on an already authenticated connection.
-func (c *client) doUnary(req, resp proto.Message, reqn contract.RPCRegister) (proto.Message, base.RPCServerResponseCode, error) {
-	if !c.disableAuth {
-		if err := c.ensureAuthForCurrentConnDeadline(); err != nil {
-			if errors.Is(err, fastrpc.ErrTimeout) {
-				return nil, base.RPCServerResponseCode_NETWORK_ERROR, err
+//     with NETWORK_ERROR/timeout instead of each waiting for the deadline.
+//   - The main RPC request is passed to fastrpc.Client.DoDeadline. fastrpc does
+//     not enforce the deadline with a per-request timer; it relies on an internal
+//     stale-request checker that may wake up to about 1s late. doDeadline
+//     therefore waits for the response with its own timer and returns
+//     NETWORK_ERROR/timeout exactly at the deadline. The abandoned fastrpc call
+//     keeps running in the background until fastrpc gives up on it too; responses
+//     that arrive after the caller gave up are counted as late.
+//   - Cancellation of ctx is honoured on both paths: doUnary returns immediately
+//     with NETWORK_ERROR and an error wrapping ctx.Err().
+//
+// Failed requests return an *RPCError, with INVALID_REQUEST if req can't be marshaled,
+// unless an interceptor returns an error of its own.
+func (c *client) doUnary(ctx context.Context, req, resp proto.Message, reqn contract.RPCRegister) (proto.Message, base.RPCServerResponseCode, error) {
+	if len(c.interceptors) == 0 {
+		return c.invoke(ctx, req, resp, reqn)
+	}
+
+	statusCode, err := intercept(ctx, c.interceptors, reqn, req, resp, func(ctx context.Context, method contract.RPCRegister, req, resp proto.Message) (base.RPCServerResponseCode, error) {
+		_, statusCode, err := c.invoke(ctx, req, resp, method)
+		return statusCode, err
+	})
+	if err != nil {
+		return nil, statusCode, err
+	}
+	return resp, statusCode, nil
+}
+
+// invoke sends req over the current connection, running the ClientTrace attached to ctx if any.
+func (c *client) invoke(ctx context.Context, req, resp proto.Message, reqn contract.RPCRegister) (proto.Message, base.RPCServerResponseCode, error) {
+	trace := ContextClientTrace(ctx)
+	if trace == nil {
+		return c.send(ctx, req, resp, reqn, nil)
+	}
+
+	c.traces.add(trace)
+	defer c.traces.remove(trace)
+
+	if trace.GotConn != nil {
+		info := GotConnInfo{
+			Addr:            c.addr,
+			ServerID:        c.GetServerID(),
+			Reused:          c.connGen.Load() > 0,
+			PendingRequests: c.PendingRequests(),
+		}
+		if remoteAddr := c.remoteAddr.Load(); remoteAddr != nil {
+			info.RemoteAddr = *remoteAddr
+		}
+		trace.GotConn(info)
+	}
+
+	res, statusCode, err := c.send(ctx, req, resp, reqn, trace)
+	if trace.GotResponse != nil {
+		trace.GotResponse(GotResponseInfo{Method: reqn, ServerID: c.GetServerID(), StatusCode: statusCode, Err: err})
+	}
+	return res, statusCode, err
+}
+
+// send sends req over the current connection, authenticating it first if needed.
+//
+// A request rejected with UNAUTHORIZED is sent once more after the connection is authenticated again
+// with a fresh token from the TokenSource, since the token may have expired or been revoked after
+// the connection was authenticated. If that fails, the UNAUTHORIZED error is returned.
+func (c *client) send(ctx context.Context, req, resp proto.Message, reqn contract.RPCRegister, trace *ClientTrace) (proto.Message, base.RPCServerResponseCode, error) {
+	if err := ctx.Err(); err != nil {
+		c.countError(reqn, err, nil)
+		return nil, base.RPCServerResponseCode_NETWORK_ERROR, c.rpcError(reqn, base.RPCServerResponseCode_NETWORK_ERROR, "", err)
+	}
+
+	if err := c.handshake(ctx, trace); err != nil {
+		statusCode := handshakeStatusCode(err)
+		return nil, statusCode, c.rpcError(reqn, statusCode, "", err)
+	}
+
+	authSeq := c.authSeq.Load()
+	res, statusCode, err := c.sendRequest(ctx, req, resp, reqn)
+	if statusCode == base.RPCServerResponseCode_UNAUTHORIZED && !c.disableAuth && c.reauth(ctx, authSeq, trace) == nil {
+		res, statusCode, err = c.sendRequest(ctx, req, resp, reqn)
+	}
+	return res, statusCode, err
+}
+
+// handshake runs the connection handshake unless it is done for the current connection.
+// With auth disabled it only starts contract.Hello in the background.
+func (c *client) handshake(ctx context.Context, trace *ClientTrace) error {
+	if c.disableAuth {
+		c.helloInBackground()
+		return nil
+	}
+	if c.isAuthForCurrentConn() {
+		return nil
+	}
+
+	if trace != nil && trace.AuthStart != nil {
+		trace.AuthStart()
+	}
+	err := c.ensureAuthForCurrentConnDeadline(ctx)
+	if trace != nil && trace.AuthDone != nil {
+		trace.AuthDone(c.GetServerID(), err)
+	}
+	return err
+}
+
+// reauth authenticates the connection again after a request sent when authSeq auth exchanges had succeeded
+// was rejected with UNAUTHORIZED, unless another rejected request has already done it.
+//
+// The server doesn't accept contract.Auth over an authenticated connection, so the connection is closed first
+// and the handshake runs over a new one.
+func (c *client) reauth(ctx context.Context, authSeq uint64, trace *ClientTrace) error {
+	var closedGen uint64
+	if c.authSeq.Load() == authSeq {
+		gen := atomic.LoadUint64(&c.authedGen)
+		if gen != 0 && atomic.CompareAndSwapUint64(&c.authedGen, gen, 0) {
+			c.log.log(slog.LevelInfo, "reauthenticating", c.addr,
+				slog.Int("server_id", int(c.GetServerID())),
+				slog.Uint64("conn_gen", gen))
+			if c.closeConn(gen) {
+				closedGen = gen
 			}
-			return nil, base.RPCServerResponseCode_UNAUTHORIZED, err
 		}
 	}
 
-	raw, err := proto.Marshal(req)
-	if 0 == len(raw) {
-		return nil, base.RPCServerResponseCode_UNKNOWN, fmt.Errorf("marshal request %s is failed: empty request", reqn)
+	err := c.handshake(ctx, trace)
+	if err != nil && closedGen != 0 && c.connGen.Load() == closedGen && ctx.Err() == nil {
+		// The handshake was picked up by the closed connection before fastrpc noticed the close.
+		// fastrpc has torn that connection down by the time it fails the handshake, so it dials a new one now.
+		err = c.handshake(ctx, trace)
 	}
+	return err
+}
+
+// closeConn closes the connection with generation gen, so the next request dials a new one.
+// It reports whether the connection was closed.
+func (c *client) closeConn(gen uint64) bool {
+	conn := c.conn.Load()
+	if conn == nil || c.connGen.Load() != gen {
+		return false
+	}
+	_ = conn.Close()
+	return true
+}
 
+// sendRequest sends req over the current connection, which must be authenticated.
+func (c *client) sendRequest(ctx context.Context, req, resp proto.Message, reqn contract.RPCRegister) (proto.Message, base.RPCServerResponseCode, error) {
+	raw, err := proto.Marshal(req)
 	if nil != err {
-		return nil, base.RPCServerResponseCode_UNKNOWN, fmt.Errorf("marshal request %s is failed: %+v", reqn, err)
+		return nil, base.RPCServerResponseCode_INVALID_REQUEST, c.rpcError(reqn, base.RPCServerResponseCode_INVALID_REQUEST, "",
+			fmt.Errorf("marshal request %s is failed: %w", reqn, err))
+	}
+	if 0 == len(raw) {
+		return nil, base.RPCServerResponseCode_INVALID_REQUEST, c.rpcError(reqn, base.RPCServerResponseCode_INVALID_REQUEST, "",
+			fmt.Errorf("marshal request %s is failed: empty request", reqn))
 	}
 
 	metricGroup := c.metricGroups[reqn]
@@ -194,7 +443,12 @@ func (c *client) doUnary(req, resp proto.Message, reqn contract.RPCRegister) (pr
 	st := time.Now()
 	rpcReq := contract.AcquireRequest()
 	rpcResp := contract.AcquireResponse()
+	abandoned := false
 	defer func() {
+		if abandoned {
+			// rpcReq and rpcResp are still owned by the fastrpc call started in doDeadline.
+			return
+		}
 		contract.ReleaseRequest(rpcReq)
 		contract.ReleaseResponse(rpcResp)
 	}()
@@ -203,18 +457,24 @@ func (c *client) doUnary(req, resp proto.Message, reqn contract.RPCRegister) (pr
 	rpcReq.Append(raw)
 
 	metricGroup.request.Inc()
-	err = c.c.DoDeadline(rpcReq, rpcResp, st.Add(c.maxRequestDuration))
+	abandoned, err = c.doDeadline(ctx, rpcReq, rpcResp, c.requestDeadline(ctx), metricGroup)
 	metricGroup.duration.UpdateDuration(st)
+	if !errors.Is(err, context.Canceled) {
+		c.observeLatency(time.Since(st))
+	}
+	if abandoned {
+		c.countError(reqn, err, nil)
+		return nil, base.RPCServerResponseCode_NETWORK_ERROR, c.rpcError(reqn, base.RPCServerResponseCode_NETWORK_ERROR, "", err)
+	}
 	if err != nil {
 		c.countError(reqn, err, rpcResp)
-		return nil, base.RPCServerResponseCode_NETWORK_ERROR, fmt.Errorf("error when calling '%s': %s", reqn, err)
+		return nil, base.RPCServerResponseCode_NETWORK_ERROR, c.rpcError(reqn, base.RPCServerResponseCode_NETWORK_ERROR, "", err)
 	}
 
 	statusCode := rpcResp.GetStatusCode()
 	if statusCode != base.RPCServerResponseCode_OK {
-		err = fmt.Errorf("RPC[%q]: %s", statusCode.String(), rpcResp.Value())
 		c.countError(reqn, nil, rpcResp)
-		return nil, statusCode, err
+		return nil, statusCode, c.rpcError(reqn, statusCode, string(rpcResp.Value()), nil)
 	}
 
 	if nil != resp {
@@ -222,28 +482,168 @@ func (c *client) doUnary(req, resp proto.Message, reqn contract.RPCRegister) (pr
 	}
 
 	if nil != err {
-		return nil, statusCode, fmt.Errorf("unmarshal response of request '%s' is failed: %w", reqn, err)
+		return nil, statusCode, c.rpcError(reqn, statusCode, "", fmt.Errorf("unmarshal response is failed: %w", err))
 	}
 	metricGroup.success.Inc()
 	return resp, statusCode, nil
 
 }
 
-// countError increments error-related metrics for the given request and logs unhandled errors with request and server details.
-func (c *client) countError(reqn contract.RPCRegister, err error, resp *contract.Response) {
-	metricGroup := c.metricGroups[reqn]
+const (
+	callRunning uint32 = iota
+	callDone
+	callAbandoned
+)
 
-	if nil == err {
-		metricGroup.failed.Inc()
+// pendingCall hands the result of a fastrpc call over to the goroutine waiting for it.
+type pendingCall struct {
+	req         *contract.Request
+	resp        *contract.Response
+	metricGroup *metricsGroup
+	trace       *ClientTrace
+	done        chan error
+	state       atomic.Uint32
+}
+
+// doDeadline runs fastrpc.Client.DoDeadline in a separate goroutine and waits for it
+// until the deadline expires or ctx is done, whichever happens first.
+//
+// When the caller gives up, doDeadline returns abandoned = true and fastrpc.ErrTimeout or ctx.Err().
+// Ownership of rpcReq and rpcResp passes to the background goroutine, which releases them
+// once fastrpc completes the call.
+func (c *client) doDeadline(ctx context.Context, rpcReq *contract.Request, rpcResp *contract.Response, deadline time.Time, metricGroup *metricsGroup) (abandoned bool, err error) {
+	call := acquirePendingCall()
+	call.req = rpcReq
+	call.resp = rpcResp
+	call.metricGroup = metricGroup
+	if trace := ContextClientTrace(ctx); trace != nil && trace.WroteRequest != nil {
+		call.trace = trace
+	}
+
+	go c.runPendingCall(call, deadline)
+
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+
+	var giveUpErr error
+	select {
+	case err = <-call.done:
+		releasePendingCall(call)
+		return false, err
+	case <-timer.C:
+		giveUpErr = timeoutError(ctx)
+	case <-ctx.Done():
+		giveUpErr = ctx.Err()
+	}
+
+	if call.state.CompareAndSwap(callRunning, callAbandoned) {
+		return true, giveUpErr
+	}
+	// fastrpc has completed the call concurrently, so its result is ready.
+	err = <-call.done
+	releasePendingCall(call)
+	return false, err
+}
+
+func (c *client) runPendingCall(call *pendingCall, deadline time.Time) {
+	var req fastrpc.RequestWriter = call.req
+	if call.trace != nil {
+		req = &tracedRequest{Request: call.req, trace: call.trace}
+	}
+	err := c.c.DoDeadline(req, call.resp, deadline)
+	c.closeRecycledConn()
+	if call.state.CompareAndSwap(callRunning, callDone) {
+		call.done <- err
 		return
 	}
 
+	// The caller has already returned, so nobody else references the call.
+	if err == nil && call.metricGroup != nil {
+		call.metricGroup.late.Inc()
+	}
+	contract.ReleaseRequest(call.req)
+	contract.ReleaseResponse(call.resp)
+	releasePendingCall(call)
+}
+
+func acquirePendingCall() *pendingCall {
+	v := pendingCallPool.Get()
+	if v == nil {
+		return &pendingCall{
+			done: make(chan error, 1),
+		}
+	}
+	return v.(*pendingCall)
+}
+
+func releasePendingCall(call *pendingCall) {
+	call.req = nil
+	call.resp = nil
+	call.metricGroup = nil
+	call.trace = nil
+	call.state.Store(callRunning)
+	pendingCallPool.Put(call)
+}
+
+var pendingCallPool sync.Pool
+
+// observeLatency records the latency of a request for the balancers.
+func (c *client) observeLatency(d time.Duration) {
+	c.latency.update(d)
+	if c.shardLatency != nil {
+		c.shardLatency.update(d)
+	}
+}
+
+// timeoutError returns the error for a request whose deadline timer has fired.
+// If the deadline came from ctx, the timer may fire just before ctx is done, so
+// context.DeadlineExceeded is returned for consistency with ctx.Err().
+func timeoutError(ctx context.Context) error {
+	if _, ok := ctx.Deadline(); ok {
+		return context.DeadlineExceeded
+	}
+	return fastrpc.ErrTimeout
+}
+
+// isContextError reports whether err is caused by context cancellation or an expired context deadline.
+func isContextError(err error) bool {
+	return errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
+
+// countError increments error-related metrics for the given request and logs it with request and server details.
+func (c *client) countError(reqn contract.RPCRegister, err error, resp *contract.Response) {
+	metricGroup := c.metricGroups[reqn]
+
+	class := "failed"
+	level := slog.LevelWarn
 	switch {
-	case errors.Is(err, fastrpc.ErrTimeout):
+	case nil == err:
+		metricGroup.failed.Inc()
+	case errors.Is(err, fastrpc.ErrTimeout), errors.Is(err, context.DeadlineExceeded):
 		metricGroup.timeout.Inc()
+		class = "timeout"
+	case errors.Is(err, context.Canceled):
+		metricGroup.canceled.Inc()
+		class = "canceled"
+		level = slog.LevelDebug
 	case errors.Is(err, fastrpc.ErrPendingRequestsOverflow):
 		metricGroup.overflow.Inc()
+		class = "overflow"
 	default:
 		metricGroup.error.Inc()
+		class = "other"
+	}
+
+	attrs := []slog.Attr{
+		slog.String("request", reqn.String()),
+		slog.String("class", class),
+		slog.Int("server_id", int(c.GetServerID())),
+		slog.Uint64("conn_gen", c.connGen.Load()),
+	}
+	if err != nil {
+		attrs = append(attrs, slog.Any("error", err))
+	} else if resp != nil {
+		attrs = append(attrs, slog.String("status", resp.GetStatusCode().String()))
 	}
+	c.log.log(level, "request failed", c.addr, attrs...)
 }
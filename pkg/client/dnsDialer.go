@@ -2,7 +2,9 @@ package client
 
 import (
 	"context"
+	"log/slog"
 	"net"
+	"slices"
 	"sort"
 	"sync"
 	"sync/atomic"
@@ -24,21 +26,28 @@ type dnsDialer struct {
 	refreshInterval time.Duration
 	dialTimeout     time.Duration
 	resolver        dnsResolver
+	log             *eventLogger
 
 	next atomic.Uint64
 
-	mu    sync.Mutex
-	addrs []string
-	conns map[*trackedConn]string
+	// done is closed by close to stop refreshLoop.
+	done chan struct{}
+
+	mu     sync.Mutex
+	addrs  []string
+	conns  map[*trackedConn]string
+	closed bool
 }
 
-func newDNSDialer(addr string, dialTimeout, refreshInterval time.Duration) *dnsDialer {
+func newDNSDialer(addr string, dialTimeout, refreshInterval time.Duration, log *eventLogger) *dnsDialer {
 	d := &dnsDialer{
 		addr:            addr,
 		refreshInterval: refreshInterval,
 		dialTimeout:     dialTimeout,
 		resolver:        net.DefaultResolver,
+		log:             log,
 		conns:           make(map[*trackedConn]string),
+		done:            make(chan struct{}),
 	}
 	d.init()
 	return d
@@ -51,6 +60,7 @@ func newDNSDialerWithResolver(addr string, dialTimeout, refreshInterval time.Dur
 		dialTimeout:     dialTimeout,
 		resolver:        resolver,
 		conns:           make(map[*trackedConn]string),
+		done:            make(chan struct{}),
 	}
 	d.init()
 	return d
@@ -77,11 +87,45 @@ func (d *dnsDialer) init() {
 }
 
 func (d *dnsDialer) dial(owner *client) (net.Conn, error) {
-	addr := d.nextAddr()
+	d.mu.Lock()
+	closed := d.closed
+	d.mu.Unlock()
+	if closed {
+		return nil, ErrClosed
+	}
+
+	addr, addrs := d.nextAddr()
+	if owner != nil {
+		owner.traces.each(func(trace *ClientTrace) {
+			if trace.DNSDone != nil {
+				trace.DNSDone(DNSDoneInfo{Host: d.host, Addrs: addrs})
+			}
+			if trace.DialStart != nil {
+				trace.DialStart(addr)
+			}
+		})
+	}
 	conn, err := fasthttp.DialTimeout(addr, d.dialTimeout)
+	if err != nil {
+		d.log.log(slog.LevelWarn, "dial failed", d.addr, slog.String("remote_addr", addr), slog.Any("error", err))
+	}
+	if owner != nil {
+		owner.traces.each(func(trace *ClientTrace) {
+			if trace.DialDone != nil {
+				trace.DialDone(addr, err)
+			}
+		})
+	}
 	if err != nil {
 		return nil, err
 	}
+	if owner != nil {
+		if prev := owner.remoteAddr.Swap(&addr); prev != nil && *prev != addr {
+			// The host may resolve to several servers, so the server ID is unknown until the next handshake
+			// unless it is pinned.
+			owner.setServerID(owner.pinnedServerID)
+		}
+	}
 
 	tc := &trackedConn{
 		Conn:   conn,
@@ -90,30 +134,64 @@ func (d *dnsDialer) dial(owner *client) (net.Conn, error) {
 		owner:  owner,
 	}
 	d.mu.Lock()
+	if d.closed {
+		d.mu.Unlock()
+		_ = conn.Close()
+		return nil, ErrClosed
+	}
 	d.conns[tc] = addr
 	d.mu.Unlock()
+	if owner != nil {
+		owner.conn.Store(tc)
+	}
 
 	return tc, nil
 }
 
-func (d *dnsDialer) nextAddr() string {
+// close stops the DNS refresh, closes all connections and makes subsequent dials fail with ErrClosed.
+func (d *dnsDialer) close() {
+	d.mu.Lock()
+	if d.closed {
+		d.mu.Unlock()
+		return
+	}
+	d.closed = true
+	close(d.done)
+	conns := make([]*trackedConn, 0, len(d.conns))
+	for conn := range d.conns {
+		conns = append(conns, conn)
+	}
+	d.mu.Unlock()
+
+	for _, conn := range conns {
+		_ = conn.Close()
+	}
+}
+
+// nextAddr returns the address for the next dial along with a copy of all resolved addresses.
+func (d *dnsDialer) nextAddr() (string, []string) {
 	d.mu.Lock()
 	addrs := append([]string(nil), d.addrs...)
 	d.mu.Unlock()
 
 	if len(addrs) == 0 {
-		return d.addr
+		return d.addr, nil
 	}
 	idx := d.next.Add(1) - 1
-	return addrs[idx%uint64(len(addrs))]
+	return addrs[idx%uint64(len(addrs))], addrs
 }
 
 func (d *dnsDialer) refreshLoop() {
 	ticker := time.NewTicker(d.refreshInterval)
 	defer ticker.Stop()
 
-	for range ticker.C {
-		d.refreshOnce()
+	for {
+		select {
+		case <-ticker.C:
+			d.refreshOnce()
+		case <-d.done:
+			return
+		}
 	}
 }
 
@@ -124,11 +202,16 @@ func (d *dnsDialer) refreshOnce() {
 	}
 
 	d.mu.Lock()
+	changed := !slices.Equal(d.addrs, addrs)
 	d.addrs = addrs
 	toClose := d.rebalanceLocked()
 	d.mu.Unlock()
 
+	if changed {
+		d.log.log(slog.LevelInfo, "dns addresses changed", d.addr, slog.Any("remote_addrs", addrs))
+	}
 	for _, conn := range toClose {
+		d.log.log(slog.LevelInfo, "connection closed for rebalancing", d.addr, slog.String("remote_addr", conn.addr))
 		_ = conn.Close()
 	}
 }
@@ -146,6 +229,7 @@ func (d *dnsDialer) resolve() []string {
 
 	ips, err := d.resolver.LookupIPAddr(ctx, d.host)
 	if err != nil {
+		d.log.log(slog.LevelWarn, "dns lookup failed", d.addr, slog.String("host", d.host), slog.Any("error", err))
 		return nil
 	}
 
@@ -207,6 +291,25 @@ func (d *dnsDialer) rebalanceLocked() []*trackedConn {
 	return toClose
 }
 
+// openConns returns the connections that are open now.
+func (d *dnsDialer) openConns() []*trackedConn {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	conns := make([]*trackedConn, 0, len(d.conns))
+	for conn := range d.conns {
+		conns = append(conns, conn)
+	}
+	return conns
+}
+
+// isOpen reports whether conn hasn't been closed yet.
+func (d *dnsDialer) isOpen(conn *trackedConn) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	_, ok := d.conns[conn]
+	return ok
+}
+
 func (d *dnsDialer) remove(conn *trackedConn) {
 	d.mu.Lock()
 	delete(d.conns, conn)
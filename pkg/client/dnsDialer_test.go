@@ -2,7 +2,9 @@ package client
 
 import (
 	"context"
+	"errors"
 	"net"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -36,7 +38,7 @@ func TestDNSDialerInitialResolveAndRoundRobin(t *testing.T) {
 		"178.63.252.110:7943",
 	}
 	for i, addr := range want {
-		if got := dialer.nextAddr(); got != addr {
+		if got, _ := dialer.nextAddr(); got != addr {
 			t.Fatalf("addr[%d]: expected %q, got %q", i, addr, got)
 		}
 	}
@@ -61,7 +63,7 @@ func TestDNSDialerRefreshUpdatesResolvedAddrs(t *testing.T) {
 		"178.63.252.112:7943",
 	}
 	for i, addr := range want {
-		if got := dialer.nextAddr(); got != addr {
+		if got, _ := dialer.nextAddr(); got != addr {
 			t.Fatalf("addr[%d]: expected %q, got %q", i, addr, got)
 		}
 	}
@@ -131,3 +133,38 @@ func newTestTrackedConn(t *testing.T, dialer *dnsDialer, addr string) *trackedCo
 		addr:   addr,
 	}
 }
+
+func TestDNSDialerCloseStopsRefreshAndDials(t *testing.T) {
+	resolver := &countingDNSResolver{}
+	dialer := newDNSDialerWithResolver("cloud.mygaru.com:7943", time.Second, 5*time.Millisecond, resolver)
+
+	conn := newTestTrackedConn(t, dialer, "178.63.252.110:7943")
+	dialer.mu.Lock()
+	dialer.conns[conn] = conn.addr
+	dialer.mu.Unlock()
+
+	dialer.close()
+	if len(dialer.conns) != 0 {
+		t.Fatalf("expected close to close all tracked connections, got %d", len(dialer.conns))
+	}
+	if _, err := dialer.dial(nil); !errors.Is(err, ErrClosed) {
+		t.Fatalf("expected ErrClosed from dial after close, got %v", err)
+	}
+
+	// Let a refresh that was already running finish.
+	time.Sleep(20 * time.Millisecond)
+	lookups := resolver.lookups.Load()
+	time.Sleep(50 * time.Millisecond)
+	if got := resolver.lookups.Load(); got != lookups {
+		t.Fatalf("expected DNS refresh to stop after close, got %d more lookups", got-lookups)
+	}
+}
+
+type countingDNSResolver struct {
+	lookups atomic.Int64
+}
+
+func (r *countingDNSResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	r.lookups.Add(1)
+	return []net.IPAddr{{IP: net.ParseIP("178.63.252.110")}}, nil
+}
@@ -0,0 +1,114 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/aradilov/fastrpc"
+	base "github.com/mygaru/dcr-sdk/gen/base1"
+	"github.com/mygaru/dcr-sdk/pkg/contract"
+)
+
+var (
+	// ErrTimeout matches errors of requests that didn't complete before their deadline.
+	ErrTimeout = errors.New("request timed out")
+
+	// ErrOverflow matches errors of requests rejected because the connection already has MaxPendingRequests in flight.
+	ErrOverflow = errors.New("too many pending requests")
+
+	// ErrUnauthorized matches errors of requests rejected with UNAUTHORIZED, including failed contract.Auth exchanges.
+	ErrUnauthorized = errors.New("unauthorized")
+
+	// ErrSDKOutdated matches errors of requests rejected with OUTDATED, including contract.Hello exchanges
+	// in which the server announced a minimum SDK version above SDKVersion.
+	ErrSDKOutdated = errors.New("sdk version is outdated")
+
+	// ErrUnknownShard is returned by Report when no shard is known for the server ID encoded in the tracking id.
+	ErrUnknownShard = errors.New("unknown server for tracking id")
+
+	// ErrMissingTrackingID is returned by Report when the request has no tracking id.
+	ErrMissingTrackingID = errors.New("tracking id is required")
+
+	// ErrShardExists is returned by AddShard when the client already has a shard with the address.
+	ErrShardExists = errors.New("shard already exists")
+
+	// ErrShardNotFound is returned by RemoveShard and DrainShard when the client has no shard with the address.
+	ErrShardNotFound = errors.New("shard not found")
+
+	// ErrLastShard is returned by RemoveShard and DrainShard for the last shard that receives Target calls.
+	ErrLastShard = errors.New("cannot remove the last shard")
+
+	// ErrInvalidConfiguration matches the errors of Configuration.Validate and NewValidatedClient.
+	ErrInvalidConfiguration = errors.New("invalid configuration")
+)
+
+// ErrorUnauthorized is the former name of ErrUnauthorized.
+//
+// Deprecated: use ErrUnauthorized.
+var ErrorUnauthorized = ErrUnauthorized
+
+// RPCError describes a failed Target or Report request.
+//
+// Use errors.As to inspect it and errors.Is with ErrTimeout, ErrOverflow, ErrUnauthorized, ErrSDKOutdated,
+// context.Canceled or context.DeadlineExceeded to classify it.
+type RPCError struct {
+	// Method is the request that failed.
+	Method contract.RPCRegister
+
+	// Addr is the address of the shard the request was sent to.
+	Addr string
+
+	// ServerID is the ID of the server behind the connection, or zero if it is not known yet.
+	ServerID uint16
+
+	// Code is the response status code. It is NETWORK_ERROR if no response was received
+	// and INVALID_REQUEST if the request couldn't be marshaled.
+	Code base.RPCServerResponseCode
+
+	// Message is the error message sent by the server with a non-OK status code.
+	Message string
+
+	// Err is the underlying error, e.g. the fastrpc or context error. It is nil if the server
+	// answered with a non-OK status code.
+	Err error
+}
+
+func (e *RPCError) Error() string {
+	if e.Err == nil {
+		return fmt.Sprintf("RPC[%q]: %s", e.Code.String(), e.Message)
+	}
+	return fmt.Sprintf("error when calling '%s': %v", e.Method, e.Err)
+}
+
+func (e *RPCError) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether e matches one of the ErrTimeout, ErrOverflow, ErrUnauthorized and ErrSDKOutdated sentinels.
+func (e *RPCError) Is(target error) bool {
+	switch target {
+	case ErrTimeout:
+		return errors.Is(e.Err, fastrpc.ErrTimeout) || errors.Is(e.Err, context.DeadlineExceeded)
+	case ErrOverflow:
+		return errors.Is(e.Err, fastrpc.ErrPendingRequestsOverflow)
+	case ErrUnauthorized:
+		return e.Code == base.RPCServerResponseCode_UNAUTHORIZED
+	case ErrSDKOutdated:
+		return e.Code == base.RPCServerResponseCode_OUTDATED
+	default:
+		return false
+	}
+}
+
+// rpcError builds an RPCError for a request sent over c.
+func (c *client) rpcError(reqn contract.RPCRegister, statusCode base.RPCServerResponseCode, message string, err error) *RPCError {
+	return &RPCError{
+		Method:   reqn,
+		Addr:     c.addr,
+		ServerID: c.GetServerID(),
+		Code:     statusCode,
+		Message:  message,
+		Err:      err,
+	}
+}
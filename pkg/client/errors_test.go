@@ -0,0 +1,165 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aradilov/fastrpc"
+	"github.com/google/uuid"
+	base "github.com/mygaru/dcr-sdk/gen/base1"
+	"github.com/mygaru/dcr-sdk/pkg/contract"
+)
+
+func TestRPCErrorCarriesServerStatus(t *testing.T) {
+	addr := startTestServer(t, func(ctx *contract.RequestCtx) {
+		ctx.Response.SetStatusCode(base.RPCServerResponseCode_INVALID_REQUEST)
+		ctx.Response.Append([]byte("bad uid"))
+	})
+	sc := newTestShardedClient(Configuration{Addrs: addr, MaximumSimultaneousConnections: 2})
+
+	_, _, err := sc.Target(testTargetRequest())
+	var rpcErr *RPCError
+	if !errors.As(err, &rpcErr) {
+		t.Fatalf("expected *RPCError, got %T: %v", err, err)
+	}
+	if rpcErr.Method != contract.Target || rpcErr.Addr != addr {
+		t.Fatalf("unexpected method %s or addr %q", rpcErr.Method, rpcErr.Addr)
+	}
+	if rpcErr.Code != base.RPCServerResponseCode_INVALID_REQUEST || rpcErr.Message != "bad uid" {
+		t.Fatalf("unexpected code %s or message %q", rpcErr.Code, rpcErr.Message)
+	}
+	if rpcErr.Err != nil {
+		t.Fatalf("expected no cause for a server status, got %v", rpcErr.Err)
+	}
+	if got := err.Error(); got != `RPC["INVALID_REQUEST"]: bad uid` {
+		t.Fatalf("unexpected error text %q", got)
+	}
+}
+
+func TestRPCErrorReportsUnmarshalableRequest(t *testing.T) {
+	var requests atomic.Int64
+	addr := startTestServer(t, func(ctx *contract.RequestCtx) {
+		requests.Add(1)
+		ctx.Response.SetStatusCode(base.RPCServerResponseCode_OK)
+	})
+	sc := newTestShardedClient(Configuration{Addrs: addr, MaximumSimultaneousConnections: 2})
+
+	_, statusCode, err := sc.Target(&base.TargetRequest{})
+	var rpcErr *RPCError
+	if !errors.As(err, &rpcErr) {
+		t.Fatalf("expected *RPCError, got %T: %v", err, err)
+	}
+	if statusCode != base.RPCServerResponseCode_INVALID_REQUEST || rpcErr.Code != base.RPCServerResponseCode_INVALID_REQUEST {
+		t.Fatalf("expected INVALID_REQUEST, got %s and %s", statusCode, rpcErr.Code)
+	}
+	if rpcErr.Err == nil {
+		t.Fatalf("expected the marshal error as the cause")
+	}
+	if got := requests.Load(); got != 0 {
+		t.Fatalf("expected nothing to be sent, got %d requests", got)
+	}
+}
+
+func TestRPCErrorWrapsTimeout(t *testing.T) {
+	addr := startDelayedServer(t, 200*time.Millisecond)
+	sc := newTestShardedClient(Configuration{
+		Addrs:              addr,
+		MaxRequestDuration: 20 * time.Millisecond,
+	})
+
+	_, statusCode, err := sc.Target(testTargetRequest())
+	if statusCode != base.RPCServerResponseCode_NETWORK_ERROR {
+		t.Fatalf("expected NETWORK_ERROR, got %s", statusCode)
+	}
+	if !errors.Is(err, ErrTimeout) {
+		t.Fatalf("expected ErrTimeout, got %v", err)
+	}
+	if !errors.Is(err, fastrpc.ErrTimeout) {
+		t.Fatalf("expected the fastrpc cause to be preserved, got %v", err)
+	}
+	if errors.Is(err, ErrOverflow) || errors.Is(err, ErrUnauthorized) {
+		t.Fatalf("unexpected sentinel match for %v", err)
+	}
+}
+
+func TestRPCErrorWrapsContextDeadline(t *testing.T) {
+	addr := startDelayedServer(t, 200*time.Millisecond)
+	sc := newTestShardedClient(Configuration{Addrs: addr, MaximumSimultaneousConnections: 2})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, _, err := sc.TargetContext(ctx, testTargetRequest())
+	if !errors.Is(err, ErrTimeout) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected ErrTimeout and DeadlineExceeded, got %v", err)
+	}
+}
+
+func TestRPCErrorMatchesUnauthorized(t *testing.T) {
+	addr := startTestServer(t, func(ctx *contract.RequestCtx) {
+		ctx.Response.SetStatusCode(base.RPCServerResponseCode_UNAUTHORIZED)
+	})
+	sc := newTestShardedClient(Configuration{
+		Addrs:    addr,
+		JwtToken: []byte(uuid.NewString()),
+	})
+
+	_, statusCode, err := sc.Target(testTargetRequest())
+	if statusCode != base.RPCServerResponseCode_UNAUTHORIZED {
+		t.Fatalf("expected UNAUTHORIZED, got %s", statusCode)
+	}
+	if !errors.Is(err, ErrUnauthorized) || !errors.Is(err, ErrorUnauthorized) {
+		t.Fatalf("expected ErrUnauthorized, got %v", err)
+	}
+}
+
+func TestRPCErrorMatchesOverflow(t *testing.T) {
+	err := fmt.Errorf("wrapped: %w", &RPCError{
+		Method: contract.Report,
+		Code:   base.RPCServerResponseCode_NETWORK_ERROR,
+		Err:    fastrpc.ErrPendingRequestsOverflow,
+	})
+	if !errors.Is(err, ErrOverflow) {
+		t.Fatalf("expected ErrOverflow, got %v", err)
+	}
+	if errors.Is(err, ErrTimeout) {
+		t.Fatalf("unexpected ErrTimeout match for %v", err)
+	}
+}
+
+func TestReportValidationSentinels(t *testing.T) {
+	sc := newTestShardedClient(Configuration{Addrs: "127.0.0.1:1", MaximumSimultaneousConnections: 2})
+
+	if _, err := sc.Report(&base.ReportRequest{}); !errors.Is(err, ErrMissingTrackingID) {
+		t.Fatalf("expected ErrMissingTrackingID, got %v", err)
+	}
+	if _, err := sc.Report(testReportRequest()); !errors.Is(err, ErrUnknownShard) {
+		t.Fatalf("expected ErrUnknownShard, got %v", err)
+	}
+}
+
+func TestValidateTrackingIDExplainsMalformedID(t *testing.T) {
+	sc := newTestShardedClient(Configuration{Addrs: "127.0.0.1:1", MaximumSimultaneousConnections: 2})
+	setTestServerID(sc.shardSet().clients[0], testReporterServerID)
+
+	if err := sc.ValidateTrackingID([]byte("0400000000000001")); err != nil {
+		t.Fatalf("expected the tracking id to be valid, got %v", err)
+	}
+	err := sc.ValidateTrackingID([]byte("04X0000000000001"))
+	if !errors.Is(err, ErrUnknownShard) || !errors.Is(err, contract.ErrInvalidTrackingID) {
+		t.Fatalf("expected ErrUnknownShard wrapping ErrInvalidTrackingID, got %v", err)
+	}
+	if sc.IsValidTrackingID([]byte("04X0000000000001")) {
+		t.Fatalf("expected a malformed tracking id to be invalid")
+	}
+	if err := sc.ValidateTrackingID([]byte("0400000000000001-suffix")); err != nil {
+		t.Fatalf("expected a tracking id longer than 16 characters to be valid, got %v", err)
+	}
+	err = sc.ValidateTrackingID([]byte("0000000000000001"))
+	if !errors.Is(err, ErrUnknownShard) || errors.Is(err, contract.ErrInvalidTrackingID) {
+		t.Fatalf("expected ErrUnknownShard for server ID 0, got %v", err)
+	}
+}
@@ -0,0 +1,191 @@
+package client
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	base "github.com/mygaru/dcr-sdk/gen/base1"
+)
+
+const (
+	defaultHedgeDelay = 10 * time.Millisecond
+
+	// latencyWindowSize is the number of recent Target latencies kept per shard.
+	latencyWindowSize = 256
+
+	// latencyRecomputeInterval is the number of samples between percentile recomputations.
+	latencyRecomputeInterval = 32
+)
+
+// HedgePolicy configures hedged Target requests.
+//
+// When the shard picked for a Target doesn't answer within the hedge delay, a second Target
+// is sent to another shard and the first OK response wins. The other request is canceled.
+// The returned TrackingId belongs to the shard that answered, so Report is routed to it.
+//
+// Hedging needs at least two shards in Configuration.Addrs and is disabled by default.
+// Report is never hedged.
+type HedgePolicy struct {
+	// Delay is the time to wait for the first shard before the hedged request is sent.
+	// If Percentile is set, Delay is used until the shard has collected enough latency samples.
+	Delay time.Duration
+
+	// Percentile derives the hedge delay from the recent Target latencies of the first shard,
+	// e.g. 0.95 sends the hedged request once the call is slower than the shard's p95.
+	// It must be in (0, 1). If Delay is zero, 10ms is used until enough samples are collected.
+	Percentile float64
+
+	// MinDelay is the lower bound for the delay derived from Percentile.
+	MinDelay time.Duration
+}
+
+func normalizeHedgePolicy(p HedgePolicy) HedgePolicy {
+	if p.Percentile <= 0 || p.Percentile >= 1 {
+		p.Percentile = 0
+	}
+	if p.Percentile > 0 && p.Delay <= 0 {
+		p.Delay = defaultHedgeDelay
+	}
+	if p.Delay <= 0 {
+		return HedgePolicy{}
+	}
+	if p.MinDelay < 0 {
+		p.MinDelay = 0
+	}
+	return p
+}
+
+func (p *HedgePolicy) enabled() bool {
+	return p.Delay > 0
+}
+
+// delay returns the hedge delay for a Target sent to shard.
+func (p *HedgePolicy) delay(shard *clientsGroup) time.Duration {
+	if p.Percentile == 0 || shard.latency == nil {
+		return p.Delay
+	}
+	d, ok := shard.latency.value()
+	if !ok {
+		return p.Delay
+	}
+	return max(d, p.MinDelay)
+}
+
+type targetResult struct {
+	resp       *base.TargetResponse
+	statusCode base.RPCServerResponseCode
+	err        error
+	shard      *clientsGroup
+}
+
+// targetHedged sends a Target attempt and hedges it to another shard according to Configuration.Hedge.
+func (sc *ShardedClient) targetHedged(ctx context.Context, req *base.TargetRequest) (*base.TargetResponse, base.RPCServerResponseCode, error) {
+	primary, probe := sc.getGroup()
+	if !sc.Hedge.enabled() || len(sc.shardSet().active) < 2 {
+		return sc.target(ctx, req, primary, probe)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// results is buffered for both requests, so the loser never blocks after targetHedged returns.
+	results := make(chan targetResult, 2)
+	send := func(shard *clientsGroup, probe uint64) {
+		resp, statusCode, err := sc.target(ctx, req, shard, probe)
+		results <- targetResult{resp: resp, statusCode: statusCode, err: err, shard: shard}
+	}
+	go send(primary, probe)
+
+	timer := time.NewTimer(sc.Hedge.delay(primary))
+	defer timer.Stop()
+
+	inflight := 1
+	hedged := false
+	for {
+		select {
+		case r := <-results:
+			inflight--
+			if r.err == nil {
+				if hedged && r.shard != primary {
+					r.shard.hedge.won.Inc()
+				}
+				return r.resp, r.statusCode, nil
+			}
+			if inflight == 0 {
+				return nil, r.statusCode, r.err
+			}
+		case <-timer.C:
+			secondary, probe := sc.nextGroup(primary)
+			if secondary == nil {
+				continue
+			}
+			hedged = true
+			inflight++
+			secondary.hedge.sent.Inc()
+			go send(secondary, probe)
+		}
+	}
+}
+
+// nextGroup returns a shard picked by the balancer that differs from shard, and its breaker probe token like getGroup.
+func (sc *ShardedClient) nextGroup(shard *clientsGroup) (*clientsGroup, uint64) {
+	shards := sc.shardSet()
+	for i := 0; i < len(shards.endpoints); i++ {
+		g, probe := sc.getGroup()
+		if g != shard {
+			return g, probe
+		}
+		if probe != 0 {
+			g.breaker.release(probe)
+		}
+	}
+	for _, g := range shards.active {
+		if g != shard {
+			return g, 0
+		}
+	}
+	return nil, 0
+}
+
+// latencyWindow keeps the recent Target latencies of a shard and a cached percentile over them.
+type latencyWindow struct {
+	percentile float64
+
+	mu      sync.Mutex
+	samples [latencyWindowSize]time.Duration
+	n       int
+
+	// cached is the last computed percentile in nanoseconds; zero until enough samples are collected.
+	cached atomic.Int64
+}
+
+func newLatencyWindow(percentile float64) *latencyWindow {
+	return &latencyWindow{percentile: percentile}
+}
+
+func (w *latencyWindow) update(d time.Duration) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.samples[w.n%latencyWindowSize] = d
+	w.n++
+	if w.n%latencyRecomputeInterval != 0 {
+		return
+	}
+
+	sorted := slices.Clone(w.samples[:min(w.n, latencyWindowSize)])
+	slices.Sort(sorted)
+	idx := int(w.percentile * float64(len(sorted)))
+	if idx >= len(sorted) {
+		idx = len(sorted) - 1
+	}
+	w.cached.Store(int64(max(sorted[idx], 1)))
+}
+
+func (w *latencyWindow) value() (time.Duration, bool) {
+	v := w.cached.Load()
+	return time.Duration(v), v > 0
+}
@@ -0,0 +1,103 @@
+package client
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mygaru/dcr-sdk/pkg/contract"
+)
+
+func TestTargetHedgesSlowShard(t *testing.T) {
+	slow := startTestServer(t, func(ctx *contract.RequestCtx) {
+		time.Sleep(300 * time.Millisecond)
+		writeTestTargetResponse(ctx, "0400000000000001")
+	})
+	fast := startTestServer(t, func(ctx *contract.RequestCtx) {
+		writeTestTargetResponse(ctx, "0401000000000001")
+	})
+
+	sc := NewClient(&Configuration{
+		Addrs:                          slow + "," + fast,
+		DisableAuth:                    true,
+		MaximumSimultaneousConnections: 1,
+		DNSRefreshInterval:             -1,
+		Hedge:                          HedgePolicy{Delay: 10 * time.Millisecond},
+	}, nil)
+	fastShard := sc.shardSet().clients[1]
+	won := fastShard.hedge.won.Get()
+
+	// Make the next round-robin pick the slow shard.
+	sc.balancer.(*roundRobinBalancer).n.Store(uint64(len(sc.shardSet().clients) - 1))
+
+	st := time.Now()
+	resp, _, err := sc.Target(testTargetRequest())
+	if err != nil {
+		t.Fatalf("expected hedged target to succeed, got %v", err)
+	}
+	if elapsed := time.Since(st); elapsed >= 200*time.Millisecond {
+		t.Fatalf("expected the hedged request to answer before the slow shard, took %s", elapsed)
+	}
+	if string(resp.TrackingId) != "0401000000000001" {
+		t.Fatalf("expected the tracking id of the fast shard, got %q", resp.TrackingId)
+	}
+	if got := fastShard.hedge.won.Get(); got != won+1 {
+		t.Fatalf("expected hedge wins to increment to %d, got %d", won+1, got)
+	}
+	if rt, _ := sc.routeTrackingID(resp.TrackingId); rt == nil || rt.shard != fastShard {
+		t.Fatalf("expected the tracking id to route reports to the shard that answered")
+	}
+}
+
+func TestTargetIsNotHedgedBeforeDelay(t *testing.T) {
+	addrs := startTestServer(t, func(ctx *contract.RequestCtx) {
+		writeTestTargetResponse(ctx, "0400000000000001")
+	})
+	addrs += "," + startTestServer(t, func(ctx *contract.RequestCtx) {
+		writeTestTargetResponse(ctx, "0401000000000001")
+	})
+
+	sc := NewClient(&Configuration{
+		Addrs:                          addrs,
+		DisableAuth:                    true,
+		MaximumSimultaneousConnections: 1,
+		DNSRefreshInterval:             -1,
+		Hedge:                          HedgePolicy{Delay: time.Second},
+	}, nil)
+	sent := sc.shardSet().clients[0].hedge.sent.Get() + sc.shardSet().clients[1].hedge.sent.Get()
+
+	for i := 0; i < 4; i++ {
+		if _, _, err := sc.Target(testTargetRequest()); err != nil {
+			t.Fatalf("target %d: %v", i, err)
+		}
+	}
+	if got := sc.shardSet().clients[0].hedge.sent.Get() + sc.shardSet().clients[1].hedge.sent.Get(); got != sent {
+		t.Fatalf("expected no hedged requests, got %d", got-sent)
+	}
+}
+
+func TestLatencyWindowPercentile(t *testing.T) {
+	w := newLatencyWindow(0.9)
+	for i := 1; i < latencyRecomputeInterval; i++ {
+		w.update(time.Duration(i) * time.Millisecond)
+	}
+	if _, ok := w.value(); ok {
+		t.Fatalf("expected no percentile before enough samples are collected")
+	}
+
+	for i := 0; i < 100-latencyRecomputeInterval+1; i++ {
+		w.update(time.Duration(latencyRecomputeInterval+i) * time.Millisecond)
+	}
+	// 100 samples of 1ms..100ms; the percentile is recomputed at 96 samples.
+	d, ok := w.value()
+	if !ok {
+		t.Fatalf("expected percentile to be computed")
+	}
+	if d != 87*time.Millisecond {
+		t.Fatalf("expected p90 of 1ms..96ms to be 87ms, got %s", d)
+	}
+
+	p := HedgePolicy{Delay: time.Millisecond, Percentile: 0.9, MinDelay: 100 * time.Millisecond}
+	if got := p.delay(&clientsGroup{latency: w}); got != 100*time.Millisecond {
+		t.Fatalf("expected MinDelay to bound the hedge delay, got %s", got)
+	}
+}
@@ -0,0 +1,248 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/aradilov/fastrpc"
+	base "github.com/mygaru/dcr-sdk/gen/base1"
+	"github.com/mygaru/dcr-sdk/pkg/contract"
+	"google.golang.org/protobuf/proto"
+)
+
+// SDKVersion is the version of this SDK announced to the servers in the contract.Hello exchange.
+const SDKVersion = contract.SDKVersion
+
+// errHelloFailed wraps failures of the contract.Hello exchange other than ErrSDKOutdated.
+var errHelloFailed = errors.New("hello is failed")
+
+// ServerInfo describes the server behind a shard, as announced in its contract.Hello response.
+type ServerInfo struct {
+	// Addr is the address of the shard.
+	Addr string
+
+	contract.HelloResponse
+}
+
+// hello runs the contract.Hello exchange through the interceptors with ctx marked by withInternalCall.
+// Servers that predate contract.Hello reject it as an unknown method with INVALID_REQUEST or without
+// a status code. That is not an error: the connection keeps its pinned server ID, if any,
+// and the handshake goes on with contract.Auth.
+func (c *client) hello(ctx context.Context) error {
+	req := contract.AcquireRequest()
+	resp := contract.AcquireResponse()
+	defer func() {
+		contract.ReleaseRequest(req)
+		contract.ReleaseResponse(resp)
+	}()
+
+	req.SetName(contract.Hello)
+	req.Append([]byte(SDKVersion))
+
+	statusCode, err := intercept(withInternalCall(ctx), c.interceptors, contract.Hello, nil, nil, func(context.Context, contract.RPCRegister, proto.Message, proto.Message) (base.RPCServerResponseCode, error) {
+		if err := c.c.DoDeadline(req, resp, time.Now().Add(c.maxRequestDuration)); err != nil {
+			return base.RPCServerResponseCode_NETWORK_ERROR, err
+		}
+		return resp.GetStatusCode(), nil
+	})
+	if err != nil {
+		return fmt.Errorf("%w: %w", errHelloFailed, err)
+	}
+
+	var info contract.HelloResponse
+	switch statusCode {
+	case base.RPCServerResponseCode_OK:
+	case base.RPCServerResponseCode_OUTDATED:
+		_ = info.Unmarshal(resp.Value())
+		return fmt.Errorf("%w: server requires %q, have %q", ErrSDKOutdated, info.MinSDKVersion, SDKVersion)
+	case base.RPCServerResponseCode_INVALID_REQUEST, base.RPCServerResponseCode_UNKNOWN:
+		c.log.log(slog.LevelDebug, "hello unsupported", c.addr, slog.String("status", statusCode.String()))
+		if c.GetServerID() == 0 {
+			c.setServerID(c.pinnedServerID)
+		}
+		return nil
+	default:
+		return fmt.Errorf("%w, err = response status code is not RPCServerResponseCode_OK, got = %s", errHelloFailed, statusCode.String())
+	}
+
+	if err := info.Unmarshal(resp.Value()); err != nil {
+		return fmt.Errorf("%w: %w", errHelloFailed, err)
+	}
+	if c.pinnedServerID != 0 && info.ServerID != c.pinnedServerID {
+		c.log.log(slog.LevelWarn, "pinned server id mismatch", c.addr,
+			slog.Int("server_id", int(info.ServerID)),
+			slog.Int("pinned_server_id", int(c.pinnedServerID)))
+	}
+	c.serverInfo.Store(&info)
+	c.setServerID(info.ServerID)
+	return nil
+}
+
+// helloInBackground starts the contract.Hello exchange for the current connection unless it is done or running.
+// Connections authenticated by their mTLS certificate don't make requests wait for contract.Hello:
+// a server that rejects the SDK version answers them with OUTDATED anyway.
+func (c *client) helloInBackground() {
+	if c.isAuthForCurrentConn() || !c.mu.TryLock() {
+		return
+	}
+	go func() {
+		defer c.mu.Unlock()
+		_ = c.ensureAuthForCurrentConnLocked(context.Background())
+	}()
+}
+
+// setServerID records the ID of the server behind the connection. Zero means it is unknown.
+func (c *client) setServerID(serverID uint16) {
+	if uint16(c.serverID.Swap(uint32(serverID))) != serverID && c.onServerID != nil {
+		c.onServerID(c)
+	}
+}
+
+// handshakeStatusCode returns the status code of a request that failed because its connection handshake failed.
+func handshakeStatusCode(err error) base.RPCServerResponseCode {
+	switch {
+	case errors.Is(err, ErrSDKOutdated):
+		return base.RPCServerResponseCode_OUTDATED
+	case errors.Is(err, fastrpc.ErrTimeout), isContextError(err), errors.Is(err, errHelloFailed):
+		return base.RPCServerResponseCode_NETWORK_ERROR
+	default:
+		return base.RPCServerResponseCode_UNAUTHORIZED
+	}
+}
+
+// ServerInfo returns the contract.Hello responses of the servers behind the shards, one per server.
+// Servers without a dialed connection, or that predate contract.Hello, are omitted.
+func (sc *ShardedClient) ServerInfo() []ServerInfo {
+	var infos []ServerInfo
+	seen := make(map[uint16]bool)
+	for _, shard := range sc.shardSet().clients {
+		for _, cl := range shard.clients {
+			info := cl.serverInfo.Load()
+			if info == nil || seen[info.ServerID] {
+				continue
+			}
+			seen[info.ServerID] = true
+			infos = append(infos, ServerInfo{Addr: shard.addr, HelloResponse: *info})
+		}
+	}
+	return infos
+}
+
+// discoveryInterval is the minimum time between the starts of two runs of discoverShards.
+const discoveryInterval = time.Second
+
+// discovery tracks the run of discoverShards in progress, if any.
+type discovery struct {
+	mu      sync.Mutex
+	running chan struct{}
+	// next is the earliest time the next run may start.
+	next time.Time
+}
+
+// discoverShards runs the handshake on one connection of every shard that has a connection with an unknown
+// server ID, and waits until it completes, ctx is done or the request deadline derived from ctx expires.
+// Concurrent calls share a single run, and runs start at most once per discoveryInterval:
+// calls in between return immediately.
+func (sc *ShardedClient) discoverShards(ctx context.Context) {
+	done := sc.startDiscovery()
+	if done == nil {
+		return
+	}
+
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(sc.MaxRequestDuration)
+	}
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+	case <-timer.C:
+	}
+}
+
+// startDiscovery starts a discovery run unless one is already running and returns a channel closed when it completes.
+// It returns nil if the last run started less than discoveryInterval ago.
+func (sc *ShardedClient) startDiscovery() <-chan struct{} {
+	sc.discovery.mu.Lock()
+	defer sc.discovery.mu.Unlock()
+	if sc.discovery.running != nil {
+		return sc.discovery.running
+	}
+	now := time.Now()
+	if now.Before(sc.discovery.next) {
+		return nil
+	}
+	sc.discovery.next = now.Add(discoveryInterval)
+
+	done := make(chan struct{})
+	sc.discovery.running = done
+	go func() {
+		var wg sync.WaitGroup
+		for _, shard := range sc.shardSet().clients {
+			cl := lockUndiscovered(shard)
+			if cl == nil {
+				continue
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer cl.mu.Unlock()
+				_ = cl.ensureAuthForCurrentConnLocked(context.Background())
+			}()
+		}
+		wg.Wait()
+
+		sc.discovery.mu.Lock()
+		sc.discovery.running = nil
+		sc.discovery.mu.Unlock()
+		close(done)
+	}()
+	return done
+}
+
+// lockUndiscovered locks and returns the first connection of shard that is undiscovered.
+// It returns nil if there is none, or if the handshake of that connection is already running.
+func lockUndiscovered(shard *clientsGroup) *client {
+	for _, cl := range shard.clients {
+		if !cl.isUndiscovered() {
+			continue
+		}
+		if !cl.mu.TryLock() {
+			return nil
+		}
+		if cl.isUndiscovered() {
+			return cl
+		}
+		cl.mu.Unlock()
+	}
+	return nil
+}
+
+// isUndiscovered reports whether the server ID of the connection is unknown and the handshake,
+// which may learn it, isn't done for the current connection.
+func (c *client) isUndiscovered() bool {
+	return c.GetServerID() == 0 && !c.isAuthForCurrentConn()
+}
+
+// canDiscover reports whether routing a tracking id that failed with err may succeed after discoverShards:
+// the id is well-formed and some connection is undiscovered.
+func (sc *ShardedClient) canDiscover(err error) bool {
+	if !errors.Is(err, ErrUnknownShard) || errors.Is(err, contract.ErrInvalidTrackingID) {
+		return false
+	}
+	for _, shard := range sc.shardSet().clients {
+		for _, cl := range shard.clients {
+			if cl.isUndiscovered() {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,96 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/google/uuid"
+	base "github.com/mygaru/dcr-sdk/gen/base1"
+	"github.com/mygaru/dcr-sdk/internal/testcloud"
+	"github.com/mygaru/dcr-sdk/pkg/contract"
+)
+
+func TestHelloLearnsServerIDWithoutAuth(t *testing.T) {
+	addr := startHelloTestServer(t, testServerConfig{hello: contract.HelloResponse{
+		ServerID:     testReporterServerID,
+		Capabilities: contract.CapabilityMTLSAuth,
+	}})
+	sc := newTestShardedClient(Configuration{
+		Addrs:                          addr,
+		MaximumSimultaneousConnections: 2,
+	})
+
+	if _, err := sc.Report(testReportRequest()); err != nil {
+		t.Fatalf("expected report to be routed after hello, got %v", err)
+	}
+	infos := sc.ServerInfo()
+	if len(infos) != 1 || infos[0].Addr != addr || infos[0].ServerID != testReporterServerID {
+		t.Fatalf("unexpected server info: %+v", infos)
+	}
+}
+
+func TestHelloRejectsOutdatedSDK(t *testing.T) {
+	addr := startHelloTestServer(t, testServerConfig{
+		hello:       contract.HelloResponse{ServerID: testReporterServerID, MinSDKVersion: "99.0.0"},
+		helloStatus: base.RPCServerResponseCode_OUTDATED,
+	})
+	sc := newTestShardedClient(Configuration{Addrs: addr})
+
+	err := sc.Warmup(context.Background())
+	if !errors.Is(err, ErrSDKOutdated) {
+		t.Fatalf("expected ErrSDKOutdated, got %v", err)
+	}
+}
+
+func TestHandshakeWithoutHelloKeepsPinnedServerIDAndAuthenticates(t *testing.T) {
+	server, err := testcloud.Start(testcloud.Config{ServerID: testReporterServerID, DisableHello: true})
+	if err != nil {
+		t.Fatalf("start test-cloud: %v", err)
+	}
+	t.Cleanup(func() { _ = server.Close() })
+
+	sc := newTestShardedClient(Configuration{
+		Shards:   []ShardConfig{{Addr: server.Addr(), ServerID: testReporterServerID}},
+		JwtToken: []byte(uuid.NewString()),
+	})
+	defer sc.Close()
+
+	if err := sc.Warmup(context.Background()); err != nil {
+		t.Fatalf("expected the handshake to fall back to auth, got %v", err)
+	}
+	cl := sc.shardSet().clients[0].clients[0]
+	if !cl.isAuthForCurrentConn() || cl.GetServerID() != testReporterServerID {
+		t.Fatalf("expected an authenticated connection to server %d, got server %d", testReporterServerID, cl.GetServerID())
+	}
+	if _, _, err := sc.Target(testTargetRequest()); err != nil {
+		t.Fatalf("expected target to succeed, got %v", err)
+	}
+	if _, err := sc.Report(testReportRequest()); err != nil {
+		t.Fatalf("expected report to be routed to the pinned server, got %v", err)
+	}
+	select {
+	case <-server.Reports():
+	default:
+		t.Fatal("expected the server to receive the report")
+	}
+	if infos := sc.ServerInfo(); len(infos) != 0 {
+		t.Fatalf("expected no server info without hello, got %+v", infos)
+	}
+}
+
+func TestHelloWithoutStatusCodeIsUnsupported(t *testing.T) {
+	// A server that doesn't know the method may leave the status code of the reply unset.
+	addr := startRawTestServer(t, func(ctx *contract.RequestCtx) {
+		if ctx.Request.GetName() == contract.Hello {
+			ctx.Response.SetStatusCode(base.RPCServerResponseCode_UNKNOWN)
+			return
+		}
+		ctx.Response.SetStatusCode(base.RPCServerResponseCode_OK)
+	})
+	sc := newTestShardedClient(Configuration{Addrs: addr})
+
+	if err := sc.Warmup(context.Background()); err != nil {
+		t.Fatalf("expected an unknown-method reply to hello to be ignored, got %v", err)
+	}
+}
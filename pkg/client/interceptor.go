@@ -0,0 +1,46 @@
+package client
+
+import (
+	"context"
+
+	base "github.com/mygaru/dcr-sdk/gen/base1"
+	"github.com/mygaru/dcr-sdk/pkg/contract"
+	"google.golang.org/protobuf/proto"
+)
+
+// Invoker sends a unary RPC over a single connection and unmarshals the response into resp.
+type Invoker func(ctx context.Context, method contract.RPCRegister, req, resp proto.Message) (base.RPCServerResponseCode, error)
+
+// UnaryInterceptor intercepts every unary RPC sent by the client, including retries, hedged requests
+// and the internal contract.Hello and contract.Auth exchanges.
+//
+// An interceptor may inspect or modify req before calling next, inspect resp after it, replace the
+// returned status code and error, or skip next entirely. resp is nil for Report.
+//
+// The contract.Hello and contract.Auth exchanges are not protobuf-encoded, so they are passed with nil req
+// and resp, and IsInternalCall reports true for their ctx. A non-OK status code is returned by next without
+// an error. The exchanges outlive the call that triggered them, so their ctx is never canceled.
+type UnaryInterceptor func(ctx context.Context, method contract.RPCRegister, req, resp proto.Message, next Invoker) (base.RPCServerResponseCode, error)
+
+type internalCallKey struct{}
+
+// IsInternalCall reports whether ctx belongs to an RPC issued by the client itself,
+// such as the contract.Hello and contract.Auth exchanges, rather than by a Target or Report call.
+func IsInternalCall(ctx context.Context) bool {
+	v, _ := ctx.Value(internalCallKey{}).(bool)
+	return v
+}
+
+func withInternalCall(ctx context.Context) context.Context {
+	return context.WithValue(ctx, internalCallKey{}, true)
+}
+
+// intercept runs the interceptors in order around invoker.
+func intercept(ctx context.Context, interceptors []UnaryInterceptor, method contract.RPCRegister, req, resp proto.Message, invoker Invoker) (base.RPCServerResponseCode, error) {
+	if len(interceptors) == 0 {
+		return invoker(ctx, method, req, resp)
+	}
+	return interceptors[0](ctx, method, req, resp, func(ctx context.Context, method contract.RPCRegister, req, resp proto.Message) (base.RPCServerResponseCode, error) {
+		return intercept(ctx, interceptors[1:], method, req, resp, invoker)
+	})
+}
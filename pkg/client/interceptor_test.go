@@ -0,0 +1,119 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/google/uuid"
+	base "github.com/mygaru/dcr-sdk/gen/base1"
+	"github.com/mygaru/dcr-sdk/pkg/contract"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestInterceptorsRunInOrderAroundEveryCall(t *testing.T) {
+	var gotUID atomic.Value
+	addr := startTestServer(t, func(ctx *contract.RequestCtx) {
+		if ctx.Request.GetName() == contract.Auth {
+			writeTestAuthResponse(ctx, testReporterServerID)
+			return
+		}
+		var req base.TargetRequest
+		if err := proto.Unmarshal(ctx.Request.Value(), &req); err == nil && len(req.GetUids()) > 0 {
+			gotUID.Store(string(req.GetUids()[0].GetId()))
+		}
+		writeTestTargetResponse(ctx, "0400000000000001")
+	})
+
+	var mu sync.Mutex
+	var calls []string
+	record := func(name string) UnaryInterceptor {
+		return func(ctx context.Context, method contract.RPCRegister, req, resp proto.Message, next Invoker) (base.RPCServerResponseCode, error) {
+			mu.Lock()
+			calls = append(calls, name+":"+method.String()+":"+internalLabel(ctx))
+			mu.Unlock()
+			return next(ctx, method, req, resp)
+		}
+	}
+	scrub := func(ctx context.Context, method contract.RPCRegister, req, resp proto.Message, next Invoker) (base.RPCServerResponseCode, error) {
+		if r, ok := req.(*base.TargetRequest); ok {
+			r.Uids[0].Id = []byte("scrubbed")
+		}
+		return next(ctx, method, req, resp)
+	}
+
+	sc := NewClient(&Configuration{
+		Addrs:                          addr,
+		JwtToken:                       []byte(uuid.NewString()),
+		MaximumSimultaneousConnections: 1,
+		DNSRefreshInterval:             -1,
+		Interceptors:                   []UnaryInterceptor{record("first"), record("second"), scrub},
+	}, nil)
+
+	if _, _, err := sc.Target(testTargetRequest()); err != nil {
+		t.Fatalf("target: %v", err)
+	}
+
+	want := []string{
+		"first:target:external",
+		"second:target:external",
+		"first:hello:internal",
+		"second:hello:internal",
+		"first:auth:internal",
+		"second:auth:internal",
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(calls) != len(want) {
+		t.Fatalf("expected calls %v, got %v", want, calls)
+	}
+	for i := range want {
+		if calls[i] != want[i] {
+			t.Fatalf("expected calls %v, got %v", want, calls)
+		}
+	}
+	if got := gotUID.Load(); got != "scrubbed" {
+		t.Fatalf("expected the server to receive the request modified by the interceptor, got %v", got)
+	}
+}
+
+func TestInterceptorCanShortCircuitCall(t *testing.T) {
+	var requests atomic.Int64
+	addr := startTestServer(t, func(ctx *contract.RequestCtx) {
+		requests.Add(1)
+		writeTestTargetResponse(ctx, "0400000000000001")
+	})
+
+	errRejected := errors.New("rejected by interceptor")
+	sc := NewClient(&Configuration{
+		Addrs:                          addr,
+		DisableAuth:                    true,
+		MaximumSimultaneousConnections: 1,
+		DNSRefreshInterval:             -1,
+		Interceptors: []UnaryInterceptor{
+			func(ctx context.Context, method contract.RPCRegister, req, resp proto.Message, next Invoker) (base.RPCServerResponseCode, error) {
+				return base.RPCServerResponseCode_INVALID_REQUEST, errRejected
+			},
+		},
+	}, nil)
+
+	_, statusCode, err := sc.Target(testTargetRequest())
+	if !errors.Is(err, errRejected) {
+		t.Fatalf("expected the interceptor error, got %v", err)
+	}
+	if statusCode != base.RPCServerResponseCode_INVALID_REQUEST {
+		t.Fatalf("expected the interceptor status code, got %s", statusCode)
+	}
+	if got := requests.Load(); got != 0 {
+		t.Fatalf("expected no request to reach the server, got %d", got)
+	}
+}
+
+func internalLabel(ctx context.Context) string {
+	if IsInternalCall(ctx) {
+		return "internal"
+	}
+	return "external"
+}
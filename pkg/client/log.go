@@ -0,0 +1,93 @@
+package client
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// logInterval is the minimum interval between two log records of the same event for the same shard.
+const logInterval = time.Second
+
+// eventLogger writes structured client events to a slog.Logger, at most one record per event and
+// shard every logInterval. Records dropped by the limit are counted in the "suppressed" attribute
+// of the next record. A nil *eventLogger discards all events.
+type eventLogger struct {
+	logger *slog.Logger
+	now    func() time.Time
+
+	mu     sync.Mutex
+	events map[eventKey]*eventState
+}
+
+type eventKey struct {
+	event string
+	addr  string
+}
+
+type eventState struct {
+	next       time.Time
+	suppressed int
+}
+
+func newEventLogger(logger *slog.Logger) *eventLogger {
+	if logger == nil {
+		return nil
+	}
+	return &eventLogger{
+		logger: logger,
+		now:    time.Now,
+		events: make(map[eventKey]*eventState),
+	}
+}
+
+// log writes event for the shard addr unless the same event was logged for addr less than logInterval ago.
+func (l *eventLogger) log(level slog.Level, event, addr string, attrs ...slog.Attr) {
+	if l == nil || !l.logger.Enabled(context.Background(), level) {
+		return
+	}
+
+	now := l.now()
+	key := eventKey{event: event, addr: addr}
+	l.mu.Lock()
+	st := l.events[key]
+	if st == nil {
+		st = &eventState{}
+		l.events[key] = st
+	}
+	if now.Before(st.next) {
+		st.suppressed++
+		l.mu.Unlock()
+		return
+	}
+	suppressed := st.suppressed
+	st.suppressed = 0
+	st.next = now.Add(logInterval)
+	l.mu.Unlock()
+
+	attrs = append(attrs, slog.String("addr", addr))
+	if suppressed > 0 {
+		attrs = append(attrs, slog.Int("suppressed", suppressed))
+	}
+	l.logger.LogAttrs(context.Background(), level, event, attrs...)
+}
+
+// logDial logs that the connection of c was dialed for the gen-th time.
+func (c *client) logDial(gen uint64) {
+	if c.log == nil {
+		return
+	}
+	attrs := []slog.Attr{
+		slog.Uint64("conn_gen", gen),
+		slog.Int("server_id", int(c.GetServerID())),
+	}
+	if remoteAddr := c.remoteAddr.Load(); remoteAddr != nil {
+		attrs = append(attrs, slog.String("remote_addr", *remoteAddr))
+	}
+	if gen == 1 {
+		c.log.log(slog.LevelDebug, "connection dialed", c.addr, attrs...)
+		return
+	}
+	c.log.log(slog.LevelInfo, "reconnected", c.addr, attrs...)
+}
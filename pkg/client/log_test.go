@@ -0,0 +1,105 @@
+package client
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	base "github.com/mygaru/dcr-sdk/gen/base1"
+	"github.com/mygaru/dcr-sdk/pkg/contract"
+)
+
+type testLogHandler struct {
+	mu      sync.Mutex
+	records []slog.Record
+}
+
+func (h *testLogHandler) Enabled(context.Context, slog.Level) bool { return true }
+func (h *testLogHandler) WithAttrs([]slog.Attr) slog.Handler       { return h }
+func (h *testLogHandler) WithGroup(string) slog.Handler            { return h }
+
+func (h *testLogHandler) Handle(_ context.Context, r slog.Record) error {
+	h.mu.Lock()
+	h.records = append(h.records, r.Clone())
+	h.mu.Unlock()
+	return nil
+}
+
+func (h *testLogHandler) find(msg string) (slog.Record, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, r := range h.records {
+		if r.Message == msg {
+			return r, true
+		}
+	}
+	return slog.Record{}, false
+}
+
+func recordAttr(r slog.Record, key string) slog.Value {
+	var v slog.Value
+	r.Attrs(func(a slog.Attr) bool {
+		if a.Key == key {
+			v = a.Value
+			return false
+		}
+		return true
+	})
+	return v
+}
+
+func TestEventLoggerLimitsRateAndCountsSuppressed(t *testing.T) {
+	h := &testLogHandler{}
+	l := newEventLogger(slog.New(h))
+	now := time.Now()
+	l.now = func() time.Time { return now }
+
+	l.log(slog.LevelWarn, "request failed", "a:1")
+	l.log(slog.LevelWarn, "request failed", "a:1")
+	l.log(slog.LevelWarn, "request failed", "a:1")
+	l.log(slog.LevelWarn, "request failed", "b:1")
+	if len(h.records) != 2 {
+		t.Fatalf("expected one record per shard, got %d", len(h.records))
+	}
+
+	now = now.Add(logInterval)
+	l.log(slog.LevelWarn, "request failed", "a:1")
+	if len(h.records) != 3 {
+		t.Fatalf("expected a record after the interval, got %d", len(h.records))
+	}
+	if got := recordAttr(h.records[2], "suppressed").Int64(); got != 2 {
+		t.Fatalf("expected 2 suppressed records, got %d", got)
+	}
+}
+
+func TestClientLogsAuthAndRequestFailures(t *testing.T) {
+	addr := startTestServer(t, func(ctx *contract.RequestCtx) {
+		ctx.Response.SetStatusCode(base.RPCServerResponseCode_UNAUTHORIZED)
+	})
+	h := &testLogHandler{}
+	sc := NewClient(&Configuration{
+		Addrs:                          addr,
+		JwtToken:                       []byte(uuid.NewString()),
+		MaximumSimultaneousConnections: 1,
+		DNSRefreshInterval:             -1,
+		Logger:                         slog.New(h),
+	}, nil)
+
+	if _, _, err := sc.Target(testTargetRequest()); err == nil {
+		t.Fatalf("expected target to fail")
+	}
+
+	r, ok := h.find("auth failed")
+	if !ok {
+		t.Fatalf("expected an auth failure to be logged")
+	}
+	if got := recordAttr(r, "addr").String(); got != addr {
+		t.Fatalf("expected the shard address in the record, got %q", got)
+	}
+	if _, ok := h.find("connection dialed"); !ok {
+		t.Fatalf("expected the dial to be logged")
+	}
+}
@@ -0,0 +1,206 @@
+package client
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// shardSet is the list of shards of a client. It is never modified once published.
+type shardSet struct {
+	// clients holds all shards, including the draining ones, whose connections still receive Report calls.
+	clients []*clientsGroup
+
+	// active holds the shards that receive Target calls.
+	active []*clientsGroup
+
+	// endpoints holds active as balancer endpoints. A shard is listed Weight times,
+	// so balancers pick it proportionally to its weight.
+	endpoints []Endpoint
+}
+
+// shardSet returns the current shards.
+func (sc *ShardedClient) shardSet() *shardSet {
+	return sc.shards.Load()
+}
+
+// publishShards publishes clients as the new shards and rebuilds the Report routes over them.
+// The caller must hold shardsMu, except in NewClient.
+func (sc *ShardedClient) publishShards(clients []*clientsGroup) {
+	set := &shardSet{clients: clients}
+	for _, shard := range clients {
+		if shard.draining {
+			continue
+		}
+		set.active = append(set.active, shard)
+		for i := 0; i < shard.weight; i++ {
+			set.endpoints = append(set.endpoints, shard)
+		}
+	}
+	sc.shards.Store(set)
+	sc.rebuildRoutes()
+}
+
+// findShard returns the shard with addr, or nil.
+func (set *shardSet) findShard(addr string) *clientsGroup {
+	for _, shard := range set.clients {
+		if shard.addr == addr {
+			return shard
+		}
+	}
+	return nil
+}
+
+// AddShard adds a shard to the client. addr uses the Configuration.Addrs syntax for a single shard,
+// e.g. "1026@dcr-c.example.com:7937;weight=2". The shard receives Target calls right away;
+// its connections are dialed lazily like the ones created by NewClient.
+//
+// AddShard returns ErrShardExists if the client already has a shard with the address, including a draining one.
+func (sc *ShardedClient) AddShard(addr string) error {
+	shards, err := ParseShards(addr)
+	if err != nil {
+		return err
+	}
+	if len(shards) != 1 {
+		return fmt.Errorf("expected a single shard, got %d in %q", len(shards), addr)
+	}
+	shardCfg := sc.shardDefaults(shards[0])
+
+	sc.shardsMu.Lock()
+	defer sc.shardsMu.Unlock()
+
+	if sc.state.Load()&clientClosedFlag != 0 {
+		return ErrClosed
+	}
+	set := sc.shardSet()
+	if set.findShard(shardCfg.Addr) != nil {
+		return fmt.Errorf("%w: %s", ErrShardExists, shardCfg.Addr)
+	}
+	shard := sc.newShard(shardCfg)
+	sc.publishShards(append(append([]*clientsGroup(nil), set.clients...), shard))
+	sc.log.log(slog.LevelInfo, "shard added", shard.addr, slog.Int("weight", shard.weight))
+	return nil
+}
+
+// RemoveShard removes the shard with addr and closes its connections. Calls in flight over them
+// fail with NETWORK_ERROR, and Report calls for the tracking ids issued by the shard fail with ErrUnknownShard.
+// Use DrainShard to take a shard out of rotation gracefully.
+//
+// The last shard that receives Target calls can't be removed.
+func (sc *ShardedClient) RemoveShard(addr string) error {
+	sc.shardsMu.Lock()
+	shard := sc.shardSet().findShard(addr)
+	if shard == nil {
+		sc.shardsMu.Unlock()
+		return fmt.Errorf("%w: %s", ErrShardNotFound, addr)
+	}
+	err := sc.removeShardLocked(shard)
+	sc.shardsMu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	sc.shardRemoved(shard)
+	return nil
+}
+
+// removeShardLocked unpublishes shard. The caller must hold shardsMu and close the shard connections.
+func (sc *ShardedClient) removeShardLocked(shard *clientsGroup) error {
+	set := sc.shardSet()
+	if !shard.draining && len(set.active) == 1 {
+		return fmt.Errorf("%w: %s", ErrLastShard, shard.addr)
+	}
+	if shard.drainTimer != nil {
+		shard.drainTimer.Stop()
+	}
+
+	clients := make([]*clientsGroup, 0, len(set.clients)-1)
+	for _, g := range set.clients {
+		if g != shard {
+			clients = append(clients, g)
+		}
+	}
+	sc.publishShards(clients)
+	return nil
+}
+
+// DrainShard stops sending Target calls to the shard with addr. Report calls for the tracking ids issued
+// by the shard are still routed to it for Configuration.DrainGracePeriod, after which the shard is removed
+// like with RemoveShard. Draining a draining shard is a no-op.
+//
+// The last shard that receives Target calls can't be drained.
+func (sc *ShardedClient) DrainShard(addr string) error {
+	sc.shardsMu.Lock()
+	defer sc.shardsMu.Unlock()
+
+	set := sc.shardSet()
+	shard := set.findShard(addr)
+	if shard == nil {
+		return fmt.Errorf("%w: %s", ErrShardNotFound, addr)
+	}
+	if shard.draining {
+		return nil
+	}
+	if len(set.active) == 1 {
+		return fmt.Errorf("%w: %s", ErrLastShard, addr)
+	}
+
+	shard.draining = true
+	sc.publishShards(set.clients)
+	shard.drainTimer = time.AfterFunc(sc.DrainGracePeriod, func() {
+		sc.finishDrain(shard)
+	})
+	sc.log.log(slog.LevelInfo, "shard draining", shard.addr, slog.Duration("grace_period", sc.DrainGracePeriod))
+	return nil
+}
+
+// finishDrain removes shard once its drain grace period has expired, unless it has been removed already.
+func (sc *ShardedClient) finishDrain(shard *clientsGroup) {
+	sc.shardsMu.Lock()
+	if sc.shardSet().findShard(shard.addr) != shard {
+		sc.shardsMu.Unlock()
+		return
+	}
+	err := sc.removeShardLocked(shard)
+	sc.shardsMu.Unlock()
+	if err != nil {
+		return
+	}
+
+	sc.shardRemoved(shard)
+}
+
+// shardRemoved closes the connections of shard once it has been unpublished and notifies the removal watchers.
+func (sc *ShardedClient) shardRemoved(shard *clientsGroup) {
+	shard.dialer.close()
+	sc.log.log(slog.LevelInfo, "shard removed", shard.addr)
+
+	sc.removalWatchersMu.Lock()
+	watchers := make([]func(*clientsGroup), 0, len(sc.removalWatchers))
+	for _, fn := range sc.removalWatchers {
+		watchers = append(watchers, fn)
+	}
+	sc.removalWatchersMu.Unlock()
+
+	for _, fn := range watchers {
+		fn(shard)
+	}
+}
+
+// watchShardRemovals registers fn to be called after a shard has been removed. The returned function unregisters it.
+func (sc *ShardedClient) watchShardRemovals(fn func(shard *clientsGroup)) (unwatch func()) {
+	sc.removalWatchersMu.Lock()
+	defer sc.removalWatchersMu.Unlock()
+
+	if sc.removalWatchers == nil {
+		sc.removalWatchers = make(map[uint64]func(*clientsGroup))
+	}
+	sc.removalWatchersSeq++
+	id := sc.removalWatchersSeq
+	sc.removalWatchers[id] = fn
+	return func() {
+		sc.removalWatchersMu.Lock()
+		delete(sc.removalWatchers, id)
+		sc.removalWatchersMu.Unlock()
+	}
+}
@@ -0,0 +1,183 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	base "github.com/mygaru/dcr-sdk/gen/base1"
+	"github.com/mygaru/dcr-sdk/pkg/contract"
+)
+
+func TestAddShardReceivesTargetsAndReports(t *testing.T) {
+	var targetsA, reportsA, targetsB, reportsB atomic.Int64
+	addrA := startHelloTestServer(t, testServerConfig{hello: contract.HelloResponse{ServerID: 1024}, targets: &targetsA, reports: &reportsA})
+	addrB := startHelloTestServer(t, testServerConfig{hello: contract.HelloResponse{ServerID: 1025}, targets: &targetsB, reports: &reportsB})
+
+	sc := newTestShardedClient(Configuration{Addrs: "1024@" + addrA})
+	defer sc.Close()
+
+	if err := sc.AddShard("1025@" + addrB); err != nil {
+		t.Fatalf("add shard: %v", err)
+	}
+	if err := sc.AddShard(addrB); !errors.Is(err, ErrShardExists) {
+		t.Fatalf("expected ErrShardExists, got %v", err)
+	}
+	if err := sc.AddShard("dcr-c"); err == nil {
+		t.Fatalf("expected a malformed address to be rejected")
+	}
+
+	for i := 0; i < 10; i++ {
+		if _, _, err := sc.Target(testTargetRequest()); err != nil {
+			t.Fatalf("target: %v", err)
+		}
+	}
+	if a, b := targetsA.Load(), targetsB.Load(); a != 5 || b != 5 {
+		t.Fatalf("expected targets to be spread over both shards, got %d and %d", a, b)
+	}
+	if _, err := sc.Report(&base.ReportRequest{TrackingId: []byte("0401000000000001")}); err != nil {
+		t.Fatalf("report: %v", err)
+	}
+	if got := reportsB.Load(); got != 1 {
+		t.Fatalf("expected the report to reach the added shard, got %d", got)
+	}
+}
+
+func TestDrainShardKeepsReportsUntilGracePeriodExpires(t *testing.T) {
+	var targetsA, reportsA, targetsB, reportsB atomic.Int64
+	addrA := startHelloTestServer(t, testServerConfig{hello: contract.HelloResponse{ServerID: 1024}, targets: &targetsA, reports: &reportsA})
+	addrB := startHelloTestServer(t, testServerConfig{hello: contract.HelloResponse{ServerID: 1025}, targets: &targetsB, reports: &reportsB})
+
+	sc := newTestShardedClient(Configuration{Addrs: "1024@" + addrA + ",1025@" + addrB, DrainGracePeriod: 200 * time.Millisecond})
+	defer sc.Close()
+
+	if err := sc.DrainShard(addrB); err != nil {
+		t.Fatalf("drain shard: %v", err)
+	}
+	if err := sc.DrainShard(addrB); err != nil {
+		t.Fatalf("expected draining a draining shard to be a no-op, got %v", err)
+	}
+	if err := sc.DrainShard(addrA); !errors.Is(err, ErrLastShard) {
+		t.Fatalf("expected ErrLastShard, got %v", err)
+	}
+
+	for i := 0; i < 10; i++ {
+		if _, _, err := sc.Target(testTargetRequest()); err != nil {
+			t.Fatalf("target: %v", err)
+		}
+	}
+	if a, b := targetsA.Load(), targetsB.Load(); a != 10 || b != 0 {
+		t.Fatalf("expected no targets to reach the draining shard, got %d and %d", a, b)
+	}
+	if _, err := sc.Report(&base.ReportRequest{TrackingId: []byte("0401000000000001")}); err != nil {
+		t.Fatalf("report: %v", err)
+	}
+	if got := reportsB.Load(); got != 1 {
+		t.Fatalf("expected the report to reach the draining shard, got %d", got)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for len(sc.shardSet().clients) != 1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the drained shard to be removed after the grace period")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if _, err := sc.Report(&base.ReportRequest{TrackingId: []byte("0401000000000001")}); !errors.Is(err, ErrUnknownShard) {
+		t.Fatalf("expected ErrUnknownShard after the drained shard is removed, got %v", err)
+	}
+}
+
+func TestRemoveShard(t *testing.T) {
+	var targetsA, reportsA, targetsB, reportsB atomic.Int64
+	addrA := startHelloTestServer(t, testServerConfig{hello: contract.HelloResponse{ServerID: 1024}, targets: &targetsA, reports: &reportsA})
+	addrB := startHelloTestServer(t, testServerConfig{hello: contract.HelloResponse{ServerID: 1025}, targets: &targetsB, reports: &reportsB})
+
+	sc := newTestShardedClient(Configuration{Addrs: "1024@" + addrA + ",1025@" + addrB})
+
+	if err := sc.RemoveShard("127.0.0.1:1"); !errors.Is(err, ErrShardNotFound) {
+		t.Fatalf("expected ErrShardNotFound, got %v", err)
+	}
+	if err := sc.RemoveShard(addrB); err != nil {
+		t.Fatalf("remove shard: %v", err)
+	}
+	if err := sc.RemoveShard(addrA); !errors.Is(err, ErrLastShard) {
+		t.Fatalf("expected ErrLastShard, got %v", err)
+	}
+	if _, err := sc.Report(&base.ReportRequest{TrackingId: []byte("0401000000000001")}); !errors.Is(err, ErrUnknownShard) {
+		t.Fatalf("expected ErrUnknownShard for the removed shard, got %v", err)
+	}
+	for i := 0; i < 4; i++ {
+		if _, _, err := sc.Target(testTargetRequest()); err != nil {
+			t.Fatalf("target: %v", err)
+		}
+	}
+	if got := targetsB.Load(); got != 0 {
+		t.Fatalf("expected no targets to reach the removed shard, got %d", got)
+	}
+
+	// The shard can be added back after its removal.
+	if err := sc.AddShard("1025@" + addrB); err != nil {
+		t.Fatalf("add shard back: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := sc.Shutdown(ctx); err != nil {
+		t.Fatalf("shutdown: %v", err)
+	}
+	if err := sc.AddShard("127.0.0.1:1"); !errors.Is(err, ErrClosed) {
+		t.Fatalf("expected ErrClosed, got %v", err)
+	}
+}
+
+func TestShardChangesDuringTraffic(t *testing.T) {
+	var targetsA, reportsA, targetsB, reportsB atomic.Int64
+	addrA := startHelloTestServer(t, testServerConfig{hello: contract.HelloResponse{ServerID: 1024}, targets: &targetsA, reports: &reportsA})
+	addrB := startHelloTestServer(t, testServerConfig{hello: contract.HelloResponse{ServerID: 1025}, targets: &targetsB, reports: &reportsB})
+
+	sc := newTestShardedClient(Configuration{Addrs: "1024@" + addrA, DrainGracePeriod: time.Millisecond})
+	defer sc.Close()
+
+	// Targets in flight over a removed shard fail with NETWORK_ERROR, so only the lack of races
+	// and the traffic to the shard that stays are checked.
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			_, _, _ = sc.Target(testTargetRequest())
+		}
+	}()
+
+	for i := 0; i < 20; i++ {
+		if err := sc.AddShard("1025@" + addrB); err != nil {
+			t.Fatalf("add shard: %v", err)
+		}
+		if i%2 == 0 {
+			if err := sc.RemoveShard(addrB); err != nil {
+				t.Fatalf("remove shard: %v", err)
+			}
+			continue
+		}
+		if err := sc.DrainShard(addrB); err != nil {
+			t.Fatalf("drain shard: %v", err)
+		}
+		for sc.shardSet().findShard(addrB) != nil {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	close(done)
+	<-stopped
+
+	if targetsA.Load() == 0 {
+		t.Fatalf("expected targets to reach the remaining shard")
+	}
+}
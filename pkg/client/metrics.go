@@ -1,28 +1,397 @@
 package client
 
 import (
-	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
 	"github.com/VictoriaMetrics/metrics"
 )
 
+const defaultMetricsPrefix = "dcrRPCClient"
+
+// MetricsConfig configures the metrics of a ShardedClient.
+type MetricsConfig struct {
+	// Prefix is prepended to every metric name. Defaults to "dcrRPCClient".
+	Prefix string
+
+	// Labels are added to every metric, e.g. {"client": "eu-bidder"}, so clients pointing at
+	// the same shards can be told apart.
+	Labels map[string]string
+
+	// Sink receives every metric update in addition to the metrics.Set of the client.
+	Sink MetricsSink
+
+	// DisableGlobalRegistration keeps the metrics of the client out of metrics.WritePrometheus.
+	// They are still written by ShardedClient.WritePrometheus.
+	//
+	// A globally registered client whose Prefix and Labels equal the ones of another globally registered client
+	// gets an additional client_id label, numbered from 2, so metrics.WritePrometheus doesn't write the same series twice.
+	DisableGlobalRegistration bool
+}
+
+// MetricsSink receives the client metrics for monitoring systems other than VictoriaMetrics and Prometheus.
+//
+// name is the metric name with the prefix and without labels. labels include MetricsConfig.Labels;
+// the map is shared between calls and must not be modified. AddCounter and ObserveHistogram are called
+// concurrently on the request path and must not block.
+type MetricsSink interface {
+	// AddCounter adds delta to a counter.
+	AddCounter(name string, labels map[string]string, delta uint64)
+
+	// ObserveHistogram records a histogram sample. Durations are recorded in seconds.
+	ObserveHistogram(name string, labels map[string]string, value float64)
+
+	// RegisterGauge registers a gauge whose current value is returned by value.
+	// It is called once per gauge, when the client or the shard the gauge belongs to is created.
+	RegisterGauge(name string, labels map[string]string, value func() float64)
+}
+
+// metricsRegistry creates the metrics of a client in its own metrics.Set.
+type metricsRegistry struct {
+	set    *metrics.Set
+	prefix string
+	labels []string
+	sink   MetricsSink
+
+	// gauges holds the current value functions of the gauges by full name, so a gauge registered again,
+	// e.g. for a shard added back after its removal, reports the new value.
+	gaugesMu sync.Mutex
+	gauges   map[string]*atomic.Pointer[func() float64]
+
+	// globalKey identifies the registry in globalSets while its set is registered globally.
+	globalKey string
+}
+
+// globalSets holds the prefixes and labels of the sets registered with metrics.RegisterSet.
+var globalSets struct {
+	mu   sync.Mutex
+	keys map[string]struct{}
+}
+
+func newMetricsRegistry(cfg MetricsConfig) *metricsRegistry {
+	r := &metricsRegistry{
+		set:    metrics.NewSet(),
+		prefix: cfg.Prefix,
+		sink:   cfg.Sink,
+	}
+	if r.prefix == "" {
+		r.prefix = defaultMetricsPrefix
+	}
+	if len(cfg.Labels) > 0 {
+		r.labels = sortedLabels(cfg.Labels)
+	}
+	return r
+}
+
+// registerGlobal registers the set with metrics.RegisterSet. If another registered set has the same prefix
+// and labels, a client_id label is added first, so the series of both stay distinct.
+// It must be called before any metric is created.
+func (r *metricsRegistry) registerGlobal() {
+	globalSets.mu.Lock()
+	defer globalSets.mu.Unlock()
+
+	if globalSets.keys == nil {
+		globalSets.keys = make(map[string]struct{})
+	}
+	labels := r.labels
+	key := r.prefix + strings.Join(labels, "\x00")
+	for id := 2; ; id++ {
+		if _, ok := globalSets.keys[key]; !ok {
+			break
+		}
+		labels = withLabel(r.labels, "client_id", strconv.Itoa(id))
+		key = r.prefix + strings.Join(labels, "\x00")
+	}
+	r.labels = labels
+	r.globalKey = key
+	globalSets.keys[key] = struct{}{}
+	metrics.RegisterSet(r.set)
+}
+
+// unregisterGlobal undoes registerGlobal.
+func (r *metricsRegistry) unregisterGlobal() {
+	metrics.UnregisterSet(r.set, false)
+
+	globalSets.mu.Lock()
+	delete(globalSets.keys, r.globalKey)
+	globalSets.mu.Unlock()
+}
+
+// withLabel returns the key-value pairs of labels sorted by key with name set to value.
+func withLabel(labels []string, name, value string) []string {
+	m := make(map[string]string, len(labels)/2+1)
+	for i := 0; i+1 < len(labels); i += 2 {
+		m[labels[i]] = labels[i+1]
+	}
+	m[name] = value
+	return sortedLabels(m)
+}
+
+func sortedLabels(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	labels := make([]string, 0, 2*len(keys))
+	for _, k := range keys {
+		labels = append(labels, k, m[k])
+	}
+	return labels
+}
+
+// name returns the full metric name with the static labels followed by labels, which are key-value pairs.
+func (r *metricsRegistry) name(name string, labels []string) (string, map[string]string) {
+	var sb strings.Builder
+	sb.WriteString(r.prefix)
+	sb.WriteString(name)
+	sb.WriteByte('{')
+
+	var m map[string]string
+	if r.sink != nil {
+		m = make(map[string]string, (len(r.labels)+len(labels))/2)
+	}
+	all := append(append([]string(nil), r.labels...), labels...)
+	for i := 0; i+1 < len(all); i += 2 {
+		if i > 0 {
+			sb.WriteByte(',')
+		}
+		sb.WriteString(all[i])
+		sb.WriteByte('=')
+		sb.WriteString(strconv.Quote(all[i+1]))
+		if m != nil {
+			m[all[i]] = all[i+1]
+		}
+	}
+	sb.WriteByte('}')
+	return sb.String(), m
+}
+
+func (r *metricsRegistry) counter(name string, labels ...string) *counter {
+	full, m := r.name(name, labels)
+	return &counter{
+		Counter: r.set.GetOrCreateCounter(full),
+		sink:    r.sink,
+		name:    r.prefix + name,
+		labels:  m,
+	}
+}
+
+func (r *metricsRegistry) histogram(name string, labels ...string) *histogram {
+	full, m := r.name(name, labels)
+	return &histogram{
+		Histogram: r.set.GetOrCreateHistogram(full),
+		sink:      r.sink,
+		name:      r.prefix + name,
+		labels:    m,
+	}
+}
+
+func (r *metricsRegistry) gauge(name string, value func() float64, labels ...string) {
+	full, m := r.name(name, labels)
+
+	r.gaugesMu.Lock()
+	defer r.gaugesMu.Unlock()
+	if current := r.gauges[full]; current != nil {
+		current.Store(&value)
+		return
+	}
+	current := &atomic.Pointer[func() float64]{}
+	current.Store(&value)
+	if r.gauges == nil {
+		r.gauges = make(map[string]*atomic.Pointer[func() float64])
+	}
+	r.gauges[full] = current
+
+	get := func() float64 { return (*current.Load())() }
+	r.set.GetOrCreateGauge(full, get)
+	if r.sink != nil {
+		r.sink.RegisterGauge(r.prefix+name, m, get)
+	}
+}
+
+// counter is a metrics.Counter that also reports to a MetricsSink.
+type counter struct {
+	*metrics.Counter
+	sink   MetricsSink
+	name   string
+	labels map[string]string
+}
+
+func (c *counter) Inc() {
+	c.Counter.Inc()
+	if c.sink != nil {
+		c.sink.AddCounter(c.name, c.labels, 1)
+	}
+}
+
+func (c *counter) Add(n int) {
+	c.Counter.Add(n)
+	if c.sink != nil && n > 0 {
+		c.sink.AddCounter(c.name, c.labels, uint64(n))
+	}
+}
+
+// histogram is a metrics.Histogram that also reports to a MetricsSink.
+type histogram struct {
+	*metrics.Histogram
+	sink   MetricsSink
+	name   string
+	labels map[string]string
+}
+
+func (h *histogram) Update(v float64) {
+	h.Histogram.Update(v)
+	if h.sink != nil {
+		h.sink.ObserveHistogram(h.name, h.labels, v)
+	}
+}
+
+func (h *histogram) UpdateDuration(startTime time.Time) {
+	h.Update(time.Since(startTime).Seconds())
+}
+
 type metricsGroup struct {
-	error    *metrics.Counter
-	success  *metrics.Counter
-	timeout  *metrics.Counter
-	failed   *metrics.Counter
-	overflow *metrics.Counter
-	request  *metrics.Counter
-	duration *metrics.Histogram
+	error    *counter
+	success  *counter
+	timeout  *counter
+	failed   *counter
+	overflow *counter
+	canceled *counter
+	late     *counter
+	request  *counter
+	duration *histogram
 }
 
-func newMetricsGroup(request, addr string) *metricsGroup {
+func newMetricsGroup(r *metricsRegistry, request, addr string) *metricsGroup {
 	return &metricsGroup{
-		error:    metrics.GetOrCreateCounter(fmt.Sprintf(`dcrRPCClientError{request=%q,addr=%q,err="other"}`, request, addr)),
-		failed:   metrics.GetOrCreateCounter(fmt.Sprintf(`dcrRPCClientError{request=%q,addr=%q,err="failed"}`, request, addr)),
-		timeout:  metrics.GetOrCreateCounter(fmt.Sprintf(`dcrRPCClientError{request=%q,addr=%q,err="timeout"}`, request, addr)),
-		overflow: metrics.GetOrCreateCounter(fmt.Sprintf(`dcrRPCClientError{request=%q,addr=%q,err="overflow"}`, request, addr)),
-		success:  metrics.GetOrCreateCounter(fmt.Sprintf(`dcrRPCClientSuccess{request=%q,addr=%q}`, request, addr)),
-		request:  metrics.GetOrCreateCounter(fmt.Sprintf(`dcrRPCClientRequest{request=%q,addr=%q}`, request, addr)),
-		duration: metrics.GetOrCreateHistogram(fmt.Sprintf(`dcrRPCClientDuration{request=%q,addr=%q}`, request, addr)),
+		error:    r.counter("Error", "request", request, "addr", addr, "err", "other"),
+		failed:   r.counter("Error", "request", request, "addr", addr, "err", "failed"),
+		timeout:  r.counter("Error", "request", request, "addr", addr, "err", "timeout"),
+		overflow: r.counter("Error", "request", request, "addr", addr, "err", "overflow"),
+		canceled: r.counter("Error", "request", request, "addr", addr, "err", "canceled"),
+		late:     r.counter("LateResponse", "request", request, "addr", addr),
+		success:  r.counter("Success", "request", request, "addr", addr),
+		request:  r.counter("Request", "request", request, "addr", addr),
+		duration: r.histogram("Duration", "request", request, "addr", addr),
+	}
+}
+
+type reportQueueMetrics struct {
+	sent    *counter
+	failed  *counter
+	dropped *counter
+}
+
+func newReportQueueMetrics(r *metricsRegistry, addr string, size func() float64) *reportQueueMetrics {
+	r.gauge("ReportQueueSize", size, "addr", addr)
+	return &reportQueueMetrics{
+		sent:    r.counter("ReportQueue", "addr", addr, "result", "sent"),
+		failed:  r.counter("ReportQueue", "addr", addr, "result", "failed"),
+		dropped: r.counter("ReportQueue", "addr", addr, "result", "dropped"),
+	}
+}
+
+type routingMetrics struct {
+	invalid   *counter
+	unknown   *counter
+	misrouted *counter
+}
+
+func newRoutingMetrics(r *metricsRegistry) *routingMetrics {
+	return &routingMetrics{
+		invalid:   r.counter("ReportRouting", "result", "invalid_id"),
+		unknown:   r.counter("ReportRouting", "result", "unknown_server"),
+		misrouted: r.counter("ReportRouting", "result", "misrouted"),
+	}
+}
+
+// spoolMetrics are the metrics of a Spool. They are kept in a registry of their own until the spool
+// is attached to a client, and then in the registry of the client.
+type spoolMetrics struct {
+	spooled   *counter
+	replayed  *counter
+	expired   *counter
+	dropped   *counter
+	discarded *counter
+	deferred  *counter
+	corrupted *counter
+}
+
+func newSpoolMetrics(r *metricsRegistry, dir string, size func() float64) *spoolMetrics {
+	r.gauge("SpoolSize", size, "dir", dir)
+	return &spoolMetrics{
+		spooled:   r.counter("Spool", "dir", dir, "event", "spooled"),
+		replayed:  r.counter("Spool", "dir", dir, "event", "replayed"),
+		expired:   r.counter("Spool", "dir", dir, "event", "expired"),
+		dropped:   r.counter("Spool", "dir", dir, "event", "dropped"),
+		discarded: r.counter("Spool", "dir", dir, "event", "discarded"),
+		deferred:  r.counter("Spool", "dir", dir, "event", "deferred"),
+		corrupted: r.counter("Spool", "dir", dir, "event", "corrupted"),
+	}
+}
+
+// add adds the counts of prev.
+func (m *spoolMetrics) add(prev *spoolMetrics) {
+	m.spooled.Add(int(prev.spooled.Get()))
+	m.replayed.Add(int(prev.replayed.Get()))
+	m.expired.Add(int(prev.expired.Get()))
+	m.dropped.Add(int(prev.dropped.Get()))
+	m.discarded.Add(int(prev.discarded.Get()))
+	m.deferred.Add(int(prev.deferred.Get()))
+	m.corrupted.Add(int(prev.corrupted.Get()))
+}
+
+type retryMetrics struct {
+	retries         *counter
+	budgetExhausted *counter
+}
+
+func newRetryMetrics(r *metricsRegistry, request string) *retryMetrics {
+	return &retryMetrics{
+		retries:         r.counter("Retry", "request", request, "result", "retried"),
+		budgetExhausted: r.counter("Retry", "request", request, "result", "budget_exhausted"),
+	}
+}
+
+type hedgeMetrics struct {
+	sent *counter
+	won  *counter
+}
+
+func newHedgeMetrics(r *metricsRegistry, addr string) *hedgeMetrics {
+	return &hedgeMetrics{
+		sent: r.counter("Hedge", "addr", addr, "result", "sent"),
+		won:  r.counter("Hedge", "addr", addr, "result", "won"),
+	}
+}
+
+type breakerMetrics struct {
+	opened   *counter
+	halfOpen *counter
+	closed   *counter
+}
+
+func newBreakerMetrics(r *metricsRegistry, addr string, state func() float64) *breakerMetrics {
+	r.gauge("BreakerState", state, "addr", addr)
+	return &breakerMetrics{
+		opened:   r.counter("BreakerTransition", "addr", addr, "state", "open"),
+		halfOpen: r.counter("BreakerTransition", "addr", addr, "state", "half-open"),
+		closed:   r.counter("BreakerTransition", "addr", addr, "state", "closed"),
+	}
+}
+
+func (m *breakerMetrics) transition(to BreakerState) {
+	switch to {
+	case BreakerOpen:
+		m.opened.Inc()
+	case BreakerHalfOpen:
+		m.halfOpen.Inc()
+	case BreakerClosed:
+		m.closed.Inc()
 	}
 }
@@ -0,0 +1,178 @@
+package client
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/VictoriaMetrics/metrics"
+	"github.com/mygaru/dcr-sdk/pkg/contract"
+)
+
+func TestClientsPointingAtSameShardHaveSeparateMetrics(t *testing.T) {
+	newTestClient := func(name string) *ShardedClient {
+		return NewClient(&Configuration{
+			Addrs:              "metrics-set.local:7943",
+			DisableAuth:        true,
+			DNSRefreshInterval: -1,
+			Metrics: MetricsConfig{
+				Prefix:                    "bidderDCR",
+				Labels:                    map[string]string{"client": name},
+				DisableGlobalRegistration: true,
+			},
+		}, nil)
+	}
+	eu := newTestClient("eu-bidder")
+	us := newTestClient("us-bidder")
+
+	eu.shardSet().clients[0].clients[0].metricGroups[contract.Target].request.Inc()
+
+	var euOut, usOut bytes.Buffer
+	eu.WritePrometheus(&euOut)
+	us.WritePrometheus(&usOut)
+
+	want := `bidderDCRRequest{client="eu-bidder",request="target",addr="metrics-set.local:7943"} 1`
+	if !strings.Contains(euOut.String(), want) {
+		t.Fatalf("expected %s in\n%s", want, euOut.String())
+	}
+	if !strings.Contains(usOut.String(), `bidderDCRRequest{client="us-bidder",request="target",addr="metrics-set.local:7943"} 0`) {
+		t.Fatalf("expected the other client counter to stay at zero in\n%s", usOut.String())
+	}
+
+	var global bytes.Buffer
+	metrics.WritePrometheus(&global, false)
+	if strings.Contains(global.String(), "bidderDCR") {
+		t.Fatalf("expected the client metrics to stay out of the global set")
+	}
+}
+
+func TestClientMetricsAreRegisteredGloballyUntilClose(t *testing.T) {
+	sc := NewClient(&Configuration{
+		Addrs:              "metrics-global.local:7943",
+		DisableAuth:        true,
+		DNSRefreshInterval: -1,
+		Metrics: MetricsConfig{
+			Labels: map[string]string{"client": "metrics-global"},
+		},
+	}, nil)
+
+	name := `dcrRPCClientRequest{client="metrics-global",request="report",addr="metrics-global.local:7943"}`
+	var global bytes.Buffer
+	metrics.WritePrometheus(&global, false)
+	if !strings.Contains(global.String(), name) {
+		t.Fatalf("expected %s in the global metrics", name)
+	}
+
+	_ = sc.Close()
+	global.Reset()
+	metrics.WritePrometheus(&global, false)
+	if strings.Contains(global.String(), name) {
+		t.Fatalf("expected %s to be unregistered after Close", name)
+	}
+}
+
+func TestGloballyRegisteredClientsWithSameLabelsGetClientID(t *testing.T) {
+	newTestClient := func() *ShardedClient {
+		return NewClient(&Configuration{
+			Addrs:              "metrics-duplicate.local:7943",
+			DisableAuth:        true,
+			DNSRefreshInterval: -1,
+			Metrics: MetricsConfig{
+				Labels: map[string]string{"client": "metrics-duplicate"},
+			},
+		}, nil)
+	}
+	first := newTestClient()
+	defer first.Close()
+	second := newTestClient()
+	defer second.Close()
+
+	var global bytes.Buffer
+	metrics.WritePrometheus(&global, false)
+	seen := make(map[string]bool)
+	for _, line := range strings.Split(global.String(), "\n") {
+		if !strings.Contains(line, "metrics-duplicate") || strings.HasPrefix(line, "#") {
+			continue
+		}
+		series, _, _ := strings.Cut(line, " ")
+		if seen[series] {
+			t.Fatalf("expected every series once, got %s twice", series)
+		}
+		seen[series] = true
+	}
+
+	want := `dcrRPCClientRequest{client="metrics-duplicate",client_id="2",request="target",addr="metrics-duplicate.local:7943"}`
+	if !seen[want] {
+		t.Fatalf("expected %s in the global metrics", want)
+	}
+
+	// The label is released when the client is closed.
+	_ = second.Close()
+	third := newTestClient()
+	defer third.Close()
+	var out bytes.Buffer
+	third.WritePrometheus(&out)
+	if !strings.Contains(out.String(), `client_id="2"`) {
+		t.Fatalf("expected the released client_id to be reused in\n%s", out.String())
+	}
+}
+
+type testMetricsSink struct {
+	mu       sync.Mutex
+	counters map[string]uint64
+	samples  map[string]int
+	gauges   map[string]func() float64
+}
+
+func newTestMetricsSink() *testMetricsSink {
+	return &testMetricsSink{
+		counters: make(map[string]uint64),
+		samples:  make(map[string]int),
+		gauges:   make(map[string]func() float64),
+	}
+}
+
+func (s *testMetricsSink) AddCounter(name string, labels map[string]string, delta uint64) {
+	s.mu.Lock()
+	s.counters[name+"/"+labels["client"]+"/"+labels["request"]] += delta
+	s.mu.Unlock()
+}
+
+func (s *testMetricsSink) ObserveHistogram(name string, labels map[string]string, value float64) {
+	s.mu.Lock()
+	s.samples[name+"/"+labels["request"]]++
+	s.mu.Unlock()
+}
+
+func (s *testMetricsSink) RegisterGauge(name string, labels map[string]string, value func() float64) {
+	s.mu.Lock()
+	s.gauges[name+"/"+labels["addr"]] = value
+	s.mu.Unlock()
+}
+
+func TestMetricsSinkReceivesUpdates(t *testing.T) {
+	sink := newTestMetricsSink()
+	registry := newMetricsRegistry(MetricsConfig{
+		Labels: map[string]string{"client": "eu-bidder"},
+		Sink:   sink,
+	})
+	group := newMetricsGroup(registry, "target", "sink.local:7943")
+	group.request.Inc()
+	group.request.Add(2)
+	group.duration.Update(0.5)
+	newBreakerMetrics(registry, "sink.local:7943", func() float64 { return 1 })
+
+	if got := sink.counters["dcrRPCClientRequest/eu-bidder/target"]; got != 3 {
+		t.Fatalf("expected the sink counter to be 3, got %d", got)
+	}
+	if got := group.request.Get(); got != 3 {
+		t.Fatalf("expected the set counter to be 3, got %d", got)
+	}
+	if got := sink.samples["dcrRPCClientDuration/target"]; got != 1 {
+		t.Fatalf("expected a histogram sample, got %d", got)
+	}
+	if gauge := sink.gauges["dcrRPCClientBreakerState/sink.local:7943"]; gauge == nil || gauge() != 1 {
+		t.Fatalf("expected the breaker gauge to be registered")
+	}
+}
@@ -0,0 +1,371 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	base "github.com/mygaru/dcr-sdk/gen/base1"
+)
+
+const (
+	defaultReportQueueSize     = 4096
+	defaultReportQueueWorker   = 4
+	defaultReportFlushInterval = 5 * time.Millisecond
+)
+
+var (
+	// ErrReporterClosed is returned by AsyncReporter.Enqueue after Close has been called.
+	ErrReporterClosed = errors.New("async reporter is closed")
+
+	// ErrReportQueueFull is returned by AsyncReporter.Enqueue when the shard queue is full and the report is dropped.
+	ErrReportQueueFull = errors.New("report queue is full")
+)
+
+// AsyncReporterOptions configures an AsyncReporter.
+type AsyncReporterOptions struct {
+	// QueueSize is the maximum number of reports buffered for each shard.
+	// Reports enqueued while the shard queue is full are dropped.
+	// By default 4096.
+	QueueSize int
+
+	// BatchSize is the maximum number of reports sent together.
+	// Up to Workers * BatchSize reports are in flight for each shard, so they should fit into
+	// MaximumSimultaneousConnections * MaxPendingRequests of the client.
+	// By default MaxPendingRequests of the client.
+	BatchSize int
+
+	// FlushInterval is how long a worker waits for a batch to fill up before sending it.
+	// By default 5ms.
+	FlushInterval time.Duration
+
+	// Workers is the number of batches in flight for each shard. Every worker sends its batches
+	// with BatchSize goroutines started along with it.
+	// By default 4.
+	Workers int
+
+	// OnError is called for every report that could not be delivered, concurrently for the reports of a batch.
+	// It must not block for long, since the worker doesn't send the next batch until the current one is done.
+	OnError func(req *base.ReportRequest, statusCode base.RPCServerResponseCode, err error)
+}
+
+// AsyncReporter sends Report requests in the background, so the caller doesn't pay for the round trip.
+//
+// Reports are routed by the server ID encoded in the tracking id into a bounded queue per shard,
+// so a slow shard doesn't delay reports for the others. Workers take the queued reports in batches
+// of up to BatchSize, waiting at most FlushInterval for a batch to fill up. The protocol has no batch
+// Report request, so the reports of a batch are sent as pipelined Report requests: all of them are queued
+// on the connections at once and fastrpc writes the requests queued on a connection with a single flush.
+//
+// The queue of a shard is closed once the shard is removed from the client. Its remaining reports fail
+// and are passed to OnError.
+type AsyncReporter struct {
+	sc   *ShardedClient
+	opts AsyncReporterOptions
+
+	// ctx is passed to every Report call; it is canceled when Close gives up waiting for the queues to drain.
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// aborted is set when Close gives up waiting; the reports not sent yet are passed to OnError instead.
+	aborted atomic.Bool
+
+	mu     sync.RWMutex
+	closed bool
+	queues map[*clientsGroup]*reportQueue
+	wg     sync.WaitGroup
+
+	// unwatch stops the removal of the queues of removed shards.
+	unwatch func()
+
+	pendingMu sync.Mutex
+	pending   int
+	idle      chan struct{}
+}
+
+// reportQueue holds the reports waiting to be sent to a single shard.
+type reportQueue struct {
+	ch      chan *base.ReportRequest
+	metrics *reportQueueMetrics
+
+	// closed is set under AsyncReporter.mu once ch is closed.
+	closed bool
+}
+
+// NewAsyncReporter creates an AsyncReporter that sends reports through sc.
+func NewAsyncReporter(sc *ShardedClient, opts AsyncReporterOptions) *AsyncReporter {
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = defaultReportQueueSize
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = sc.MaxPendingRequests
+	}
+	if opts.FlushInterval <= 0 {
+		opts.FlushInterval = defaultReportFlushInterval
+	}
+	if opts.Workers <= 0 {
+		opts.Workers = defaultReportQueueWorker
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r := &AsyncReporter{
+		sc:     sc,
+		opts:   opts,
+		ctx:    ctx,
+		cancel: cancel,
+		queues: make(map[*clientsGroup]*reportQueue),
+	}
+	r.unwatch = sc.watchShardRemovals(r.removeQueue)
+	return r
+}
+
+// Enqueue schedules req for sending and returns without waiting for the server.
+//
+// Enqueue fails synchronously if the tracking id is missing or unknown, if the shard queue is full
+// (ErrReportQueueFull) or if the reporter is closed (ErrReporterClosed).
+// req must not be modified after a successful Enqueue.
+func (r *AsyncReporter) Enqueue(req *base.ReportRequest) error {
+	rt, err := r.sc.routeTrackingID(req.TrackingId)
+	if err != nil {
+		r.sc.countUnroutable(err)
+		return err
+	}
+
+	q, err := r.queueFor(rt.shard)
+	if err != nil {
+		return err
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if r.closed {
+		return ErrReporterClosed
+	}
+	if q.closed {
+		// The shard has been removed since the tracking id was routed.
+		return ErrUnknownShard
+	}
+
+	r.addPending()
+	select {
+	case q.ch <- req:
+		return nil
+	default:
+		r.donePending()
+		q.metrics.dropped.Inc()
+		return ErrReportQueueFull
+	}
+}
+
+// Flush waits until every report enqueued so far has been sent or ctx is done.
+func (r *AsyncReporter) Flush(ctx context.Context) error {
+	r.pendingMu.Lock()
+	if r.pending == 0 {
+		r.pendingMu.Unlock()
+		return nil
+	}
+	idle := r.idle
+	r.pendingMu.Unlock()
+
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close stops accepting new reports and waits until the queued ones are sent.
+//
+// If ctx is done first, Close cancels the reports in flight and drops the queued ones: they are passed
+// to OnError with ErrReporterClosed without being sent. Close waits for that and returns ctx.Err().
+// The canceled reports fail with NETWORK_ERROR like any other Report call, so they are spooled
+// if the client has a ReportSpool.
+func (r *AsyncReporter) Close(ctx context.Context) error {
+	r.unwatch()
+	r.mu.Lock()
+	if !r.closed {
+		r.closed = true
+		for shard, q := range r.queues {
+			q.close()
+			delete(r.queues, shard)
+		}
+	}
+	r.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		r.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		r.cancel()
+		return nil
+	case <-ctx.Done():
+	}
+
+	r.aborted.Store(true)
+	r.cancel()
+	<-done
+	return ctx.Err()
+}
+
+// queueFor returns the queue for shard, starting its workers on first use.
+func (r *AsyncReporter) queueFor(shard *clientsGroup) (*reportQueue, error) {
+	r.mu.RLock()
+	q := r.queues[shard]
+	closed := r.closed
+	r.mu.RUnlock()
+	if closed {
+		return nil, ErrReporterClosed
+	}
+	if q != nil {
+		return q, nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return nil, ErrReporterClosed
+	}
+	if q = r.queues[shard]; q != nil {
+		return q, nil
+	}
+	if r.sc.shardSet().findShard(shard.addr) != shard {
+		// The shard has been removed since the tracking id was routed.
+		return nil, ErrUnknownShard
+	}
+
+	q = &reportQueue{
+		ch: make(chan *base.ReportRequest, r.opts.QueueSize),
+	}
+	q.metrics = newReportQueueMetrics(r.sc.metrics, shard.addr, func() float64 {
+		return float64(len(q.ch))
+	})
+	r.queues[shard] = q
+
+	for i := 0; i < r.opts.Workers; i++ {
+		r.wg.Add(1)
+		go r.worker(q)
+	}
+	return q, nil
+}
+
+// removeQueue closes the queue of a removed shard, so its workers exit once the remaining reports fail.
+func (r *AsyncReporter) removeQueue(shard *clientsGroup) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if q := r.queues[shard]; q != nil {
+		q.close()
+		delete(r.queues, shard)
+	}
+}
+
+// close closes the queue. The caller must hold AsyncReporter.mu.
+func (q *reportQueue) close() {
+	if !q.closed {
+		q.closed = true
+		close(q.ch)
+	}
+}
+
+func (r *AsyncReporter) worker(q *reportQueue) {
+	defer r.wg.Done()
+
+	// The reports of a batch are sent concurrently, so they are pipelined over the connections.
+	jobs := make(chan *base.ReportRequest)
+	var sent sync.WaitGroup
+	for i := 0; i < r.opts.BatchSize; i++ {
+		go func() {
+			for req := range jobs {
+				r.send(q, req)
+				sent.Done()
+			}
+		}()
+	}
+	defer close(jobs)
+
+	batch := make([]*base.ReportRequest, 0, r.opts.BatchSize)
+	timer := time.NewTimer(r.opts.FlushInterval)
+	timer.Stop()
+	for {
+		var ok bool
+		batch, ok = r.nextBatch(q, batch[:0], timer)
+		sent.Add(len(batch))
+		for _, req := range batch {
+			jobs <- req
+		}
+		sent.Wait()
+		if !ok {
+			return
+		}
+	}
+}
+
+// nextBatch waits for a report and appends it to batch together with the reports enqueued within
+// FlushInterval, up to BatchSize. It returns false once the queue is closed and drained.
+func (r *AsyncReporter) nextBatch(q *reportQueue, batch []*base.ReportRequest, timer *time.Timer) ([]*base.ReportRequest, bool) {
+	req, ok := <-q.ch
+	if !ok {
+		return batch, false
+	}
+	batch = append(batch, req)
+
+	timer.Reset(r.opts.FlushInterval)
+	defer timer.Stop()
+	for len(batch) < r.opts.BatchSize {
+		select {
+		case req, ok := <-q.ch:
+			if !ok {
+				return batch, false
+			}
+			batch = append(batch, req)
+		case <-timer.C:
+			return batch, true
+		}
+	}
+	return batch, true
+}
+
+func (r *AsyncReporter) send(q *reportQueue, req *base.ReportRequest) {
+	defer r.donePending()
+	if r.aborted.Load() {
+		q.metrics.failed.Inc()
+		if r.opts.OnError != nil {
+			r.opts.OnError(req, base.RPCServerResponseCode_UNKNOWN, ErrReporterClosed)
+		}
+		return
+	}
+
+	statusCode, err := r.sc.ReportContext(r.ctx, req)
+	if err != nil {
+		q.metrics.failed.Inc()
+		if r.opts.OnError != nil {
+			r.opts.OnError(req, statusCode, err)
+		}
+	} else {
+		q.metrics.sent.Inc()
+	}
+}
+
+func (r *AsyncReporter) addPending() {
+	r.pendingMu.Lock()
+	if r.pending == 0 {
+		r.idle = make(chan struct{})
+	}
+	r.pending++
+	r.pendingMu.Unlock()
+}
+
+func (r *AsyncReporter) donePending() {
+	r.pendingMu.Lock()
+	r.pending--
+	if r.pending == 0 {
+		close(r.idle)
+	}
+	r.pendingMu.Unlock()
+}
@@ -0,0 +1,224 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	base "github.com/mygaru/dcr-sdk/gen/base1"
+	"github.com/mygaru/dcr-sdk/pkg/contract"
+)
+
+const testReporterServerID = 1024
+
+func TestAsyncReporterFlushSendsEnqueuedReports(t *testing.T) {
+	var received atomic.Int64
+	addr := startTestServer(t, func(ctx *contract.RequestCtx) {
+		if ctx.Request.GetName() == contract.Report {
+			received.Add(1)
+		}
+		ctx.Response.SetStatusCode(base.RPCServerResponseCode_OK)
+	})
+	sc := newTestShardedClient(Configuration{Addrs: "1024@" + addr, MaximumSimultaneousConnections: 8})
+
+	reporter := NewAsyncReporter(sc, AsyncReporterOptions{})
+	for i := 0; i < 100; i++ {
+		if err := reporter.Enqueue(testReportRequest()); err != nil {
+			t.Fatalf("enqueue report %d: %v", i, err)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := reporter.Flush(ctx); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+	if got := received.Load(); got != 100 {
+		t.Fatalf("expected 100 reports to be delivered, got %d", got)
+	}
+
+	if err := reporter.Close(ctx); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	if err := reporter.Enqueue(testReportRequest()); !errors.Is(err, ErrReporterClosed) {
+		t.Fatalf("expected ErrReporterClosed, got %v", err)
+	}
+}
+
+func TestAsyncReporterDropsReportsWhenQueueIsFull(t *testing.T) {
+	addr := startDelayedServer(t, 100*time.Millisecond)
+	sc := newTestShardedClient(Configuration{Addrs: "1024@" + addr, MaximumSimultaneousConnections: 8})
+
+	reporter := NewAsyncReporter(sc, AsyncReporterOptions{QueueSize: 1, Workers: 1, BatchSize: 1})
+	dropped := 0
+	for i := 0; i < 3; i++ {
+		err := reporter.Enqueue(testReportRequest())
+		if errors.Is(err, ErrReportQueueFull) {
+			dropped++
+			continue
+		}
+		if err != nil {
+			t.Fatalf("enqueue report %d: %v", i, err)
+		}
+	}
+	if dropped == 0 {
+		t.Fatalf("expected at least one report to be dropped")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := reporter.Close(ctx); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+}
+
+func TestAsyncReporterSendsReportsInBatches(t *testing.T) {
+	var inFlight, maxInFlight atomic.Int64
+	addr := startTestServer(t, func(ctx *contract.RequestCtx) {
+		n := inFlight.Add(1)
+		for {
+			max := maxInFlight.Load()
+			if n <= max || maxInFlight.CompareAndSwap(max, n) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+		inFlight.Add(-1)
+		ctx.Response.SetStatusCode(base.RPCServerResponseCode_OK)
+	})
+	sc := newTestShardedClient(Configuration{Addrs: "1024@" + addr, MaximumSimultaneousConnections: 8})
+
+	reporter := NewAsyncReporter(sc, AsyncReporterOptions{Workers: 1, BatchSize: 8, FlushInterval: 50 * time.Millisecond})
+	for i := 0; i < 8; i++ {
+		if err := reporter.Enqueue(testReportRequest()); err != nil {
+			t.Fatalf("enqueue report %d: %v", i, err)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := reporter.Close(ctx); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	// A single worker sends the reports of a batch at once instead of one after another.
+	if got := maxInFlight.Load(); got < 2 {
+		t.Fatalf("expected the batch to be sent at once, got at most %d reports in flight", got)
+	}
+}
+
+func TestAsyncReporterClosesQueueOfRemovedShard(t *testing.T) {
+	sc := newTestShardedClient(Configuration{Addrs: "127.0.0.1:1,127.0.0.1:2"})
+	defer sc.Close()
+	removed := sc.shardSet().clients[0]
+	setTestServerID(removed, testReporterServerID)
+	setTestServerID(sc.shardSet().clients[1], testReporterServerID+1)
+
+	var failed atomic.Int64
+	reporter := NewAsyncReporter(sc, AsyncReporterOptions{
+		FlushInterval: time.Hour,
+		BatchSize:     2,
+		OnError: func(*base.ReportRequest, base.RPCServerResponseCode, error) {
+			failed.Add(1)
+		},
+	})
+	if err := reporter.Enqueue(testReportRequest()); err != nil {
+		t.Fatalf("enqueue: %v", err)
+	}
+
+	if err := sc.RemoveShard(removed.addr); err != nil {
+		t.Fatalf("remove shard: %v", err)
+	}
+	reporter.mu.RLock()
+	queues := len(reporter.queues)
+	reporter.mu.RUnlock()
+	if queues != 0 {
+		t.Fatalf("expected the queue of the removed shard to be deleted, got %d queues", queues)
+	}
+
+	// The worker waiting for the batch to fill up exits once the queue is closed.
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := reporter.Flush(ctx); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+	if got := failed.Load(); got != 1 {
+		t.Fatalf("expected the queued report to fail, got %d failures", got)
+	}
+	if err := reporter.Enqueue(testReportRequest()); !errors.Is(err, ErrUnknownShard) {
+		t.Fatalf("expected ErrUnknownShard, got %v", err)
+	}
+	if err := reporter.Close(ctx); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+}
+
+func TestAsyncReporterCloseDropsUnsentReportsOnTimeout(t *testing.T) {
+	release := make(chan struct{})
+	t.Cleanup(func() { close(release) })
+	var received atomic.Int64
+	addr := startTestServer(t, func(ctx *contract.RequestCtx) {
+		if ctx.Request.GetName() == contract.Report {
+			received.Add(1)
+			<-release
+		}
+		ctx.Response.SetStatusCode(base.RPCServerResponseCode_OK)
+	})
+	sc := newTestShardedClient(Configuration{Addrs: "1024@" + addr, MaximumSimultaneousConnections: 8})
+
+	var dropped, failed atomic.Int64
+	reporter := NewAsyncReporter(sc, AsyncReporterOptions{
+		Workers:   1,
+		BatchSize: 1,
+		OnError: func(_ *base.ReportRequest, _ base.RPCServerResponseCode, err error) {
+			if errors.Is(err, ErrReporterClosed) {
+				dropped.Add(1)
+			} else {
+				failed.Add(1)
+			}
+		},
+	})
+	for i := 0; i < 5; i++ {
+		if err := reporter.Enqueue(testReportRequest()); err != nil {
+			t.Fatalf("enqueue report %d: %v", i, err)
+		}
+	}
+	for received.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := reporter.Close(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected context.DeadlineExceeded, got %v", err)
+	}
+	if got := dropped.Load(); got != 4 {
+		t.Fatalf("expected the 4 queued reports to be dropped to OnError, got %d", got)
+	}
+	if got := failed.Load(); got != 1 {
+		t.Fatalf("expected the report in flight to be canceled, got %d failures", got)
+	}
+	if got := received.Load(); got != 1 {
+		t.Fatalf("expected only the report in flight to reach the server, got %d", got)
+	}
+}
+
+func TestAsyncReporterRejectsUnknownTrackingID(t *testing.T) {
+	sc := newTestShardedClient(Configuration{Addrs: "1024@127.0.0.1:1", MaximumSimultaneousConnections: 8})
+	reporter := NewAsyncReporter(sc, AsyncReporterOptions{})
+
+	if err := reporter.Enqueue(&base.ReportRequest{}); err == nil {
+		t.Fatalf("expected missing tracking id error")
+	}
+	if err := reporter.Enqueue(&base.ReportRequest{TrackingId: []byte("FFFF000000000001")}); err == nil {
+		t.Fatalf("expected unknown server error")
+	}
+}
+
+func testReportRequest() *base.ReportRequest {
+	return &base.ReportRequest{
+		TrackingId: []byte("0400000000000001"),
+		Event:      base.EventType_EVENT_TYPE_IMPRESSION,
+	}
+}
@@ -0,0 +1,229 @@
+package client
+
+import (
+	"context"
+	"math/rand/v2"
+	"slices"
+	"sync/atomic"
+	"time"
+
+	base "github.com/mygaru/dcr-sdk/gen/base1"
+	"github.com/mygaru/dcr-sdk/pkg/contract"
+)
+
+const (
+	defaultRetryInitialBackoff    = 5 * time.Millisecond
+	defaultRetryMaxBackoff        = 50 * time.Millisecond
+	defaultRetryBackoffMultiplier = 2
+	defaultRetryJitter            = 0.2
+	defaultRetryBudgetRatio       = 0.1
+	defaultRetryBudgetBurst       = 10
+
+	// retryBudgetScale converts budget tokens to the fixed-point units stored in retryBudget.
+	retryBudgetScale = 1000
+)
+
+// RetryPolicy configures automatic retries of Target and Report.
+//
+// Report retries are always sent to the shard that issued the tracking id.
+// Target retries pick a shard again, so they may be served by another shard.
+//
+// Each attempt is bounded by MaxRequestDuration or the context deadline, and no retry is made
+// if its backoff would end after the context deadline.
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of attempts per call, including the first one.
+	// Values below 2 disable retries.
+	MaxAttempts int
+
+	// InitialBackoff is the delay before the first retry.
+	// By default 5ms.
+	InitialBackoff time.Duration
+
+	// MaxBackoff caps the delay between retries.
+	// By default 50ms.
+	MaxBackoff time.Duration
+
+	// BackoffMultiplier is the factor the delay is multiplied by after each retry.
+	// By default 2.
+	BackoffMultiplier float64
+
+	// Jitter randomizes every delay by up to ±Jitter of its value. It must be in [0, 1].
+	// By default 0.2.
+	Jitter float64
+
+	// RetryableCodes lists the response codes that are retried.
+	// By default NETWORK_ERROR and SERVICE_UNAVAILABLE.
+	// Errors caused by context cancellation are never retried.
+	RetryableCodes []base.RPCServerResponseCode
+
+	// BudgetRatio limits retries to this fraction of calls across the whole client,
+	// so retries cannot multiply the load on an overloaded cloud.
+	// By default 0.1. A negative value disables the budget.
+	BudgetRatio float64
+
+	// BudgetBurst is the number of retries allowed before the budget starts to be enforced.
+	// By default 10.
+	BudgetBurst int
+}
+
+func normalizeRetryPolicy(p RetryPolicy) RetryPolicy {
+	if p.MaxAttempts < 2 {
+		return RetryPolicy{}
+	}
+	if p.InitialBackoff <= 0 {
+		p.InitialBackoff = defaultRetryInitialBackoff
+	}
+	if p.MaxBackoff <= 0 {
+		p.MaxBackoff = defaultRetryMaxBackoff
+	}
+	if p.MaxBackoff < p.InitialBackoff {
+		p.MaxBackoff = p.InitialBackoff
+	}
+	if p.BackoffMultiplier < 1 {
+		p.BackoffMultiplier = defaultRetryBackoffMultiplier
+	}
+	if p.Jitter <= 0 || p.Jitter > 1 {
+		p.Jitter = defaultRetryJitter
+	}
+	if len(p.RetryableCodes) == 0 {
+		p.RetryableCodes = []base.RPCServerResponseCode{
+			base.RPCServerResponseCode_NETWORK_ERROR,
+			base.RPCServerResponseCode_SERVICE_UNAVAILABLE,
+		}
+	} else {
+		p.RetryableCodes = slices.Clone(p.RetryableCodes)
+	}
+	if p.BudgetRatio == 0 {
+		p.BudgetRatio = defaultRetryBudgetRatio
+	}
+	if p.BudgetBurst <= 0 {
+		p.BudgetBurst = defaultRetryBudgetBurst
+	}
+	return p
+}
+
+// retrier applies RetryPolicy to RPC calls.
+type retrier struct {
+	policy  RetryPolicy
+	budget  retryBudget
+	metrics [contract.MaxRequestIdentifier + 1]*retryMetrics
+}
+
+func newRetrier(policy RetryPolicy, registry *metricsRegistry) *retrier {
+	r := &retrier{
+		policy: policy,
+	}
+	if policy.BudgetRatio > 0 {
+		r.budget.deposit = int64(policy.BudgetRatio * retryBudgetScale)
+		r.budget.max = int64(policy.BudgetBurst) * retryBudgetScale
+		r.budget.tokens.Store(r.budget.max)
+	}
+	for i := 1; i <= int(contract.MaxRequestIdentifier); i++ {
+		r.metrics[i] = newRetryMetrics(registry, contract.RPCRegister(i).String())
+	}
+	return r
+}
+
+// do runs attempt and retries it according to the policy.
+func (r *retrier) do(ctx context.Context, reqn contract.RPCRegister, attempt func() (base.RPCServerResponseCode, error)) (base.RPCServerResponseCode, error) {
+	if r.policy.MaxAttempts < 2 {
+		return attempt()
+	}
+
+	r.budget.earn()
+	statusCode, err := attempt()
+
+	backoff := r.policy.InitialBackoff
+	for n := 1; n < r.policy.MaxAttempts && err != nil && r.isRetryable(statusCode, err); n++ {
+		if !r.budget.spend() {
+			r.metrics[reqn].budgetExhausted.Inc()
+			break
+		}
+		if !sleepContext(ctx, r.jitter(backoff)) {
+			break
+		}
+
+		r.metrics[reqn].retries.Inc()
+		statusCode, err = attempt()
+
+		backoff = time.Duration(float64(backoff) * r.policy.BackoffMultiplier)
+		if backoff > r.policy.MaxBackoff {
+			backoff = r.policy.MaxBackoff
+		}
+	}
+	return statusCode, err
+}
+
+func (r *retrier) isRetryable(statusCode base.RPCServerResponseCode, err error) bool {
+	if isContextError(err) {
+		return false
+	}
+	return slices.Contains(r.policy.RetryableCodes, statusCode)
+}
+
+func (r *retrier) jitter(d time.Duration) time.Duration {
+	f := 1 + r.policy.Jitter*(2*rand.Float64()-1)
+	return time.Duration(float64(d) * f)
+}
+
+// sleepContext waits for d. It returns false without waiting if ctx expires before d elapses,
+// and false as soon as ctx is canceled.
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) <= d {
+		return false
+	}
+	if ctx.Done() == nil {
+		time.Sleep(d)
+		return true
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// retryBudget is a token bucket shared by all calls of a client.
+// Every call earns BudgetRatio tokens and every retry spends one token.
+// Tokens are stored as fixed-point numbers scaled by retryBudgetScale.
+type retryBudget struct {
+	deposit int64
+	max     int64
+	tokens  atomic.Int64
+}
+
+func (b *retryBudget) earn() {
+	if b.max == 0 {
+		return
+	}
+	for {
+		tokens := b.tokens.Load()
+		if tokens >= b.max {
+			return
+		}
+		next := min(tokens+b.deposit, b.max)
+		if b.tokens.CompareAndSwap(tokens, next) {
+			return
+		}
+	}
+}
+
+func (b *retryBudget) spend() bool {
+	if b.max == 0 {
+		// the budget is disabled.
+		return true
+	}
+	for {
+		tokens := b.tokens.Load()
+		if tokens < retryBudgetScale {
+			return false
+		}
+		if b.tokens.CompareAndSwap(tokens, tokens-retryBudgetScale) {
+			return true
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	base "github.com/mygaru/dcr-sdk/gen/base1"
+	"github.com/mygaru/dcr-sdk/pkg/contract"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestTargetRetriesRetryableStatus(t *testing.T) {
+	var attempts atomic.Int64
+	addr := startTestServer(t, func(ctx *contract.RequestCtx) {
+		if attempts.Add(1) < 3 {
+			ctx.Response.SetStatusCode(base.RPCServerResponseCode_SERVICE_UNAVAILABLE)
+			return
+		}
+		writeTestTargetResponse(ctx, "0400000000000001")
+	})
+	sc := newTestShardedClient(Configuration{
+		Addrs:                          addr,
+		MaximumSimultaneousConnections: 2,
+		Retry:                          RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond},
+	})
+
+	resp, statusCode, err := sc.Target(testTargetRequest())
+	if err != nil {
+		t.Fatalf("expected target to succeed after retries, got %v", err)
+	}
+	if statusCode != base.RPCServerResponseCode_OK {
+		t.Fatalf("expected OK status, got %s", statusCode)
+	}
+	if string(resp.TrackingId) != "0400000000000001" {
+		t.Fatalf("unexpected tracking id %q", resp.TrackingId)
+	}
+	if got := attempts.Load(); got != 3 {
+		t.Fatalf("expected 3 attempts, got %d", got)
+	}
+}
+
+func TestRetryDoesNotRetryNonRetryableStatus(t *testing.T) {
+	var attempts atomic.Int64
+	addr := startTestServer(t, func(ctx *contract.RequestCtx) {
+		attempts.Add(1)
+		ctx.Response.SetStatusCode(base.RPCServerResponseCode_INVALID_REQUEST)
+	})
+	sc := newTestShardedClient(Configuration{
+		Addrs:                          addr,
+		MaximumSimultaneousConnections: 2,
+		Retry:                          RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond},
+	})
+	setTestServerID(sc.shardSet().clients[0], testReporterServerID)
+
+	statusCode, _ := sc.Report(testReportRequest())
+	if statusCode != base.RPCServerResponseCode_INVALID_REQUEST {
+		t.Fatalf("expected INVALID_REQUEST status, got %s", statusCode)
+	}
+	if got := attempts.Load(); got != 1 {
+		t.Fatalf("expected a single attempt, got %d", got)
+	}
+}
+
+func TestReportRetriesStayOnOwningShard(t *testing.T) {
+	var ownerAttempts, otherAttempts atomic.Int64
+	owner := startTestServer(t, func(ctx *contract.RequestCtx) {
+		if ownerAttempts.Add(1) == 1 {
+			ctx.Response.SetStatusCode(base.RPCServerResponseCode_SERVICE_UNAVAILABLE)
+			return
+		}
+		ctx.Response.SetStatusCode(base.RPCServerResponseCode_OK)
+	})
+	other := startTestServer(t, func(ctx *contract.RequestCtx) {
+		otherAttempts.Add(1)
+		ctx.Response.SetStatusCode(base.RPCServerResponseCode_OK)
+	})
+	sc := newTestShardedClient(Configuration{
+		Addrs:                          owner + "," + other,
+		MaximumSimultaneousConnections: 2,
+		Retry:                          RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond},
+	})
+	setTestServerID(sc.shardSet().clients[0], testReporterServerID)
+	setTestServerID(sc.shardSet().clients[1], testReporterServerID+1)
+
+	if _, err := sc.Report(testReportRequest()); err != nil {
+		t.Fatalf("expected report to succeed after retry, got %v", err)
+	}
+	if got := ownerAttempts.Load(); got != 2 {
+		t.Fatalf("expected 2 attempts on the owning shard, got %d", got)
+	}
+	if got := otherAttempts.Load(); got != 0 {
+		t.Fatalf("expected no attempts on the other shard, got %d", got)
+	}
+}
+
+func TestRetryBudgetLimitsRetries(t *testing.T) {
+	r := newRetrier(normalizeRetryPolicy(RetryPolicy{
+		MaxAttempts:    5,
+		InitialBackoff: time.Microsecond,
+		BudgetRatio:    0.01,
+		BudgetBurst:    2,
+	}), newMetricsRegistry(MetricsConfig{}))
+
+	attempts := 0
+	_, _ = r.do(context.Background(), contract.Target, func() (base.RPCServerResponseCode, error) {
+		attempts++
+		return base.RPCServerResponseCode_NETWORK_ERROR, errTestAttempt
+	})
+	if attempts != 3 {
+		t.Fatalf("expected the burst of 2 retries to be spent, got %d attempts", attempts)
+	}
+
+	attempts = 0
+	_, _ = r.do(context.Background(), contract.Target, func() (base.RPCServerResponseCode, error) {
+		attempts++
+		return base.RPCServerResponseCode_NETWORK_ERROR, errTestAttempt
+	})
+	if attempts != 1 {
+		t.Fatalf("expected no retries with an exhausted budget, got %d attempts", attempts)
+	}
+}
+
+func TestRetryStopsAtContextDeadline(t *testing.T) {
+	r := newRetrier(normalizeRetryPolicy(RetryPolicy{
+		MaxAttempts:    5,
+		InitialBackoff: 50 * time.Millisecond,
+	}), newMetricsRegistry(MetricsConfig{}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	attempts := 0
+	_, _ = r.do(ctx, contract.Target, func() (base.RPCServerResponseCode, error) {
+		attempts++
+		return base.RPCServerResponseCode_NETWORK_ERROR, errTestAttempt
+	})
+	if attempts != 1 {
+		t.Fatalf("expected no retry past the context deadline, got %d attempts", attempts)
+	}
+}
+
+var errTestAttempt = errors.New("attempt failed")
+
+func writeTestTargetResponse(ctx *contract.RequestCtx, trackingID string) {
+	raw, err := proto.Marshal(&base.TargetResponse{
+		TrackingId: []byte(trackingID),
+		StatusCode: base.RPCServerResponseCode_OK,
+	})
+	if err != nil {
+		ctx.Response.SetStatusCode(base.RPCServerResponseCode_TECH_ERROR)
+		return
+	}
+	ctx.Response.SetStatusCode(base.RPCServerResponseCode_OK)
+	ctx.Response.Append(raw)
+}
@@ -0,0 +1,177 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/mygaru/dcr-sdk/pkg/contract"
+)
+
+// route holds the connections to a single DCR server. A shard address may resolve to several servers,
+// so the connections of a shard may belong to different routes.
+type route struct {
+	// serverID is the ID of the server, the one encoded into the tracking ids it issues.
+	serverID uint16
+
+	// shard is the shard of the first connection to the server.
+	shard *clientsGroup
+
+	clients   []*client
+	endpoints []Endpoint
+	balancer  Balancer
+}
+
+// getClient returns the connection to the server chosen by the route balancer.
+func (rt *route) getClient() *client {
+	return rt.clients[rt.balancer.Pick(rt.endpoints)]
+}
+
+// routeTable maps server IDs to the connections to the servers. It is never modified once published.
+type routeTable map[uint16]*route
+
+// routes is the routing table of Report calls. Membership changes rebuild it, and a connection that learns
+// or forgets its server ID updates only the routes it leaves and joins.
+type routes struct {
+	table atomic.Pointer[routeTable]
+
+	// mu serializes rebuilds and updates.
+	mu sync.Mutex
+}
+
+// lookup returns the route to the server with serverID, or nil if no connection to it is known.
+func (r *routes) lookup(serverID uint16) *route {
+	table := r.table.Load()
+	if table == nil {
+		return nil
+	}
+	return (*table)[serverID]
+}
+
+// rebuildRoutes publishes a new routing table built from the current server IDs of all connections.
+func (sc *ShardedClient) rebuildRoutes() {
+	sc.routes.mu.Lock()
+	defer sc.routes.mu.Unlock()
+
+	prev := sc.routes.table.Load()
+	table := make(routeTable)
+	for _, shard := range sc.shardSet().clients {
+		for _, cl := range shard.clients {
+			serverID := cl.GetServerID()
+			cl.routedServerID = serverID
+			if serverID == 0 {
+				continue
+			}
+			rt := table[serverID]
+			if rt == nil {
+				rt = &route{serverID: serverID, shard: shard}
+				if prev != nil && (*prev)[serverID] != nil {
+					rt.balancer = (*prev)[serverID].balancer
+				} else {
+					rt.balancer = sc.Balancer()
+				}
+				table[serverID] = rt
+			}
+			rt.clients = append(rt.clients, cl)
+			rt.endpoints = append(rt.endpoints, cl)
+		}
+	}
+	sc.routes.table.Store(&table)
+}
+
+// updateRoute moves cl to the route of its current server ID. It copies the table and only the routes cl leaves
+// and joins, so a warmup that learns the server IDs of all connections doesn't rebuild the table once per connection.
+// Connections of removed shards are left out.
+func (sc *ShardedClient) updateRoute(cl *client) {
+	sc.routes.mu.Lock()
+	defer sc.routes.mu.Unlock()
+
+	prev := sc.routes.table.Load()
+	set := sc.shardSet()
+	if prev == nil || set == nil || set.findShard(cl.addr) != cl.shard {
+		// Not published yet or already removed: the rebuild by publishShards covers cl.
+		return
+	}
+	serverID := cl.GetServerID()
+	if serverID == cl.routedServerID {
+		return
+	}
+
+	table := make(routeTable, len(*prev)+1)
+	for id, rt := range *prev {
+		table[id] = rt
+	}
+	if rt := table[cl.routedServerID]; rt != nil {
+		if next := rt.without(cl); next != nil {
+			table[cl.routedServerID] = next
+		} else {
+			delete(table, cl.routedServerID)
+		}
+	}
+	if serverID != 0 {
+		table[serverID] = table[serverID].with(cl, serverID, sc.Balancer)
+	}
+	cl.routedServerID = serverID
+	sc.routes.table.Store(&table)
+}
+
+// with returns a copy of rt with cl appended. rt may be nil, in which case the new route gets
+// a balancer from newBalancer and the shard of cl.
+func (rt *route) with(cl *client, serverID uint16, newBalancer func() Balancer) *route {
+	if rt == nil {
+		return &route{
+			serverID:  serverID,
+			shard:     cl.shard,
+			clients:   []*client{cl},
+			endpoints: []Endpoint{cl},
+			balancer:  newBalancer(),
+		}
+	}
+	next := &route{serverID: rt.serverID, shard: rt.shard, balancer: rt.balancer}
+	next.clients = append(append(make([]*client, 0, len(rt.clients)+1), rt.clients...), cl)
+	next.endpoints = append(append(make([]Endpoint, 0, len(rt.endpoints)+1), rt.endpoints...), cl)
+	return next
+}
+
+// without returns a copy of rt with cl removed, or nil if cl was its only connection.
+func (rt *route) without(cl *client) *route {
+	next := &route{serverID: rt.serverID, balancer: rt.balancer}
+	for _, c := range rt.clients {
+		if c != cl {
+			next.clients = append(next.clients, c)
+			next.endpoints = append(next.endpoints, c)
+		}
+	}
+	if len(next.clients) == 0 {
+		return nil
+	}
+	next.shard = next.clients[0].shard
+	return next
+}
+
+// routeTrackingID returns the route to the server that issued trackingId.
+func (sc *ShardedClient) routeTrackingID(trackingId []byte) (*route, error) {
+	if nil == trackingId {
+		return nil, ErrMissingTrackingID
+	}
+	id, err := contract.ParseTrackingIDBytes(trackingId)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrUnknownShard, err)
+	}
+	rt := sc.routes.lookup(id.ServerID())
+	if nil == rt {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownShard, trackingId)
+	}
+	return rt, nil
+}
+
+// countUnroutable counts a Report that can't be routed because of err.
+func (sc *ShardedClient) countUnroutable(err error) {
+	switch {
+	case errors.Is(err, contract.ErrInvalidTrackingID):
+		sc.routing.invalid.Inc()
+	case errors.Is(err, ErrUnknownShard):
+		sc.routing.unknown.Inc()
+	}
+}
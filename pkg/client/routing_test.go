@@ -0,0 +1,188 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	base "github.com/mygaru/dcr-sdk/gen/base1"
+	"github.com/mygaru/dcr-sdk/pkg/contract"
+)
+
+func TestReportIsRoutedToServerBehindSharedAddress(t *testing.T) {
+	var reportsA, reportsB atomic.Int64
+	addrA := startHelloTestServer(t, testServerConfig{hello: contract.HelloResponse{ServerID: 1024}, reports: &reportsA})
+	addrB := startHelloTestServer(t, testServerConfig{hello: contract.HelloResponse{ServerID: 1025}, reports: &reportsB})
+
+	sc := newTestShardedClient(Configuration{
+		Addrs:                          addrA,
+		MaximumSimultaneousConnections: 2,
+	})
+	// Both servers are behind the single shard address, as if its host resolved to two IPs.
+	sc.shardSet().clients[0].dialer.addrs = []string{addrA, addrB}
+
+	if err := sc.Warmup(context.Background()); err != nil {
+		t.Fatalf("warmup: %v", err)
+	}
+
+	for i := 0; i < 10; i++ {
+		if _, err := sc.Report(&base.ReportRequest{TrackingId: []byte("0401000000000001")}); err != nil {
+			t.Fatalf("report: %v", err)
+		}
+	}
+	if _, err := sc.Report(&base.ReportRequest{TrackingId: []byte("0400000000000001")}); err != nil {
+		t.Fatalf("report: %v", err)
+	}
+	if a, b := reportsA.Load(), reportsB.Load(); a != 1 || b != 10 {
+		t.Fatalf("expected reports to reach the servers that issued them, got %d and %d", a, b)
+	}
+	if got := sc.routing.misrouted.Get(); got != 0 {
+		t.Fatalf("expected no misrouted reports, got %d", got)
+	}
+}
+
+func TestReportCountsUnroutableTrackingIDs(t *testing.T) {
+	var reports atomic.Int64
+	addr := startHelloTestServer(t, testServerConfig{hello: contract.HelloResponse{ServerID: 1024}, reports: &reports})
+	sc := newTestShardedClient(Configuration{Addrs: addr})
+
+	if _, err := sc.Report(&base.ReportRequest{TrackingId: []byte("0FFF000000000001")}); !errors.Is(err, ErrUnknownShard) {
+		t.Fatalf("expected ErrUnknownShard, got %v", err)
+	}
+	if _, err := sc.Report(&base.ReportRequest{TrackingId: []byte("bad")}); !errors.Is(err, contract.ErrInvalidTrackingID) {
+		t.Fatalf("expected ErrInvalidTrackingID, got %v", err)
+	}
+	if got := sc.routing.unknown.Get(); got != 1 {
+		t.Fatalf("expected an unknown server id to be counted, got %d", got)
+	}
+	if got := sc.routing.invalid.Get(); got != 1 {
+		t.Fatalf("expected an invalid tracking id to be counted, got %d", got)
+	}
+}
+
+func TestRedialToAnotherAddressForgetsServerID(t *testing.T) {
+	var reportsA, reportsB atomic.Int64
+	addrA := startHelloTestServer(t, testServerConfig{hello: contract.HelloResponse{ServerID: 1024}, reports: &reportsA})
+	addrB := startHelloTestServer(t, testServerConfig{hello: contract.HelloResponse{ServerID: 1025}, reports: &reportsB})
+	sc := newTestShardedClient(Configuration{Addrs: addrA})
+	cl := sc.shardSet().clients[0].clients[0]
+
+	if err := sc.Warmup(context.Background()); err != nil {
+		t.Fatalf("warmup: %v", err)
+	}
+	if cl.GetServerID() != 1024 {
+		t.Fatalf("expected server id 1024, got %d", cl.GetServerID())
+	}
+
+	sc.shardSet().clients[0].dialer.addrs = []string{addrB}
+	conn, err := sc.shardSet().clients[0].dialer.dial(cl)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	if cl.GetServerID() != 0 {
+		t.Fatalf("expected the server id to be forgotten, got %d", cl.GetServerID())
+	}
+	if _, err := sc.routeTrackingID([]byte("0400000000000001")); !errors.Is(err, ErrUnknownShard) {
+		t.Fatalf("expected the route to be removed, got %v", err)
+	}
+}
+
+func TestServerIDChangeUpdatesOnlyItsRoutes(t *testing.T) {
+	sc := newTestShardedClient(Configuration{
+		Addrs:                          "127.0.0.1:1,127.0.0.1:2",
+		MaximumSimultaneousConnections: 2,
+	})
+	shardA, shardB := sc.shardSet().clients[0], sc.shardSet().clients[1]
+
+	setTestServerID(shardA, 1024)
+	shardB.clients[0].setServerID(1025)
+	routeA := sc.routes.lookup(1024)
+	if routeA == nil || len(routeA.clients) != 2 || routeA.shard != shardA {
+		t.Fatalf("expected both connections of the first shard on route 1024, got %+v", routeA)
+	}
+
+	shardB.clients[1].setServerID(1025)
+	if sc.routes.lookup(1024) != routeA {
+		t.Fatal("expected route 1024 to be kept when another server ID changes")
+	}
+
+	shardA.clients[0].setServerID(1025)
+	if rt := sc.routes.lookup(1024); len(rt.clients) != 1 || rt.clients[0] != shardA.clients[1] {
+		t.Fatalf("expected route 1024 to keep only the second connection, got %+v", rt.clients)
+	}
+	if rt := sc.routes.lookup(1025); len(rt.clients) != 3 || rt.shard != shardB {
+		t.Fatalf("expected route 1025 to hold three connections and keep the second shard, got %+v", rt)
+	}
+
+	shardA.clients[1].setServerID(0)
+	if sc.routes.lookup(1024) != nil {
+		t.Fatal("expected route 1024 to be removed with its last connection")
+	}
+
+	if err := sc.RemoveShard(shardB.addr); err != nil {
+		t.Fatalf("remove shard: %v", err)
+	}
+	shardB.clients[0].setServerID(1026)
+	if sc.routes.lookup(1026) != nil {
+		t.Fatal("expected connections of a removed shard to stay unrouted")
+	}
+	if rt := sc.routes.lookup(1025); len(rt.clients) != 1 || rt.shard != shardA {
+		t.Fatalf("expected route 1025 to keep only the first shard after the removal, got %+v", rt)
+	}
+}
+
+func TestReportDiscoversOneConnectionPerShard(t *testing.T) {
+	var hellos atomic.Int64
+	addr := startHelloTestServer(t, testServerConfig{hello: contract.HelloResponse{ServerID: 1024}, hellos: &hellos})
+	sc := newTestShardedClient(Configuration{
+		Addrs:                          addr,
+		MaximumSimultaneousConnections: 4,
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := sc.Report(&base.ReportRequest{TrackingId: []byte("0FFF000000000001")}); !errors.Is(err, ErrUnknownShard) {
+				t.Errorf("expected ErrUnknownShard, got %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if _, err := sc.Report(&base.ReportRequest{TrackingId: []byte("0FFF000000000001")}); !errors.Is(err, ErrUnknownShard) {
+		t.Fatalf("expected ErrUnknownShard, got %v", err)
+	}
+	if got := hellos.Load(); got != 1 {
+		t.Fatalf("expected a single hello, got %d", got)
+	}
+}
+
+func TestReportWaitsForDiscoveryUntilDeadline(t *testing.T) {
+	release := make(chan struct{})
+	t.Cleanup(func() { close(release) })
+	addr := startRawTestServer(t, func(ctx *contract.RequestCtx) {
+		if ctx.Request.GetName() == contract.Hello {
+			<-release
+		}
+		ctx.Response.SetStatusCode(base.RPCServerResponseCode_OK)
+	})
+	sc := newTestShardedClient(Configuration{
+		Addrs:              addr,
+		MaxRequestDuration: 10 * time.Second,
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := sc.ReportContext(ctx, &base.ReportRequest{TrackingId: []byte("0400000000000001")}); !errors.Is(err, ErrUnknownShard) {
+		t.Fatalf("expected ErrUnknownShard, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("expected Report to give up at its deadline, took %s", elapsed)
+	}
+}
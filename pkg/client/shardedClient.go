@@ -1,15 +1,19 @@
 package client
 
 import (
+	"context"
 	"crypto/tls"
+	"errors"
 	"fmt"
+	"io"
+	"log/slog"
 	"net"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/aradilov/fastrpc"
-	"github.com/aradilov/uniqid"
 	base "github.com/mygaru/dcr-sdk/gen/base1"
 	"github.com/mygaru/dcr-sdk/internal/sdkutil"
 	"github.com/mygaru/dcr-sdk/pkg/contract"
@@ -17,13 +21,32 @@ import (
 
 const defaultCloudAddr = "cloud.mygaru.com:7937"
 
+// ErrClosed is returned by the ShardedClient calls made after Close or Shutdown.
+var ErrClosed = errors.New("client is closed")
+
+// clientClosedFlag is set in ShardedClient.state once the client is closed.
+const clientClosedFlag = 1 << 62
+
 type Configuration struct {
 	// Addrs specifies the comma-separated list of server addresses used for sharding the client connections.
+	// An address may pin the server ID of the shard and set its weight and number of connections,
+	// e.g. "1024@dcr-a:7937;weight=2;conns=64,1025@dcr-b:7937". See ParseShards.
 	Addrs string
 
+	// Shards is the structured alternative to Addrs. Addrs is ignored if Shards is set.
+	Shards []ShardConfig
+
+	// DrainGracePeriod is how long a shard drained with ShardedClient.DrainShard still receives Report calls
+	// for its tracking ids before it is removed. By default 5 minutes.
+	DrainGracePeriod time.Duration
+
 	// Client's JWT token for authentication.
 	JwtToken []byte
 
+	// TokenSource provides the JWT token instead of JwtToken, so a rotated token is used without a restart.
+	// See TokenSource and FileTokenSource.
+	TokenSource TokenSource
+
 	// DisableAuth skips the legacy contract.Auth request.
 	// It is enabled by the SDK mTLS constructor because the client identity is established during TLS handshake.
 	DisableAuth bool
@@ -55,32 +78,161 @@ type Configuration struct {
 	//
 	// DefaultWriteBufferSize is used by default.
 	WriteBufferSize int
+
+	// ReportSpool optionally persists Report requests that failed with NETWORK_ERROR
+	// and replays them in the background. See OpenSpool. A spool is attached to a single client,
+	// whose metrics.Set and MetricsSink then receive the spool metrics.
+	ReportSpool *Spool
+
+	// Retry configures automatic retries of Target and Report. Retries are disabled by default.
+	Retry RetryPolicy
+
+	// Hedge configures hedged Target requests across shards. Hedging is disabled by default.
+	Hedge HedgePolicy
+
+	// Breaker configures the per-shard circuit breaker that takes failing shards out of Target rotation.
+	// Circuit breaking is disabled by default.
+	Breaker BreakerPolicy
+
+	// CertificateReload configures the hot reload of the mTLS client certificate. It is disabled by default.
+	CertificateReload CertificateReloadPolicy
+
+	// Balancer creates the balancer that picks the shard for Target and the connection within a shard.
+	// A separate balancer is created for the shards and for every shard.
+	// By default NewRoundRobinBalancer is used; NewP2CBalancer prefers less loaded shards and connections.
+	Balancer func() Balancer
+
+	// AuthOnDial starts the connection handshake (contract.Hello and contract.Auth) as soon as a connection
+	// is dialed instead of in the first request over it, and keeps dialed connections that haven't completed it
+	// yet out of rotation while the shard has other ones. With DisableAuth only contract.Hello is sent.
+	//
+	// fastrpc has no hook between its protocol handshake and the first request, so a request that
+	// triggers a reconnect is still sent before the connection handshake.
+	AuthOnDial bool
+
+	// Interceptors run in order around every unary RPC, including every retry and hedged request
+	// and the internal contract.Auth exchange. See UnaryInterceptor.
+	Interceptors []UnaryInterceptor
+
+	// Metrics configures the metrics.Set of the client. See MetricsConfig.
+	Metrics MetricsConfig
+
+	// Logger receives structured events about connections, auth, DNS changes and failed requests.
+	// Every event is logged at most once per second per shard. Nothing is logged if Logger is nil.
+	Logger *slog.Logger
 }
 
 type ShardedClient struct {
 	Configuration
 
-	// roundRobin is a counter used for load balancing to distribute requests evenly across client connections.
-	roundRobin uint64
+	// balancer picks the shard for Target requests.
+	balancer Balancer
 
-	// clients is a slice of pointers to client instances used for managing connections to multiple servers for sharding.
-	clients []*clientsGroup
+	// tokens is TokenSource, or a StaticTokenSource for JwtToken if TokenSource is nil. It is kept apart
+	// from Configuration, so the configuration of the client still has at most one of the two set.
+	tokens TokenSource
+
+	// shards holds the current shards. It is replaced as a whole by AddShard, RemoveShard and DrainShard,
+	// so requests read it without locking.
+	shards atomic.Pointer[shardSet]
+
+	// shardsMu serializes changes of shards.
+	shardsMu sync.Mutex
+
+	// removalWatchers are called with every shard removed by RemoveShard or at the end of DrainShard.
+	removalWatchersMu  sync.Mutex
+	removalWatchers    map[uint64]func(shard *clientsGroup)
+	removalWatchersSeq uint64
+
+	// tlsConfig and log are used for the connections of every shard, including the ones added later.
+	tlsConfig *tls.Config
+	log       *eventLogger
+
+	// retrier applies Configuration.Retry to Target and Report calls.
+	retrier *retrier
+
+	// metrics holds the metrics.Set of the client.
+	metrics *metricsRegistry
+
+	// routes routes Report calls to the connections to the server that issued the tracking id.
+	routes routes
+
+	// routing counts Report calls that can't be routed or reach another server.
+	routing *routingMetrics
+
+	// discovery learns the server IDs of the connections for Report calls that can't be routed yet.
+	discovery discovery
+
+	// recycleStop stops the connection recycling started by the last RecycleConnections call.
+	recycleMu   sync.Mutex
+	recycleStop chan struct{}
+
+	// state holds clientClosedFlag and the number of in-flight Target and Report calls.
+	state atomic.Int64
+	// done is closed when the client is closed.
+	done chan struct{}
+	// idle is closed when the client is closed and the last in-flight call has returned.
+	idle      chan struct{}
+	idleOnce  sync.Once
+	closeOnce sync.Once
 }
 
 // clientsGroup is a structure that holds a group of client instances for managing sharded connections to the signle server.
 type clientsGroup struct {
-	// roundRobin is a counter used for load balancing to distribute requests evenly across client connections.
-	roundRobin uint64
-	clients    []*client
-	id         atomic.Uint32
+	// balancer picks the connection for every request to the shard.
+	balancer  Balancer
+	clients   []*client
+	endpoints []Endpoint
+
+	// addr is the shard address from Configuration.Addrs.
+	addr string
+
+	// hedge counts hedged Target requests sent to the shard.
+	hedge *hedgeMetrics
+
+	// latency tracks recent Target latencies when Configuration.Hedge uses a percentile; otherwise nil.
+	latency *latencyWindow
+
+	// breaker takes the shard out of Target rotation while it fails; nil if Configuration.Breaker is disabled.
+	breaker *breaker
+
+	// latencyAvg is the moving average of request latencies over all connections of the shard.
+	latencyAvg ewma
+
+	// dialer dials the connections of the shard and tracks them until they are closed.
+	dialer *dnsDialer
+
+	// authOnDial is set from Configuration.AuthOnDial.
+	authOnDial bool
+
+	// weight is the number of times the shard is listed in shardSet.endpoints.
+	weight int
+
+	// draining and drainTimer are set by DrainShard. They are guarded by ShardedClient.shardsMu.
+	draining   bool
+	drainTimer *time.Timer
 }
 
-// getClient returns a pointer to the next client in the clientsGroup's clients list using a round-robin load-balancing strategy.
+// getClient returns a pointer to the client in the clientsGroup's clients list chosen by the shard balancer.
 func (sh *clientsGroup) getClient() *client {
-	n := atomic.AddUint64(&sh.roundRobin, 1)
-	idx := n % uint64(len(sh.clients))
+	if sh.authOnDial {
+		return sh.getReadyClient()
+	}
+	return sh.clients[sh.balancer.Pick(sh.endpoints)]
+}
+
+// PendingRequests returns the number of pending requests over all connections of the shard.
+func (sh *clientsGroup) PendingRequests() int {
+	n := 0
+	for _, c := range sh.clients {
+		n += c.c.PendingRequests()
+	}
+	return n
+}
 
-	return sh.clients[idx]
+// Latency returns the moving average of request latencies of the shard.
+func (sh *clientsGroup) Latency() time.Duration {
+	return sh.latencyAvg.value()
 }
 
 // Target is the primary request used by a third-party platform to:
@@ -89,20 +241,56 @@ func (sh *clientsGroup) getClient() *client {
 // Obtain identification accuracy to determine the validity and reliability of the response.
 // For more details, see here: [LINK]
 func (sc *ShardedClient) Target(req *base.TargetRequest) (*base.TargetResponse, base.RPCServerResponseCode, error) {
-	shard := sc.getGroup()
+	return sc.TargetContext(context.Background(), req)
+}
+
+// TargetContext is like Target, but the request is bound to ctx.
+// A deadline on ctx overrides MaxRequestDuration. If ctx is canceled or its deadline expires
+// before the response arrives, TargetContext returns immediately with NETWORK_ERROR
+// and an error for which errors.Is(err, ctx.Err()) reports true.
+//
+// Failed attempts are retried according to Configuration.Retry; every retry may be sent to another shard.
+// Slow attempts are hedged to another shard according to Configuration.Hedge.
+func (sc *ShardedClient) TargetContext(ctx context.Context, req *base.TargetRequest) (*base.TargetResponse, base.RPCServerResponseCode, error) {
+	if !sc.acquire() {
+		return nil, base.RPCServerResponseCode_UNKNOWN, ErrClosed
+	}
+	defer sc.release()
+
+	var targetResp *base.TargetResponse
+	statusCode, err := sc.retrier.do(ctx, contract.Target, func() (base.RPCServerResponseCode, error) {
+		var (
+			statusCode base.RPCServerResponseCode
+			err        error
+		)
+		targetResp, statusCode, err = sc.targetHedged(ctx, req)
+		return statusCode, err
+	})
+	if nil != err {
+		return nil, statusCode, err
+	}
+	return targetResp, statusCode, nil
+}
+
+// target sends a single Target attempt to shard. probe is the breaker token returned by getGroup.
+func (sc *ShardedClient) target(ctx context.Context, req *base.TargetRequest, shard *clientsGroup, probe uint64) (*base.TargetResponse, base.RPCServerResponseCode, error) {
 	cl := shard.getClient()
-	res, statusCode, err := cl.doUnary(req, &base.TargetResponse{}, contract.Target)
+	st := time.Now()
+	res, statusCode, err := cl.doUnary(ctx, req, &base.TargetResponse{}, contract.Target)
+	if shard.breaker != nil {
+		shard.breaker.observe(ctx, probe, statusCode, err)
+	}
 	if nil != err {
 		return nil, statusCode, err
 	}
-	targetResp := res.(*base.TargetResponse)
-	serverID := cl.serverID
-	if serverID == 0 {
-		serverID = uniqid.GetServerID(targetResp.TrackingId)
-		cl.serverID = serverID
+	if shard.latency != nil {
+		shard.latency.update(time.Since(st))
 	}
-	if serverID > 0 {
-		shard.id.Store(uint32(serverID))
+	targetResp := res.(*base.TargetResponse)
+	if cl.GetServerID() == 0 {
+		if id, err := contract.ParseTrackingIDBytes(targetResp.TrackingId); err == nil {
+			cl.setServerID(id.ServerID())
+		}
 	}
 	return targetResp, statusCode, nil
 }
@@ -111,56 +299,179 @@ func (sc *ShardedClient) Target(req *base.TargetRequest) (*base.TargetResponse,
 // This mechanism is used to record statistical data and perform settlements between system users as part of the third-party billing strategy.
 // For more details, see here: [LINK]
 func (sc *ShardedClient) Report(req *base.ReportRequest) (base.RPCServerResponseCode, error) {
-	if nil == req.TrackingId {
-		return base.RPCServerResponseCode_UNKNOWN, fmt.Errorf("tracking id is required")
-	}
-	shard := sc.lookupGroup(uniqid.GetServerID(req.TrackingId))
-	if nil == shard {
-		return base.RPCServerResponseCode_UNKNOWN, fmt.Errorf("unknown server for tracking id: %q", req.TrackingId)
+	return sc.ReportContext(context.Background(), req)
+}
+
+// ReportContext is like Report, but the request is bound to ctx.
+// ctx deadline and cancellation are handled the same way as in TargetContext.
+//
+// If Configuration.ReportSpool is set and the report fails with NETWORK_ERROR, it is persisted
+// for background replay and the returned error wraps ErrReportSpooled.
+func (sc *ShardedClient) ReportContext(ctx context.Context, req *base.ReportRequest) (base.RPCServerResponseCode, error) {
+	if !sc.acquire() {
+		return base.RPCServerResponseCode_UNKNOWN, ErrClosed
 	}
+	defer sc.release()
 
-	_, statusCode, err := shard.getClient().doUnary(req, nil, contract.Report)
+	statusCode, err := sc.sendReport(ctx, req)
+	if err != nil && statusCode == base.RPCServerResponseCode_NETWORK_ERROR && sc.ReportSpool != nil {
+		if spoolErr := sc.ReportSpool.Append(req); spoolErr == nil {
+			err = fmt.Errorf("%w: %w", ErrReportSpooled, err)
+		}
+	}
 	return statusCode, err
 }
 
-// IsValidTrackingID checks if the provided tracking ID is valid by ensuring it is not nil and maps to a valid shard.
-func (sc *ShardedClient) IsValidTrackingID(trackingId []byte) bool {
-	if nil == trackingId {
-		return false
+// sendReport routes req over a connection to the server that issued its tracking id.
+// If no connection to that server is known, the server IDs of the other connections are learned
+// with the connection handshake before giving up. Retries according to Configuration.Retry stay on the same server.
+func (sc *ShardedClient) sendReport(ctx context.Context, req *base.ReportRequest) (base.RPCServerResponseCode, error) {
+	rt, err := sc.routeTrackingID(req.TrackingId)
+	if err != nil && sc.canDiscover(err) {
+		sc.discoverShards(ctx)
+		rt, err = sc.routeTrackingID(req.TrackingId)
 	}
-	shard := sc.lookupGroup(uniqid.GetServerID(trackingId))
-	return nil != shard
+	if err != nil {
+		sc.countUnroutable(err)
+		return base.RPCServerResponseCode_UNKNOWN, err
+	}
+
+	return sc.retrier.do(ctx, contract.Report, func() (base.RPCServerResponseCode, error) {
+		cl := rt.getClient()
+		_, statusCode, err := cl.doUnary(ctx, req, nil, contract.Report)
+		if serverID := cl.GetServerID(); serverID != 0 && serverID != rt.serverID {
+			// The connection was redialed to another server since the route was looked up.
+			sc.routing.misrouted.Inc()
+			cl.log.log(slog.LevelWarn, "report misrouted", cl.addr,
+				slog.Int("server_id", int(serverID)),
+				slog.Int("want_server_id", int(rt.serverID)))
+		}
+		return statusCode, err
+	})
+}
+
+// Close closes the client immediately: it stops the DNS refresh and the spool replay and closes all connections.
+// Calls that are in flight fail with NETWORK_ERROR; calls made after Close fail with ErrClosed.
+//
+// fastrpc doesn't support stopping its per-connection goroutines, so they stay parked
+// without connections after Close.
+func (sc *ShardedClient) Close() error {
+	sc.markClosed()
+	sc.closeConns()
+	return nil
+}
+
+// Shutdown stops accepting new calls, waits for the in-flight Target and Report calls to return
+// and then closes the client like Close.
+//
+// If ctx is done first, Shutdown closes the client anyway, aborting the remaining calls, and returns ctx.Err().
+// Reports queued in an AsyncReporter are not waited for; close the reporter before the client.
+func (sc *ShardedClient) Shutdown(ctx context.Context) error {
+	sc.markClosed()
+
+	var err error
+	select {
+	case <-sc.idle:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	sc.closeConns()
+	return err
 }
 
-func (sc *ShardedClient) lookupGroup(serverID uint16) *clientsGroup {
-	var shard *clientsGroup
-	for i := 0; i < len(sc.clients); i++ {
-		shard = sc.clients[i]
-		shardID := uint16(shard.id.Load())
-		if shardID > 0 && shardID == serverID {
-			return shard
+func (sc *ShardedClient) markClosed() {
+	for {
+		s := sc.state.Load()
+		if s&clientClosedFlag != 0 {
+			return
+		}
+		if sc.state.CompareAndSwap(s, s|clientClosedFlag) {
+			close(sc.done)
+			if s == 0 {
+				sc.idleOnce.Do(func() { close(sc.idle) })
+			}
+			return
 		}
 	}
+}
 
-	return nil
+func (sc *ShardedClient) closeConns() {
+	sc.closeOnce.Do(func() {
+		sc.shardsMu.Lock()
+		shards := sc.shardSet().clients
+		for _, shard := range shards {
+			if shard.drainTimer != nil {
+				shard.drainTimer.Stop()
+			}
+		}
+		sc.shardsMu.Unlock()
+
+		for _, shard := range shards {
+			shard.dialer.close()
+		}
+		if !sc.Metrics.DisableGlobalRegistration {
+			sc.metrics.unregisterGlobal()
+		}
+	})
 }
 
-// getGroup selects a clientsGroup instance from the sharded clients list using a round-robin load-balancing strategy.
-func (sc *ShardedClient) getGroup() *clientsGroup {
-	n := atomic.AddUint64(&sc.roundRobin, 1)
-	idx := n % uint64(len(sc.clients))
+// acquire registers an in-flight call. It returns false if the client is closed.
+func (sc *ShardedClient) acquire() bool {
+	for {
+		s := sc.state.Load()
+		if s&clientClosedFlag != 0 {
+			return false
+		}
+		if sc.state.CompareAndSwap(s, s+1) {
+			return true
+		}
+	}
+}
 
-	return sc.clients[idx]
+func (sc *ShardedClient) release() {
+	if sc.state.Add(-1) == clientClosedFlag {
+		sc.idleOnce.Do(func() { close(sc.idle) })
+	}
+}
+
+// IsValidTrackingID checks if the provided tracking ID is valid by ensuring it is well-formed and maps to a valid shard.
+func (sc *ShardedClient) IsValidTrackingID(trackingId []byte) bool {
+	_, err := sc.routeTrackingID(trackingId)
+	return err == nil
+}
+
+// ValidateTrackingID is like IsValidTrackingID, but explains why trackingId can't be reported.
+// The error is ErrMissingTrackingID, or wraps ErrUnknownShard and, for a malformed id,
+// a *contract.TrackingIDError.
+func (sc *ShardedClient) ValidateTrackingID(trackingId []byte) error {
+	_, err := sc.routeTrackingID(trackingId)
+	return err
+}
+
+// getGroup selects a clientsGroup instance from the sharded clients list using the configured balancer.
+// Shards with an open circuit breaker are skipped unless all of them are open.
+// probe is the breaker token of the Target if it is the probe of a half-open shard, and 0 otherwise.
+func (sc *ShardedClient) getGroup() (shard *clientsGroup, probe uint64) {
+	endpoints := sc.shardSet().endpoints
+	idx := sc.balancer.Pick(endpoints)
+
+	if sc.Breaker.FailureRatio == 0 {
+		return endpoints[idx].(*clientsGroup), 0
+	}
+	for i := 0; i < len(endpoints); i++ {
+		shard := endpoints[(idx+i)%len(endpoints)].(*clientsGroup)
+		if ok, probe := shard.breaker.allow(); ok {
+			return shard, probe
+		}
+	}
+	return endpoints[idx].(*clientsGroup), 0
 }
 
 // PendingRequests computes the total number of pending requests across all clients managed by the ShardedClient instance.
 func (sc *ShardedClient) PendingRequests() int {
 	n := 0
-	for _, c := range sc.clients {
-		for i := 0; i < len(c.clients); i++ {
-			n += c.clients[i].c.PendingRequests()
-		}
-
+	for _, c := range sc.shardSet().clients {
+		n += c.PendingRequests()
 	}
 	return n
 }
@@ -168,7 +479,7 @@ func (sc *ShardedClient) PendingRequests() int {
 // Reconnects computes and returns the total number of reconnections across all clients in the ShardedClient instance.
 func (sc *ShardedClient) Reconnects() int {
 	n := 0
-	for _, c := range sc.clients {
+	for _, c := range sc.shardSet().clients {
 		for i := 0; i < len(c.clients); i++ {
 			n += int(c.clients[i].Reconnects())
 		}
@@ -177,71 +488,137 @@ func (sc *ShardedClient) Reconnects() int {
 	return n
 }
 
+// WritePrometheus writes the metrics of the client to w in Prometheus text exposition format.
+// Call it from the handler of a metrics endpoint when MetricsConfig.DisableGlobalRegistration is set.
+func (sc *ShardedClient) WritePrometheus(w io.Writer) {
+	sc.metrics.set.WritePrometheus(w)
+}
+
 // NewClient initializes and returns a new instance of ShardedClient
 const (
 	defaultMaxRequestDuration             = time.Second
 	defaultMaximumSimultaneousConnections = 128
 	defaultMaxPendingRequests             = 8
 	defaultBufferSize                     = 4 * 1024
+	defaultDrainGracePeriod               = 5 * time.Minute
 )
 
 // NewClient initializes and returns a new instance of ShardedClient.
+// It replaces invalid values with defaults, and logs malformed entries of Addrs or Shards;
+// NewValidatedClient returns an error instead.
 func NewClient(cfg *Configuration, tlsConfig *tls.Config) *ShardedClient {
 	cfg = normalizeConfiguration(cfg)
+	logger := newEventLogger(cfg.Logger)
+	shards := cfg.shardConfigs(logger)
 
-	sc := &ShardedClient{Configuration: *cfg}
+	registry := newMetricsRegistry(cfg.Metrics)
+	if !cfg.Metrics.DisableGlobalRegistration {
+		registry.registerGlobal()
+	}
 
-	for _, shardAddr := range strings.Split(cfg.Addrs, ",") {
-		shardAddr = strings.TrimSpace(shardAddr)
-		if shardAddr == "" {
-			continue
-		}
+	sc := &ShardedClient{
+		Configuration: *cfg,
+		retrier:       newRetrier(cfg.Retry, registry),
+		metrics:       registry,
+		routing:       newRoutingMetrics(registry),
+		balancer:      cfg.Balancer(),
+		tokens:        cfg.tokenSource(),
+		tlsConfig:     tlsConfig,
+		log:           logger,
+		done:          make(chan struct{}),
+		idle:          make(chan struct{}),
+	}
+
+	groups := make([]*clientsGroup, 0, len(shards))
+	for _, shardCfg := range shards {
+		groups = append(groups, sc.newShard(shardCfg))
+	}
+	sc.publishShards(groups)
+
+	if cfg.ReportSpool != nil {
+		cfg.ReportSpool.attachMetrics(registry)
+		go sc.replaySpool(cfg.ReportSpool)
+	}
+	if cfg.CertificateReload.Reloader != nil {
+		go sc.reloadCertificates(cfg.CertificateReload)
+	}
+
+	return sc
+}
 
-		metrics := buildShardMetrics(shardAddr)
-		shard := &clientsGroup{}
-		dialer := newDNSDialer(shardAddr, cfg.MaxDialDuration, cfg.DNSRefreshInterval)
-
-		for i := 0; i < cfg.MaximumSimultaneousConnections; i++ {
-			rpc := &client{
-				maxRequestDuration: cfg.MaxRequestDuration,
-				metricGroups:       metrics,
-				JwtToken:           cfg.JwtToken,
-				disableAuth:        cfg.DisableAuth,
-				c: &fastrpc.Client{
-					SniffHeader:     sdkutil.SniffHeader,
-					ProtocolVersion: sdkutil.ProtocolVersion,
-					NewResponse: func() fastrpc.ResponseReader {
-						return &contract.Response{}
-					},
-					TLSConfig: tlsConfig,
-					Addr:      shardAddr,
-					// High-read timeout helps avoid frequent reconnects on mostly idle connections.
-					ReadTimeout:        time.Minute,
-					WriteTimeout:       cfg.MaxRequestDuration * 10,
-					MaxPendingRequests: cfg.MaxPendingRequests,
-					CompressType:       fastrpc.CompressSnappy,
-					WriteBufferSize:    cfg.WriteBufferSize,
-					ReadBufferSize:     cfg.ReadBufferSize,
+// newShard creates the connections to the shard described by shardCfg. They are dialed lazily.
+func (sc *ShardedClient) newShard(shardCfg ShardConfig) *clientsGroup {
+	shardAddr := shardCfg.Addr
+	shardMetrics := buildShardMetrics(sc.metrics, shardAddr)
+	shard := &clientsGroup{
+		addr:       shardAddr,
+		weight:     shardCfg.Weight,
+		balancer:   sc.Balancer(),
+		hedge:      newHedgeMetrics(sc.metrics, shardAddr),
+		authOnDial: sc.AuthOnDial,
+	}
+	if sc.Hedge.Percentile > 0 {
+		shard.latency = newLatencyWindow(sc.Hedge.Percentile)
+	}
+	if sc.Breaker.FailureRatio > 0 {
+		shard.breaker = newBreaker(sc.Breaker, shardAddr, shardMetrics, sc.metrics)
+	}
+	dialer := newDNSDialer(shardAddr, sc.MaxDialDuration, sc.DNSRefreshInterval, sc.log)
+	shard.dialer = dialer
+
+	for i := 0; i < shardCfg.Conns; i++ {
+		rpc := &client{
+			pinnedServerID:     shardCfg.ServerID,
+			maxRequestDuration: sc.MaxRequestDuration,
+			addr:               shardAddr,
+			metricGroups:       shardMetrics,
+			shardLatency:       &shard.latencyAvg,
+			tokens:             sc.tokens,
+			disableAuth:        sc.DisableAuth,
+			authOnDial:         sc.AuthOnDial,
+			onServerID:         sc.updateRoute,
+			shard:              shard,
+			interceptors:       sc.Interceptors,
+			log:                sc.log,
+			c: &fastrpc.Client{
+				SniffHeader:     sdkutil.SniffHeader,
+				ProtocolVersion: sdkutil.ProtocolVersion,
+				NewResponse: func() fastrpc.ResponseReader {
+					return &contract.Response{}
 				},
-			}
+				Addr: shardAddr,
+				// High-read timeout helps avoid frequent reconnects on mostly idle connections.
+				ReadTimeout:        time.Minute,
+				WriteTimeout:       sc.MaxRequestDuration * 10,
+				MaxPendingRequests: sc.MaxPendingRequests,
+				CompressType:       fastrpc.CompressSnappy,
+				WriteBufferSize:    sc.WriteBufferSize,
+				ReadBufferSize:     sc.ReadBufferSize,
+			},
+		}
 
-			rpcRef := rpc
-			rpc.c.Dial = func(addr string) (net.Conn, error) {
-				conn, err := dialer.dial(rpcRef)
-				if err != nil {
-					return nil, err
-				}
-				rpcRef.connGen.Add(1)
-				return conn, nil
-			}
+		rpc.c.TLSConfig = rpc.tracedTLSConfig(sc.tlsConfig)
+		rpc.serverID.Store(uint32(shardCfg.ServerID))
 
-			shard.clients = append(shard.clients, rpc)
+		rpcRef := rpc
+		rpc.c.Dial = func(addr string) (net.Conn, error) {
+			conn, err := dialer.dial(rpcRef)
+			if err != nil {
+				return nil, err
+			}
+			gen := rpcRef.connGen.Add(1)
+			rpcRef.logDial(gen)
+			if shard.authOnDial {
+				go rpcRef.authAfterDial()
+			}
+			return conn, nil
 		}
 
-		sc.clients = append(sc.clients, shard)
+		shard.clients = append(shard.clients, rpc)
+		shard.endpoints = append(shard.endpoints, rpc)
 	}
 
-	return sc
+	return shard
 }
 
 func normalizeConfiguration(cfg *Configuration) *Configuration {
@@ -253,7 +630,7 @@ func normalizeConfiguration(cfg *Configuration) *Configuration {
 		normalized.MaximumSimultaneousConnections = defaultMaximumSimultaneousConnections
 	}
 	normalized.Addrs = normalizeAddrs(normalized.Addrs)
-	if len(normalized.Addrs) == 0 {
+	if len(normalized.Addrs) == 0 && len(normalized.Shards) == 0 {
 		normalized.Addrs = defaultCloudAddr
 	}
 	if normalized.MaxRequestDuration <= 0 {
@@ -265,6 +642,9 @@ func normalizeConfiguration(cfg *Configuration) *Configuration {
 	if normalized.DNSRefreshInterval == 0 {
 		normalized.DNSRefreshInterval = defaultDNSRefreshInterval
 	}
+	if normalized.DrainGracePeriod <= 0 {
+		normalized.DrainGracePeriod = defaultDrainGracePeriod
+	}
 	if normalized.MaxPendingRequests <= 0 {
 		normalized.MaxPendingRequests = defaultMaxPendingRequests
 	}
@@ -274,6 +654,13 @@ func normalizeConfiguration(cfg *Configuration) *Configuration {
 	if normalized.WriteBufferSize <= 0 {
 		normalized.WriteBufferSize = defaultBufferSize
 	}
+	normalized.Retry = normalizeRetryPolicy(normalized.Retry)
+	normalized.Hedge = normalizeHedgePolicy(normalized.Hedge)
+	normalized.Breaker = normalizeBreakerPolicy(normalized.Breaker)
+	normalized.CertificateReload = normalizeCertificateReloadPolicy(normalized.CertificateReload)
+	if normalized.Balancer == nil {
+		normalized.Balancer = NewRoundRobinBalancer
+	}
 	return &normalized
 }
 
@@ -288,11 +675,11 @@ func normalizeAddrs(addrs string) string {
 	return strings.Join(normalized, ",")
 }
 
-func buildShardMetrics(shardAddr string) [contract.MaxRequestIdentifier + 1]*metricsGroup {
+func buildShardMetrics(registry *metricsRegistry, shardAddr string) [contract.MaxRequestIdentifier + 1]*metricsGroup {
 	var metrics [contract.MaxRequestIdentifier + 1]*metricsGroup
 	for i := 1; i <= int(contract.MaxRequestIdentifier); i++ {
 		req := contract.RPCRegister(i)
-		metrics[i] = newMetricsGroup(req.String(), shardAddr)
+		metrics[i] = newMetricsGroup(registry, req.String(), shardAddr)
 	}
 	return metrics
 }
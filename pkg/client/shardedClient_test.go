@@ -1,15 +1,16 @@
 package client
 
 import (
+	"context"
 	"errors"
 	"net"
 	"sync/atomic"
 	"testing"
 	"time"
 
-	"github.com/VictoriaMetrics/metrics"
 	"github.com/aradilov/fastrpc"
 	base "github.com/mygaru/dcr-sdk/gen/base1"
+	"github.com/mygaru/dcr-sdk/internal/sdkutil"
 	"github.com/mygaru/dcr-sdk/pkg/contract"
 )
 
@@ -94,16 +95,17 @@ func TestNewClientDoesNotMutateConfiguration(t *testing.T) {
 
 func TestBuildShardMetricsUsesRequestAndAddrLabels(t *testing.T) {
 	shardAddr := "metrics-labels.local:7943"
-	groups := buildShardMetrics(shardAddr)
+	registry := newMetricsRegistry(MetricsConfig{})
+	groups := buildShardMetrics(registry, shardAddr)
 
-	want := metrics.GetOrCreateCounter(`dcrRPCClientRequest{request="target",addr="metrics-labels.local:7943"}`)
-	if groups[contract.Target].request != want {
+	want := registry.set.GetOrCreateCounter(`dcrRPCClientRequest{request="target",addr="metrics-labels.local:7943"}`)
+	if groups[contract.Target].request.Counter != want {
 		t.Fatalf("expected target request counter to use request and addr labels")
 	}
 }
 
 func TestDoUnaryIncrementsRequestMetric(t *testing.T) {
-	metricGroups := buildShardMetrics("request-counter.local:7943")
+	metricGroups := buildShardMetrics(newMetricsRegistry(MetricsConfig{}), "request-counter.local:7943")
 	requests := metricGroups[contract.Target].request
 	before := requests.Get()
 
@@ -123,7 +125,7 @@ func TestDoUnaryIncrementsRequestMetric(t *testing.T) {
 	cl.connGen.Store(1)
 	atomic.StoreUint64(&cl.authedGen, 1)
 
-	_, _, _ = cl.doUnary(testTargetRequest(), nil, contract.Target)
+	_, _, _ = cl.doUnary(context.Background(), testTargetRequest(), nil, contract.Target)
 	if got := requests.Get(); got != before+1 {
 		t.Fatalf("expected request counter to increment to %d, got %d", before+1, got)
 	}
@@ -145,7 +147,7 @@ func TestDoUnaryTimesOutAuthReconnectPath(t *testing.T) {
 	}
 
 	start := time.Now()
-	_, statusCode, err := cl.doUnary(testTargetRequest(), nil, contract.Target)
+	_, statusCode, err := cl.doUnary(context.Background(), testTargetRequest(), nil, contract.Target)
 	elapsed := time.Since(start)
 
 	if err == nil {
@@ -177,7 +179,7 @@ func TestDoUnaryFailsFastWhenAuthAlreadyInProgress(t *testing.T) {
 	defer cl.mu.Unlock()
 
 	start := time.Now()
-	_, statusCode, err := cl.doUnary(testTargetRequest(), nil, contract.Target)
+	_, statusCode, err := cl.doUnary(context.Background(), testTargetRequest(), nil, contract.Target)
 	elapsed := time.Since(start)
 
 	if err == nil {
@@ -194,6 +196,343 @@ func TestDoUnaryFailsFastWhenAuthAlreadyInProgress(t *testing.T) {
 	}
 }
 
+func TestDoUnaryContextDeadlineOverridesMaxRequestDuration(t *testing.T) {
+	cl := &client{
+		maxRequestDuration: time.Second,
+		c: &fastrpc.Client{
+			Addr: "127.0.0.1:1",
+			NewResponse: func() fastrpc.ResponseReader {
+				return &contract.Response{}
+			},
+			Dial: func(addr string) (net.Conn, error) {
+				time.Sleep(100 * time.Millisecond)
+				return nil, errors.New("dial failed")
+			},
+		},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, statusCode, err := cl.doUnary(ctx, testTargetRequest(), nil, contract.Target)
+	elapsed := time.Since(start)
+
+	if err == nil {
+		t.Fatalf("expected deadline error")
+	}
+	if !errors.Is(err, fastrpc.ErrTimeout) && !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected timeout error, got %v", err)
+	}
+	if statusCode != base.RPCServerResponseCode_NETWORK_ERROR {
+		t.Fatalf("expected NETWORK_ERROR status, got %s", statusCode)
+	}
+	if elapsed >= 80*time.Millisecond {
+		t.Fatalf("expected context deadline to cut the auth path, elapsed %s", elapsed)
+	}
+}
+
+func TestDoUnaryReturnsImmediatelyOnCancel(t *testing.T) {
+	metricGroups := buildShardMetrics(newMetricsRegistry(MetricsConfig{}), "cancel.local:7943")
+	canceled := metricGroups[contract.Target].canceled
+	before := canceled.Get()
+
+	cl := &client{
+		maxRequestDuration: time.Second,
+		metricGroups:       metricGroups,
+		c: &fastrpc.Client{
+			Addr: "127.0.0.1:1",
+			NewResponse: func() fastrpc.ResponseReader {
+				return &contract.Response{}
+			},
+			Dial: func(addr string) (net.Conn, error) {
+				time.Sleep(200 * time.Millisecond)
+				return nil, errors.New("dial failed")
+			},
+		},
+	}
+	cl.connGen.Store(1)
+	atomic.StoreUint64(&cl.authedGen, 1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+
+	start := time.Now()
+	_, statusCode, err := cl.doUnary(ctx, testTargetRequest(), nil, contract.Target)
+	elapsed := time.Since(start)
+
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if statusCode != base.RPCServerResponseCode_NETWORK_ERROR {
+		t.Fatalf("expected NETWORK_ERROR status, got %s", statusCode)
+	}
+	if elapsed >= 100*time.Millisecond {
+		t.Fatalf("expected cancellation to return immediately, elapsed %s", elapsed)
+	}
+	if got := canceled.Get(); got != before+1 {
+		t.Fatalf("expected canceled counter to increment to %d, got %d", before+1, got)
+	}
+}
+
+func TestDoUnaryEnforcesDeadlineOnAuthenticatedConnection(t *testing.T) {
+	addr := startDelayedServer(t, 200*time.Millisecond)
+	metricGroups := buildShardMetrics(newMetricsRegistry(MetricsConfig{}), "strict-deadline.local:7943")
+
+	cl := newTestRPCClient(addr, 20*time.Millisecond, metricGroups)
+
+	start := time.Now()
+	_, statusCode, err := cl.doUnary(context.Background(), testTargetRequest(), nil, contract.Target)
+	elapsed := time.Since(start)
+
+	if !errors.Is(err, fastrpc.ErrTimeout) {
+		t.Fatalf("expected timeout error, got %v", err)
+	}
+	if statusCode != base.RPCServerResponseCode_NETWORK_ERROR {
+		t.Fatalf("expected NETWORK_ERROR status, got %s", statusCode)
+	}
+	if elapsed >= 80*time.Millisecond {
+		t.Fatalf("expected the SDK timer to enforce the deadline, elapsed %s", elapsed)
+	}
+}
+
+func TestDoUnaryCountsLateResponses(t *testing.T) {
+	addr := startDelayedServer(t, 50*time.Millisecond)
+	metricGroups := buildShardMetrics(newMetricsRegistry(MetricsConfig{}), "late-response.local:7943")
+	late := metricGroups[contract.Target].late
+	before := late.Get()
+
+	cl := newTestRPCClient(addr, time.Second, metricGroups)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+	if _, _, err := cl.doUnary(ctx, testTargetRequest(), nil, contract.Target); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for late.Get() == before {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected late response counter to increment")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// startDelayedServer starts a fastrpc server that answers every request with OK after delay.
+func TestCloseFailsSubsequentCalls(t *testing.T) {
+	addr := startTestServer(t, func(ctx *contract.RequestCtx) {
+		writeTestTargetResponse(ctx, "0400000000000001")
+	})
+	sc := NewClient(&Configuration{
+		Addrs:                          addr,
+		DisableAuth:                    true,
+		MaximumSimultaneousConnections: 1,
+		DNSRefreshInterval:             -1,
+	}, nil)
+
+	if _, _, err := sc.Target(testTargetRequest()); err != nil {
+		t.Fatalf("target before close: %v", err)
+	}
+	if err := sc.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	if _, _, err := sc.Target(testTargetRequest()); !errors.Is(err, ErrClosed) {
+		t.Fatalf("expected ErrClosed from Target, got %v", err)
+	}
+	if _, err := sc.Report(testReportRequest()); !errors.Is(err, ErrClosed) {
+		t.Fatalf("expected ErrClosed from Report, got %v", err)
+	}
+	if len(sc.shardSet().clients[0].dialer.conns) != 0 {
+		t.Fatalf("expected all connections to be closed")
+	}
+	if _, err := sc.shardSet().clients[0].dialer.dial(nil); !errors.Is(err, ErrClosed) {
+		t.Fatalf("expected ErrClosed from dial, got %v", err)
+	}
+}
+
+func TestShutdownWaitsForInFlightCalls(t *testing.T) {
+	addr := startTestServer(t, func(ctx *contract.RequestCtx) {
+		time.Sleep(100 * time.Millisecond)
+		writeTestTargetResponse(ctx, "0400000000000001")
+	})
+	sc := NewClient(&Configuration{
+		Addrs:                          addr,
+		DisableAuth:                    true,
+		MaximumSimultaneousConnections: 1,
+		DNSRefreshInterval:             -1,
+	}, nil)
+
+	errCh := make(chan error, 1)
+	go func() {
+		_, _, err := sc.Target(testTargetRequest())
+		errCh <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	if err := sc.Shutdown(context.Background()); err != nil {
+		t.Fatalf("shutdown: %v", err)
+	}
+	select {
+	case err := <-errCh:
+		if err != nil {
+			t.Fatalf("expected the in-flight target to complete, got %v", err)
+		}
+	default:
+		t.Fatalf("expected Shutdown to wait for the in-flight target")
+	}
+}
+
+func TestShutdownReturnsContextErrorWhenCallsDoNotFinish(t *testing.T) {
+	addr := startTestServer(t, func(ctx *contract.RequestCtx) {
+		time.Sleep(300 * time.Millisecond)
+		writeTestTargetResponse(ctx, "0400000000000001")
+	})
+	sc := NewClient(&Configuration{
+		Addrs:                          addr,
+		DisableAuth:                    true,
+		MaximumSimultaneousConnections: 1,
+		DNSRefreshInterval:             -1,
+	}, nil)
+
+	go func() {
+		_, _, _ = sc.Target(testTargetRequest())
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := sc.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected DeadlineExceeded, got %v", err)
+	}
+	if _, _, err := sc.Target(testTargetRequest()); !errors.Is(err, ErrClosed) {
+		t.Fatalf("expected ErrClosed after shutdown, got %v", err)
+	}
+}
+
+func startDelayedServer(t *testing.T, delay time.Duration) string {
+	t.Helper()
+
+	return startTestServer(t, func(ctx *contract.RequestCtx) {
+		time.Sleep(delay)
+		ctx.Response.SetStatusCode(base.RPCServerResponseCode_OK)
+	})
+}
+
+// startTestServer starts a server that predates contract.Hello: it rejects Hello with INVALID_REQUEST
+// and passes the other requests to handler.
+func startTestServer(t *testing.T, handler func(ctx *contract.RequestCtx)) string {
+	t.Helper()
+
+	return startRawTestServer(t, func(ctx *contract.RequestCtx) {
+		if ctx.Request.GetName() == contract.Hello {
+			ctx.Response.SetStatusCode(base.RPCServerResponseCode_INVALID_REQUEST)
+			return
+		}
+		handler(ctx)
+	})
+}
+
+// startRawTestServer starts a server that passes every request, including contract.Hello, to handler.
+func startRawTestServer(t *testing.T, handler func(ctx *contract.RequestCtx)) string {
+	t.Helper()
+
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	server := &fastrpc.Server{
+		SniffHeader:     sdkutil.SniffHeader,
+		ProtocolVersion: sdkutil.ProtocolVersion,
+		Handler: func(ctxv fastrpc.HandlerCtx) fastrpc.HandlerCtx {
+			handler(ctxv.(*contract.RequestCtx))
+			return ctxv
+		},
+		NewHandlerCtx: func() fastrpc.HandlerCtx {
+			return &contract.RequestCtx{}
+		},
+		CompressType:     fastrpc.CompressSnappy,
+		PipelineRequests: true,
+	}
+	go func() {
+		_ = server.Serve(ln)
+	}()
+	t.Cleanup(func() {
+		_ = ln.Close()
+	})
+	return ln.Addr().String()
+}
+
+// testServerConfig configures the server started by startHelloTestServer.
+type testServerConfig struct {
+	// hello is the reply to contract.Hello, sent with helloStatus, or with OK if helloStatus is zero.
+	hello       contract.HelloResponse
+	helloStatus base.RPCServerResponseCode
+
+	// hellos, targets and reports count the requests of each kind; they may be nil.
+	hellos, targets, reports *atomic.Int64
+}
+
+// startHelloTestServer starts a server that answers contract.Hello with cfg.hello and the other requests with OK.
+func startHelloTestServer(t *testing.T, cfg testServerConfig) string {
+	t.Helper()
+
+	helloStatus := cfg.helloStatus
+	if helloStatus == base.RPCServerResponseCode_UNKNOWN {
+		helloStatus = base.RPCServerResponseCode_OK
+	}
+	return startRawTestServer(t, func(ctx *contract.RequestCtx) {
+		switch ctx.Request.GetName() {
+		case contract.Hello:
+			countTestRequest(cfg.hellos)
+			ctx.Response.SetStatusCode(helloStatus)
+			ctx.Response.SwapValue(cfg.hello.AppendTo(nil))
+			return
+		case contract.Target:
+			countTestRequest(cfg.targets)
+		case contract.Report:
+			countTestRequest(cfg.reports)
+		}
+		ctx.Response.SetStatusCode(base.RPCServerResponseCode_OK)
+	})
+}
+
+func countTestRequest(n *atomic.Int64) {
+	if n != nil {
+		n.Add(1)
+	}
+}
+
+// newTestShardedClient creates a client from cfg with DNS refresh turned off. It opens a single connection
+// per shard unless cfg sets MaximumSimultaneousConnections, and sets DisableAuth unless cfg sets JwtToken
+// or TokenSource.
+func newTestShardedClient(cfg Configuration) *ShardedClient {
+	cfg.DNSRefreshInterval = -1
+	if cfg.MaximumSimultaneousConnections == 0 {
+		cfg.MaximumSimultaneousConnections = 1
+	}
+	cfg.DisableAuth = len(cfg.JwtToken) == 0 && cfg.TokenSource == nil
+	return NewClient(&cfg, nil)
+}
+
+func newTestRPCClient(addr string, maxRequestDuration time.Duration, metricGroups [contract.MaxRequestIdentifier + 1]*metricsGroup) *client {
+	return &client{
+		maxRequestDuration: maxRequestDuration,
+		metricGroups:       metricGroups,
+		disableAuth:        true,
+		c: &fastrpc.Client{
+			SniffHeader:     sdkutil.SniffHeader,
+			ProtocolVersion: sdkutil.ProtocolVersion,
+			Addr:            addr,
+			NewResponse: func() fastrpc.ResponseReader {
+				return &contract.Response{}
+			},
+			CompressType: fastrpc.CompressSnappy,
+		},
+	}
+}
+
 func testTargetRequest() *base.TargetRequest {
 	return &base.TargetRequest{
 		Uids: []*base.UID{
@@ -201,3 +540,10 @@ func testTargetRequest() *base.TargetRequest {
 		},
 	}
 }
+
+// setTestServerID makes every connection of shard report serverID, as if learned by the connection handshake.
+func setTestServerID(shard *clientsGroup, serverID uint16) {
+	for _, cl := range shard.clients {
+		cl.setServerID(serverID)
+	}
+}
@@ -0,0 +1,196 @@
+package client
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// maxShardWeight bounds ShardConfig.Weight, because a shard is picked from a list with Weight entries per shard.
+const maxShardWeight = 100
+
+// ShardConfig describes a single shard.
+type ShardConfig struct {
+	// Addr is the host:port of the shard.
+	Addr string
+
+	// ServerID pins the ID of the server behind Addr, so Report is routed to the shard before its connections
+	// are dialed. The connection handshake still overrides it. Zero means the ID is learned from the handshake.
+	ServerID uint16
+
+	// Weight is the share of Target traffic sent to the shard relative to the other shards, from 1 to 100.
+	// Zero means 1.
+	Weight int
+
+	// Conns is the number of connections to the shard. Zero means MaximumSimultaneousConnections.
+	Conns int
+}
+
+// String returns c in the Configuration.Addrs syntax.
+func (c ShardConfig) String() string {
+	var sb strings.Builder
+	if c.ServerID > 0 {
+		sb.WriteString(strconv.Itoa(int(c.ServerID)))
+		sb.WriteByte('@')
+	}
+	sb.WriteString(c.Addr)
+	if c.Weight > 0 {
+		fmt.Fprintf(&sb, ";weight=%d", c.Weight)
+	}
+	if c.Conns > 0 {
+		fmt.Fprintf(&sb, ";conns=%d", c.Conns)
+	}
+	return sb.String()
+}
+
+// ParseShards parses addrs in the Configuration.Addrs syntax: a comma-separated list of shards, each of them
+// host:port optionally prefixed with the pinned server ID and followed by options, e.g.
+//
+//	1024@dcr-a.example.com:7937;weight=2;conns=64,1025@dcr-b.example.com:7937
+//
+// The supported options are weight and conns. See ShardConfig.
+func ParseShards(addrs string) ([]ShardConfig, error) {
+	var shards []ShardConfig
+	for _, entry := range strings.Split(addrs, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		shard, err := parseShard(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid shard %q: %w", entry, err)
+		}
+		shards = append(shards, shard)
+	}
+	return shards, nil
+}
+
+// parseShard parses entry of the Configuration.Addrs syntax. On error the returned shard still holds
+// the settings that parsed, for NewClient to fall back to.
+func parseShard(entry string) (ShardConfig, error) {
+	var shard ShardConfig
+	var firstErr error
+	fail := func(err error) {
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	addr, opts, _ := strings.Cut(entry, ";")
+	if id, rest, ok := strings.Cut(addr, "@"); ok {
+		n, err := strconv.ParseUint(strings.TrimSpace(id), 10, 16)
+		if err != nil || n == 0 {
+			fail(fmt.Errorf("server id must be a number from 1 to 65535, got %q", id))
+		} else {
+			shard.ServerID = uint16(n)
+		}
+		addr = rest
+	}
+	shard.Addr = strings.TrimSpace(addr)
+
+	for _, opt := range strings.Split(opts, ";") {
+		opt = strings.TrimSpace(opt)
+		if opt == "" {
+			continue
+		}
+		key, value, _ := strings.Cut(opt, "=")
+		n, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil {
+			fail(fmt.Errorf("option %q must be a number, got %q", key, value))
+			continue
+		}
+		switch strings.TrimSpace(key) {
+		case "weight":
+			shard.Weight = n
+		case "conns":
+			shard.Conns = n
+		default:
+			fail(fmt.Errorf("unknown option %q", key))
+		}
+	}
+	if firstErr != nil {
+		return shard, firstErr
+	}
+	return shard, shard.validate()
+}
+
+func (c ShardConfig) validate() error {
+	if err := validateAddr(c.Addr); err != nil {
+		return err
+	}
+	if c.Weight < 0 || c.Weight > maxShardWeight {
+		return fmt.Errorf("weight must be from 1 to %d, got %d", maxShardWeight, c.Weight)
+	}
+	if c.Conns < 0 {
+		return fmt.Errorf("conns must not be negative, got %d", c.Conns)
+	}
+	return nil
+}
+
+// validateAddr checks that addr is host:port with a non-empty host and a numeric port.
+func validateAddr(addr string) error {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("%w; use host:port, e.g. cloud.mygaru.com:7937", err)
+	}
+	if host == "" {
+		return fmt.Errorf("address %s: missing host", addr)
+	}
+	if n, err := strconv.ParseUint(port, 10, 16); err != nil || n == 0 {
+		return fmt.Errorf("address %s: port must be a number from 1 to 65535, got %q", addr, port)
+	}
+	return nil
+}
+
+// shardConfigs returns Shards if set and the parsed Addrs otherwise, with the defaults applied.
+// Malformed entries are logged, and their invalid settings are replaced with defaults;
+// the address is kept as is, so a malformed one fails when it is dialed. Validate reports them instead.
+func (cfg *Configuration) shardConfigs(log *eventLogger) []ShardConfig {
+	var shards []ShardConfig
+	if len(cfg.Shards) > 0 {
+		for _, shard := range cfg.Shards {
+			if err := shard.validate(); err != nil {
+				log.log(slog.LevelWarn, "invalid shard", shard.Addr, slog.Any("error", err))
+			}
+			shards = append(shards, cfg.shardDefaults(shard.withValidSettings()))
+		}
+		return shards
+	}
+
+	for _, entry := range strings.Split(cfg.Addrs, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		shard, err := parseShard(entry)
+		if err != nil {
+			log.log(slog.LevelWarn, "invalid shard", entry, slog.Any("error", err))
+		}
+		shards = append(shards, cfg.shardDefaults(shard.withValidSettings()))
+	}
+	return shards
+}
+
+// withValidSettings returns c with an out of range weight or number of connections reset to the default.
+func (c ShardConfig) withValidSettings() ShardConfig {
+	if c.Weight < 0 || c.Weight > maxShardWeight {
+		c.Weight = 0
+	}
+	if c.Conns < 0 {
+		c.Conns = 0
+	}
+	return c
+}
+
+// shardDefaults returns shard with the default weight and number of connections applied.
+func (cfg *Configuration) shardDefaults(shard ShardConfig) ShardConfig {
+	if shard.Weight == 0 {
+		shard.Weight = 1
+	}
+	if shard.Conns == 0 {
+		shard.Conns = cfg.MaximumSimultaneousConnections
+	}
+	return shard
+}
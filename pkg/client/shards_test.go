@@ -0,0 +1,120 @@
+package client
+
+import (
+	"log/slog"
+	"reflect"
+	"testing"
+)
+
+func TestParseShards(t *testing.T) {
+	shards, err := ParseShards(" 1024@dcr-a:7937;weight=2;conns=4 , dcr-b:7937,,1025@dcr-c:7937;conns=1")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	want := []ShardConfig{
+		{Addr: "dcr-a:7937", ServerID: 1024, Weight: 2, Conns: 4},
+		{Addr: "dcr-b:7937"},
+		{Addr: "dcr-c:7937", ServerID: 1025, Conns: 1},
+	}
+	if !reflect.DeepEqual(shards, want) {
+		t.Fatalf("unexpected shards: %+v", shards)
+	}
+	if got := shards[0].String(); got != "1024@dcr-a:7937;weight=2;conns=4" {
+		t.Fatalf("unexpected string: %s", got)
+	}
+
+	for _, addrs := range []string{
+		"0@dcr-a:7937",
+		"70000@dcr-a:7937",
+		"x@dcr-a:7937",
+		"dcr-a",
+		":7937",
+		"dcr-a:http",
+		"dcr-a:0",
+		"dcr-a:7937;weight=0x",
+		"dcr-a:7937;weight=101",
+		"dcr-a:7937;conns=-1",
+		"dcr-a:7937;zone=eu",
+	} {
+		if _, err := ParseShards(addrs); err == nil {
+			t.Fatalf("expected %q to be rejected", addrs)
+		}
+	}
+}
+
+func TestPinnedServerIDRoutesReportBeforeDial(t *testing.T) {
+	sc := NewClient(&Configuration{
+		Addrs:              "1024@127.0.0.1:1;conns=2,127.0.0.1:2;conns=3",
+		DNSRefreshInterval: -1,
+	}, nil)
+
+	rt, err := sc.routeTrackingID([]byte("0400000000000001"))
+	if err != nil {
+		t.Fatalf("expected the pinned server id to be routable, got %v", err)
+	}
+	if rt.shard != sc.shardSet().clients[0] || len(rt.clients) != 2 {
+		t.Fatalf("expected a route over the 2 connections of the first shard, got %d", len(rt.clients))
+	}
+	if got := len(sc.shardSet().clients[1].clients); got != 3 {
+		t.Fatalf("expected 3 connections to the second shard, got %d", got)
+	}
+	for _, cl := range sc.shardSet().clients[0].clients {
+		if cl.Reconnects() != 0 {
+			t.Fatalf("expected no connection to be dialed")
+		}
+	}
+}
+
+func TestShardWeightsSplitTargetTraffic(t *testing.T) {
+	sc := NewClient(&Configuration{
+		Shards: []ShardConfig{
+			{Addr: "127.0.0.1:1", Weight: 3},
+			{Addr: "127.0.0.1:2"},
+		},
+		MaximumSimultaneousConnections: 1,
+		DNSRefreshInterval:             -1,
+	}, nil)
+
+	picks := make(map[*clientsGroup]int)
+	for i := 0; i < 40; i++ {
+		shard, _ := sc.getGroup()
+		picks[shard]++
+	}
+	if picks[sc.shardSet().clients[0]] != 30 || picks[sc.shardSet().clients[1]] != 10 {
+		t.Fatalf("expected a 3:1 split, got %d:%d", picks[sc.shardSet().clients[0]], picks[sc.shardSet().clients[1]])
+	}
+}
+
+func TestNewClientIgnoresMalformedShardSettings(t *testing.T) {
+	h := &testLogHandler{}
+	sc := NewClient(&Configuration{
+		Addrs:                          "dcr-a:7937;weight=-1;conns=2,0@dcr-b:7937;size=3,dcr-c:7937;weight=2",
+		MaximumSimultaneousConnections: 1,
+		DNSRefreshInterval:             -1,
+		Logger:                         slog.New(h),
+	}, nil)
+
+	shards := sc.shardSet().clients
+	if len(shards) != 3 {
+		t.Fatalf("expected every shard to be kept, got %d", len(shards))
+	}
+	for i, want := range []struct {
+		addr   string
+		weight int
+		conns  int
+	}{{"dcr-a:7937", 1, 2}, {"dcr-b:7937", 1, 1}, {"dcr-c:7937", 2, 1}} {
+		if shards[i].addr != want.addr || shards[i].weight != want.weight || len(shards[i].clients) != want.conns {
+			t.Fatalf("shard %d: expected %s with weight %d and %d conns, got %s with weight %d and %d conns",
+				i, want.addr, want.weight, want.conns, shards[i].addr, shards[i].weight, len(shards[i].clients))
+		}
+	}
+	logged := 0
+	for _, r := range h.records {
+		if r.Message == "invalid shard" {
+			logged++
+		}
+	}
+	if logged != 2 {
+		t.Fatalf("expected 2 malformed shards to be logged, got %d", logged)
+	}
+}
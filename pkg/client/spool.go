@@ -0,0 +1,684 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	base "github.com/mygaru/dcr-sdk/gen/base1"
+	"github.com/mygaru/dcr-sdk/pkg/contract"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	defaultSpoolMaxSegmentSize    = 4 * 1024 * 1024
+	defaultSpoolMaxSize           = 256 * 1024 * 1024
+	defaultSpoolMaxAge            = 24 * time.Hour
+	defaultSpoolReplayInterval    = time.Second
+	defaultSpoolMaxReplayBackoff  = time.Minute
+	defaultSpoolMaxReplayAttempts = 5
+
+	spoolSegmentExt = ".spool"
+
+	// spoolRecordHeaderSize is the size of the record header: payload length, CRC32-C and the spool timestamp.
+	spoolRecordHeaderSize = 4 + 4 + 8
+
+	// spoolMaxRecordSize protects the reader from allocating huge buffers for a corrupted length field.
+	spoolMaxRecordSize = 1024 * 1024
+)
+
+var (
+	// ErrReportSpooled is wrapped into the error returned by ShardedClient.Report when the report
+	// failed with NETWORK_ERROR and was persisted to Configuration.ReportSpool for background replay.
+	// The caller must not resubmit such a report.
+	ErrReportSpooled = errors.New("report is spooled for replay")
+
+	// ErrSpoolFull is returned by Spool.Append when the report doesn't fit into SpoolConfig.MaxSize.
+	ErrSpoolFull = errors.New("report spool is full")
+
+	// ErrSpoolClosed is returned by Spool.Append after Close has been called.
+	ErrSpoolClosed = errors.New("report spool is closed")
+)
+
+var spoolCRCTable = crc32.MakeTable(crc32.Castagnoli)
+
+// SpoolConfig configures a Spool.
+type SpoolConfig struct {
+	// Dir is the directory holding spool segments. It is created if it doesn't exist.
+	// A directory must not be shared by several open spools.
+	Dir string
+
+	// MaxSegmentSize is the size in bytes after which the spool starts a new segment file.
+	// By default 4MB.
+	MaxSegmentSize int64
+
+	// MaxSize is the total size in bytes of all segments. When it is exceeded the oldest segments are deleted.
+	// By default 256MB.
+	MaxSize int64
+
+	// MaxAge is how long a spooled report stays eligible for replay. Older reports are expired.
+	// By default 24h.
+	MaxAge time.Duration
+
+	// ReplayInterval is the initial delay between replay attempts.
+	// It is doubled after every failed attempt up to MaxReplayBackoff.
+	// By default 1s.
+	ReplayInterval time.Duration
+
+	// MaxReplayBackoff caps the delay between replay attempts.
+	// By default 1m.
+	MaxReplayBackoff time.Duration
+
+	// MaxReplayAttempts is the number of replay attempts in a row after which a report that keeps failing
+	// is moved to the end of the spool, so it doesn't hold back the reports behind it. It stays eligible
+	// for replay until MaxAge.
+	// By default 5.
+	MaxReplayAttempts int
+
+	// Sync forces fsync after every appended report.
+	Sync bool
+}
+
+// Spool is a durable write-ahead log for Report requests that failed with NETWORK_ERROR.
+//
+// Reports are appended to segmented append-only files. Each record is
+// [payload length uint32][CRC32-C uint32][spool time unix nano int64][marshaled base.ReportRequest]
+// in little-endian byte order; the checksum covers the timestamp and the payload.
+//
+// A Spool is attached to the client via Configuration.ReportSpool. The client then replays it
+// in the background with exponential backoff.
+type Spool struct {
+	cfg     SpoolConfig
+	metrics atomic.Pointer[spoolMetrics]
+	now     func() time.Time
+
+	mu        sync.Mutex
+	closed    bool
+	done      chan struct{}
+	sealed    []*spoolSegment
+	active    *spoolSegment
+	file      *os.File
+	totalSize int64
+	replaying *spoolSegment
+
+	// headSeg and headOffset locate the record at which the last replay stopped, and headAttempts
+	// is the number of replays in a row that stopped at it. They are only used by the replaying goroutine.
+	headSeg      *spoolSegment
+	headOffset   int64
+	headAttempts int
+}
+
+type spoolSegment struct {
+	seq     uint64
+	path    string
+	size    int64
+	records int
+
+	// offset is the number of bytes at the segment head that have already been replayed.
+	offset int64
+}
+
+type replayOutcome int
+
+const (
+	replayDelivered replayOutcome = iota
+	replayRetry
+	replayDiscard
+)
+
+// OpenSpool opens the spool in cfg.Dir, recovering segments left by a previous process.
+//
+// Records with a broken checksum or a truncated tail are cut off and counted as corrupted.
+func OpenSpool(cfg SpoolConfig) (*Spool, error) {
+	if cfg.Dir == "" {
+		return nil, fmt.Errorf("spool dir is required")
+	}
+	if cfg.MaxSegmentSize <= 0 {
+		cfg.MaxSegmentSize = defaultSpoolMaxSegmentSize
+	}
+	if cfg.MaxSize <= 0 {
+		cfg.MaxSize = defaultSpoolMaxSize
+	}
+	if cfg.MaxAge <= 0 {
+		cfg.MaxAge = defaultSpoolMaxAge
+	}
+	if cfg.ReplayInterval <= 0 {
+		cfg.ReplayInterval = defaultSpoolReplayInterval
+	}
+	if cfg.MaxReplayBackoff <= 0 {
+		cfg.MaxReplayBackoff = defaultSpoolMaxReplayBackoff
+	}
+	if cfg.MaxReplayBackoff < cfg.ReplayInterval {
+		cfg.MaxReplayBackoff = cfg.ReplayInterval
+	}
+	if cfg.MaxReplayAttempts <= 0 {
+		cfg.MaxReplayAttempts = defaultSpoolMaxReplayAttempts
+	}
+
+	if err := os.MkdirAll(cfg.Dir, 0o755); err != nil {
+		return nil, fmt.Errorf("create spool dir %q: %w", cfg.Dir, err)
+	}
+
+	s := &Spool{
+		cfg:  cfg,
+		now:  time.Now,
+		done: make(chan struct{}),
+	}
+	s.metrics.Store(s.newMetrics(newMetricsRegistry(MetricsConfig{})))
+
+	if err := s.recover(); err != nil {
+		return nil, err
+	}
+
+	var nextSeq uint64 = 1
+	if n := len(s.sealed); n > 0 {
+		nextSeq = s.sealed[n-1].seq + 1
+	}
+	if err := s.openActive(nextSeq); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// newMetrics creates the metrics of the spool in r.
+func (s *Spool) newMetrics(r *metricsRegistry) *spoolMetrics {
+	return newSpoolMetrics(r, s.cfg.Dir, func() float64 {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return float64(s.totalSize)
+	})
+}
+
+// attachMetrics moves the metrics of the spool to the registry of the client it is attached to,
+// carrying over the counts collected so far.
+func (s *Spool) attachMetrics(r *metricsRegistry) {
+	m := s.newMetrics(r)
+	m.add(s.metrics.Swap(m))
+}
+
+// Append persists req for later replay.
+func (s *Spool) Append(req *base.ReportRequest) error {
+	raw, err := proto.Marshal(req)
+	if err != nil {
+		return fmt.Errorf("marshal report for spool: %w", err)
+	}
+	if len(raw) > spoolMaxRecordSize {
+		return fmt.Errorf("too big report for spool: size=%d. Must not exceed %d", len(raw), spoolMaxRecordSize)
+	}
+	rec := appendSpoolRecord(make([]byte, 0, spoolRecordHeaderSize+len(raw)), raw, s.now())
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.appendLocked(rec); err != nil {
+		return err
+	}
+	s.metrics.Load().spooled.Inc()
+	return nil
+}
+
+// appendLocked writes rec to the active segment.
+func (s *Spool) appendLocked(rec []byte) error {
+	if s.closed {
+		return ErrSpoolClosed
+	}
+	if s.active.size > 0 && s.active.size+int64(len(rec)) > s.cfg.MaxSegmentSize {
+		if err := s.rotateLocked(); err != nil {
+			return err
+		}
+	}
+	if !s.ensureCapacityLocked(int64(len(rec))) {
+		s.metrics.Load().dropped.Inc()
+		return ErrSpoolFull
+	}
+
+	if _, err := s.file.Write(rec); err != nil {
+		return fmt.Errorf("write spool segment %q: %w", s.active.path, err)
+	}
+	if s.cfg.Sync {
+		if err := s.file.Sync(); err != nil {
+			return fmt.Errorf("sync spool segment %q: %w", s.active.path, err)
+		}
+	}
+	s.active.size += int64(len(rec))
+	s.active.records++
+	s.totalSize += int64(len(rec))
+	return nil
+}
+
+// Size returns the total size in bytes of all spool segments.
+func (s *Spool) Size() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.totalSize
+}
+
+// Close closes the active segment and stops background replay. Spooled reports stay on disk.
+func (s *Spool) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	close(s.done)
+	return s.file.Close()
+}
+
+// replay sends spooled reports oldest first until the spool is empty or send asks to retry later.
+//
+// It returns false if replay stopped because of replayRetry.
+func (s *Spool) replay(send func(req *base.ReportRequest) replayOutcome) bool {
+	for {
+		seg := s.nextReplaySegment()
+		if seg == nil {
+			return true
+		}
+
+		offset, done := s.replaySegment(seg, send)
+
+		s.mu.Lock()
+		s.replaying = nil
+		seg.offset = offset
+		if done {
+			s.removeSealedLocked(seg)
+		}
+		s.mu.Unlock()
+
+		if !done {
+			return false
+		}
+	}
+}
+
+// nextReplaySegment returns the oldest sealed segment, sealing the active one if it is the only one with data.
+func (s *Spool) nextReplaySegment() *spoolSegment {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed || s.replaying != nil {
+		return nil
+	}
+	if len(s.sealed) == 0 {
+		if s.active.records == 0 {
+			return nil
+		}
+		if err := s.rotateLocked(); err != nil {
+			return nil
+		}
+	}
+	s.replaying = s.sealed[0]
+	return s.replaying
+}
+
+// replaySegment sends the records of seg starting at seg.offset.
+//
+// It returns the offset of the first record that is not processed yet and done = true
+// when the whole segment has been processed.
+func (s *Spool) replaySegment(seg *spoolSegment, send func(req *base.ReportRequest) replayOutcome) (offset int64, done bool) {
+	offset = seg.offset
+
+	f, err := os.Open(seg.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return offset, true
+		}
+		return offset, false
+	}
+	defer f.Close()
+
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return offset, false
+	}
+	br := bufio.NewReader(f)
+
+	var payload []byte
+	for {
+		var n int
+		var spooledAt time.Time
+		payload, spooledAt, n, err = readSpoolRecord(br, payload)
+		if err == io.EOF {
+			return offset, true
+		}
+		if err != nil {
+			s.metrics.Load().corrupted.Inc()
+			next, ok := findSpoolRecord(f, offset+1)
+			if !ok {
+				// Nothing valid follows, e.g. a write torn by a crash.
+				return offset, true
+			}
+			if _, err := f.Seek(next, io.SeekStart); err != nil {
+				return offset, false
+			}
+			br.Reset(f)
+			offset = next
+			continue
+		}
+
+		if s.now().Sub(spooledAt) > s.cfg.MaxAge {
+			s.metrics.Load().expired.Inc()
+			offset += int64(n)
+			continue
+		}
+
+		req := &base.ReportRequest{}
+		if err := proto.Unmarshal(payload, req); err != nil {
+			s.metrics.Load().corrupted.Inc()
+			offset += int64(n)
+			continue
+		}
+
+		switch send(req) {
+		case replayRetry:
+			if !s.deferHead(seg, offset, payload, spooledAt) {
+				return offset, false
+			}
+			s.metrics.Load().deferred.Inc()
+		case replayDiscard:
+			s.metrics.Load().discarded.Inc()
+		default:
+			s.metrics.Load().replayed.Inc()
+		}
+		offset += int64(n)
+	}
+}
+
+// deferHead counts a failed replay of the record at offset in seg. Once MaxReplayAttempts replays in a row
+// have stopped at the record, it is appended to the end of the spool with its original spool time and
+// deferHead returns true, so replay continues with the next record.
+func (s *Spool) deferHead(seg *spoolSegment, offset int64, payload []byte, spooledAt time.Time) bool {
+	if s.headSeg != seg || s.headOffset != offset {
+		s.headSeg, s.headOffset, s.headAttempts = seg, offset, 0
+	}
+	s.headAttempts++
+	if s.headAttempts < s.cfg.MaxReplayAttempts {
+		return false
+	}
+
+	rec := appendSpoolRecord(make([]byte, 0, spoolRecordHeaderSize+len(payload)), payload, spooledAt)
+	s.mu.Lock()
+	err := s.appendLocked(rec)
+	s.mu.Unlock()
+	if err != nil {
+		return false
+	}
+	s.headSeg, s.headAttempts = nil, 0
+	return true
+}
+
+// recover loads the segments found in the spool dir and cuts off corrupted tails.
+func (s *Spool) recover() error {
+	entries, err := os.ReadDir(s.cfg.Dir)
+	if err != nil {
+		return fmt.Errorf("read spool dir %q: %w", s.cfg.Dir, err)
+	}
+
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, spoolSegmentExt) {
+			continue
+		}
+		seq, err := strconv.ParseUint(strings.TrimSuffix(name, spoolSegmentExt), 10, 64)
+		if err != nil {
+			continue
+		}
+
+		seg := &spoolSegment{
+			seq:  seq,
+			path: filepath.Join(s.cfg.Dir, name),
+		}
+		if err := s.scanSegment(seg); err != nil {
+			return err
+		}
+		if seg.records == 0 {
+			_ = os.Remove(seg.path)
+			continue
+		}
+		s.sealed = append(s.sealed, seg)
+		s.totalSize += seg.size
+	}
+
+	sort.Slice(s.sealed, func(i, j int) bool {
+		return s.sealed[i].seq < s.sealed[j].seq
+	})
+	return nil
+}
+
+// scanSegment counts valid records in seg, skipping corrupted ones, and truncates the file after the last one.
+func (s *Spool) scanSegment(seg *spoolSegment) error {
+	f, err := os.OpenFile(seg.path, os.O_RDWR, 0)
+	if err != nil {
+		return fmt.Errorf("open spool segment %q: %w", seg.path, err)
+	}
+	defer f.Close()
+
+	br := bufio.NewReader(f)
+	var payload []byte
+	for {
+		var n int
+		payload, _, n, err = readSpoolRecord(br, payload)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			next, ok := findSpoolRecord(f, seg.size+1)
+			if !ok {
+				s.metrics.Load().corrupted.Inc()
+				if err := f.Truncate(seg.size); err != nil {
+					return fmt.Errorf("truncate corrupted spool segment %q: %w", seg.path, err)
+				}
+				return nil
+			}
+			if _, err := f.Seek(next, io.SeekStart); err != nil {
+				return fmt.Errorf("seek spool segment %q: %w", seg.path, err)
+			}
+			br.Reset(f)
+			// The corrupted record stays in the file and is counted when the segment is replayed.
+			seg.size = next
+			continue
+		}
+		seg.size += int64(n)
+		seg.records++
+	}
+}
+
+func (s *Spool) openActive(seq uint64) error {
+	path := filepath.Join(s.cfg.Dir, fmt.Sprintf("%020d%s", seq, spoolSegmentExt))
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("create spool segment %q: %w", path, err)
+	}
+	s.file = f
+	s.active = &spoolSegment{
+		seq:  seq,
+		path: path,
+	}
+	return nil
+}
+
+// rotateLocked seals the active segment and starts a new one.
+func (s *Spool) rotateLocked() error {
+	if err := s.file.Close(); err != nil {
+		return fmt.Errorf("close spool segment %q: %w", s.active.path, err)
+	}
+	sealed := s.active
+	if err := s.openActive(sealed.seq + 1); err != nil {
+		return err
+	}
+	if sealed.records > 0 {
+		s.sealed = append(s.sealed, sealed)
+	} else {
+		_ = os.Remove(sealed.path)
+	}
+	return nil
+}
+
+// ensureCapacityLocked deletes the oldest sealed segments until n more bytes fit into MaxSize.
+// The segment being replayed is never deleted.
+func (s *Spool) ensureCapacityLocked(n int64) bool {
+	for s.totalSize+n > s.cfg.MaxSize {
+		var victim *spoolSegment
+		for _, seg := range s.sealed {
+			if seg != s.replaying {
+				victim = seg
+				break
+			}
+		}
+		if victim == nil {
+			return false
+		}
+		s.metrics.Load().dropped.Add(victim.records)
+		s.removeSealedLocked(victim)
+	}
+	return true
+}
+
+func (s *Spool) removeSealedLocked(seg *spoolSegment) {
+	for i, candidate := range s.sealed {
+		if candidate == seg {
+			s.sealed = append(s.sealed[:i], s.sealed[i+1:]...)
+			s.totalSize -= seg.size
+			_ = os.Remove(seg.path)
+			return
+		}
+	}
+}
+
+func appendSpoolRecord(dst, payload []byte, spooledAt time.Time) []byte {
+	var ts [8]byte
+	binary.LittleEndian.PutUint64(ts[:], uint64(spooledAt.UnixNano()))
+
+	crc := crc32.Update(0, spoolCRCTable, ts[:])
+	crc = crc32.Update(crc, spoolCRCTable, payload)
+
+	dst = binary.LittleEndian.AppendUint32(dst, uint32(len(payload)))
+	dst = binary.LittleEndian.AppendUint32(dst, crc)
+	dst = append(dst, ts[:]...)
+	return append(dst, payload...)
+}
+
+// readSpoolRecord reads the next record from br into payload.
+//
+// It returns io.EOF at a clean end of segment and a non-nil error for truncated or corrupted records.
+func readSpoolRecord(br *bufio.Reader, payload []byte) ([]byte, time.Time, int, error) {
+	var header [spoolRecordHeaderSize]byte
+	if _, err := io.ReadFull(br, header[:]); err != nil {
+		if err == io.EOF {
+			return payload, time.Time{}, 0, io.EOF
+		}
+		return payload, time.Time{}, 0, fmt.Errorf("cannot read spool record header: %w", err)
+	}
+
+	size := int(binary.LittleEndian.Uint32(header[0:4]))
+	if size > spoolMaxRecordSize {
+		return payload, time.Time{}, 0, fmt.Errorf("too big spool record size=%d. Must not exceed %d", size, spoolMaxRecordSize)
+	}
+	if cap(payload) < size {
+		payload = make([]byte, size)
+	}
+	payload = payload[:size]
+	if _, err := io.ReadFull(br, payload); err != nil {
+		return payload, time.Time{}, 0, fmt.Errorf("cannot read spool record with size %d: %w", size, err)
+	}
+
+	crc := crc32.Update(0, spoolCRCTable, header[8:16])
+	crc = crc32.Update(crc, spoolCRCTable, payload)
+	if crc != binary.LittleEndian.Uint32(header[4:8]) {
+		return payload, time.Time{}, 0, fmt.Errorf("spool record checksum mismatch")
+	}
+
+	spooledAt := time.Unix(0, int64(binary.LittleEndian.Uint64(header[8:16])))
+	return payload, spooledAt, spoolRecordHeaderSize + size, nil
+}
+
+// findSpoolRecord returns the offset of the first valid record in f at or after from, so the records
+// following a corrupted one are not lost. It returns false if there is none.
+func findSpoolRecord(f *os.File, from int64) (int64, bool) {
+	data, err := io.ReadAll(io.NewSectionReader(f, from, math.MaxInt64-from))
+	if err != nil {
+		return 0, false
+	}
+	for i := 0; i+spoolRecordHeaderSize <= len(data); i++ {
+		if isSpoolRecord(data[i:]) {
+			return from + int64(i), true
+		}
+	}
+	return 0, false
+}
+
+// isSpoolRecord reports whether b starts with a complete record with a valid checksum.
+func isSpoolRecord(b []byte) bool {
+	size := int(binary.LittleEndian.Uint32(b[0:4]))
+	if size > spoolMaxRecordSize || len(b) < spoolRecordHeaderSize+size {
+		return false
+	}
+	crc := crc32.Update(0, spoolCRCTable, b[8:spoolRecordHeaderSize])
+	crc = crc32.Update(crc, spoolCRCTable, b[spoolRecordHeaderSize:spoolRecordHeaderSize+size])
+	return crc == binary.LittleEndian.Uint32(b[4:8])
+}
+
+// replaySpool replays spool in the background until the spool or the client is closed.
+func (sc *ShardedClient) replaySpool(spool *Spool) {
+	backoff := spool.cfg.ReplayInterval
+	timer := time.NewTimer(backoff)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-timer.C:
+		case <-spool.done:
+			return
+		case <-sc.done:
+			return
+		}
+
+		if spool.replay(sc.replayReport) {
+			backoff = spool.cfg.ReplayInterval
+		} else {
+			backoff *= 2
+			if backoff > spool.cfg.MaxReplayBackoff {
+				backoff = spool.cfg.MaxReplayBackoff
+			}
+		}
+		timer.Reset(backoff)
+	}
+}
+
+// replayReport resends a spooled report and decides whether it must stay in the spool.
+func (sc *ShardedClient) replayReport(req *base.ReportRequest) replayOutcome {
+	if !sc.acquire() {
+		return replayRetry
+	}
+	defer sc.release()
+
+	statusCode, err := sc.sendReport(context.Background(), req)
+	if err == nil {
+		return replayDelivered
+	}
+	switch {
+	case errors.Is(err, ErrMissingTrackingID), errors.Is(err, contract.ErrInvalidTrackingID):
+		// No server will ever accept this report.
+		return replayDiscard
+	case statusCode == base.RPCServerResponseCode_INVALID_REQUEST, statusCode == base.RPCServerResponseCode_OUTDATED:
+		// The server will never accept this report.
+		return replayDiscard
+	default:
+		// NETWORK_ERROR, unknown shard for the tracking id, temporarily unavailable server and so on.
+		// A report that keeps failing is moved to the end of the spool after MaxReplayAttempts.
+		return replayRetry
+	}
+}
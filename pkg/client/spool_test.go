@@ -0,0 +1,425 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	base "github.com/mygaru/dcr-sdk/gen/base1"
+	"github.com/mygaru/dcr-sdk/pkg/contract"
+)
+
+func TestSpoolSurvivesReopenAndReplaysInOrder(t *testing.T) {
+	dir := t.TempDir()
+	spool, err := OpenSpool(SpoolConfig{Dir: dir, MaxSegmentSize: 64})
+	if err != nil {
+		t.Fatalf("open spool: %v", err)
+	}
+	for i := 0; i < 10; i++ {
+		if err := spool.Append(testSpoolReport(i)); err != nil {
+			t.Fatalf("append report %d: %v", i, err)
+		}
+	}
+	if err := spool.Close(); err != nil {
+		t.Fatalf("close spool: %v", err)
+	}
+
+	spool, err = OpenSpool(SpoolConfig{Dir: dir, MaxSegmentSize: 64})
+	if err != nil {
+		t.Fatalf("reopen spool: %v", err)
+	}
+	defer spool.Close()
+
+	var got []uint32
+	if !spool.replay(func(req *base.ReportRequest) replayOutcome {
+		got = append(got, req.Rules[0].EventsCount)
+		return replayDelivered
+	}) {
+		t.Fatalf("expected replay to complete")
+	}
+	if len(got) != 10 {
+		t.Fatalf("expected 10 replayed reports, got %d", len(got))
+	}
+	for i, n := range got {
+		if n != uint32(i) {
+			t.Fatalf("report[%d]: expected events count %d, got %d", i, i, n)
+		}
+	}
+	if size := spool.Size(); size != 0 {
+		t.Fatalf("expected empty spool after replay, got %d bytes", size)
+	}
+}
+
+func TestSpoolReplayResumesAfterRetry(t *testing.T) {
+	spool, err := OpenSpool(SpoolConfig{Dir: t.TempDir()})
+	if err != nil {
+		t.Fatalf("open spool: %v", err)
+	}
+	defer spool.Close()
+
+	for i := 0; i < 3; i++ {
+		if err := spool.Append(testSpoolReport(i)); err != nil {
+			t.Fatalf("append report %d: %v", i, err)
+		}
+	}
+
+	var got []uint32
+	calls := 0
+	send := func(req *base.ReportRequest) replayOutcome {
+		calls++
+		if calls == 2 {
+			return replayRetry
+		}
+		got = append(got, req.Rules[0].EventsCount)
+		return replayDelivered
+	}
+	if spool.replay(send) {
+		t.Fatalf("expected replay to stop on retry")
+	}
+	if !spool.replay(send) {
+		t.Fatalf("expected second replay to complete")
+	}
+	if len(got) != 3 || got[0] != 0 || got[1] != 1 || got[2] != 2 {
+		t.Fatalf("expected reports 0,1,2 to be replayed once each, got %v", got)
+	}
+}
+
+func TestSpoolDefersReportThatKeepsFailing(t *testing.T) {
+	spool, err := OpenSpool(SpoolConfig{Dir: t.TempDir(), MaxReplayAttempts: 2})
+	if err != nil {
+		t.Fatalf("open spool: %v", err)
+	}
+	defer spool.Close()
+
+	for i := 0; i < 3; i++ {
+		if err := spool.Append(testSpoolReport(i)); err != nil {
+			t.Fatalf("append report %d: %v", i, err)
+		}
+	}
+
+	var got []uint32
+	send := func(req *base.ReportRequest) replayOutcome {
+		if req.Rules[0].EventsCount == 0 {
+			return replayRetry
+		}
+		got = append(got, req.Rules[0].EventsCount)
+		return replayDelivered
+	}
+	deferred := spool.metrics.Load().deferred.Get()
+	if spool.replay(send) || len(got) != 0 {
+		t.Fatalf("expected the first replay to stop at the failing report, got %v", got)
+	}
+	if spool.replay(send) {
+		t.Fatalf("expected the deferred report to stay in the spool")
+	}
+	if len(got) != 2 || got[0] != 1 || got[1] != 2 {
+		t.Fatalf("expected reports 1,2 to be replayed past the failing one, got %v", got)
+	}
+	if got := spool.metrics.Load().deferred.Get(); got != deferred+1 {
+		t.Fatalf("expected deferred counter to increment to %d, got %d", deferred+1, got)
+	}
+	if spool.Size() == 0 {
+		t.Fatalf("expected the deferred report to be kept")
+	}
+}
+
+func TestSpoolExpiresOldReports(t *testing.T) {
+	spool, err := OpenSpool(SpoolConfig{Dir: t.TempDir(), MaxAge: time.Minute})
+	if err != nil {
+		t.Fatalf("open spool: %v", err)
+	}
+	defer spool.Close()
+
+	now := time.Now()
+	spool.now = func() time.Time { return now }
+	if err := spool.Append(testSpoolReport(1)); err != nil {
+		t.Fatalf("append report: %v", err)
+	}
+
+	expired := spool.metrics.Load().expired.Get()
+	now = now.Add(2 * time.Minute)
+	spool.replay(func(req *base.ReportRequest) replayOutcome {
+		t.Fatalf("expected expired report not to be replayed")
+		return replayDelivered
+	})
+	if got := spool.metrics.Load().expired.Get(); got != expired+1 {
+		t.Fatalf("expected expired counter to increment to %d, got %d", expired+1, got)
+	}
+}
+
+func TestSpoolTruncatesCorruptedTail(t *testing.T) {
+	dir := t.TempDir()
+	spool, err := OpenSpool(SpoolConfig{Dir: dir})
+	if err != nil {
+		t.Fatalf("open spool: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := spool.Append(testSpoolReport(i)); err != nil {
+			t.Fatalf("append report %d: %v", i, err)
+		}
+	}
+	path := spool.active.path
+	if err := spool.Close(); err != nil {
+		t.Fatalf("close spool: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read segment: %v", err)
+	}
+	data[len(data)-1] ^= 0xff
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatalf("write segment: %v", err)
+	}
+
+	spool, err = OpenSpool(SpoolConfig{Dir: dir})
+	if err != nil {
+		t.Fatalf("reopen spool: %v", err)
+	}
+	defer spool.Close()
+
+	replayed := 0
+	spool.replay(func(req *base.ReportRequest) replayOutcome {
+		replayed++
+		return replayDelivered
+	})
+	if replayed != 1 {
+		t.Fatalf("expected only the intact report to be replayed, got %d", replayed)
+	}
+}
+
+func TestSpoolSkipsRecordCorruptedInTheMiddle(t *testing.T) {
+	for _, reopen := range []bool{false, true} {
+		dir := t.TempDir()
+		spool, err := OpenSpool(SpoolConfig{Dir: dir})
+		if err != nil {
+			t.Fatalf("open spool: %v", err)
+		}
+		for i := 1; i <= 3; i++ {
+			if err := spool.Append(testSpoolReport(i)); err != nil {
+				t.Fatalf("append report %d: %v", i, err)
+			}
+		}
+
+		// The reports have the same size, so the second one is in the middle third of the segment.
+		path := spool.active.path
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("read segment: %v", err)
+		}
+		data[len(data)/3+len(data)/6] ^= 0xff
+		if err := os.WriteFile(path, data, 0o644); err != nil {
+			t.Fatalf("write segment: %v", err)
+		}
+
+		if reopen {
+			if err := spool.Close(); err != nil {
+				t.Fatalf("close spool: %v", err)
+			}
+			if spool, err = OpenSpool(SpoolConfig{Dir: dir}); err != nil {
+				t.Fatalf("reopen spool: %v", err)
+			}
+		}
+
+		var got []uint32
+		if !spool.replay(func(req *base.ReportRequest) replayOutcome {
+			got = append(got, req.Rules[0].EventsCount)
+			return replayDelivered
+		}) {
+			t.Fatalf("reopen=%v: expected replay to complete", reopen)
+		}
+		if len(got) != 2 || got[0] != 1 || got[1] != 3 {
+			t.Fatalf("reopen=%v: expected reports 1,3 to be replayed around the corrupted one, got %v", reopen, got)
+		}
+		if got := spool.metrics.Load().corrupted.Get(); got != 1 {
+			t.Fatalf("reopen=%v: expected a corrupted record to be counted, got %d", reopen, got)
+		}
+		_ = spool.Close()
+	}
+}
+
+func TestSpoolDropsOldestSegmentsOverMaxSize(t *testing.T) {
+	spool, err := OpenSpool(SpoolConfig{Dir: t.TempDir(), MaxSegmentSize: 32, MaxSize: 128})
+	if err != nil {
+		t.Fatalf("open spool: %v", err)
+	}
+	defer spool.Close()
+
+	for i := 0; i < 20; i++ {
+		if err := spool.Append(testSpoolReport(i)); err != nil {
+			t.Fatalf("append report %d: %v", i, err)
+		}
+	}
+	if size := spool.Size(); size > 128 {
+		t.Fatalf("expected spool size to stay within 128 bytes, got %d", size)
+	}
+
+	var first uint32
+	spool.replay(func(req *base.ReportRequest) replayOutcome {
+		if first == 0 {
+			first = req.Rules[0].EventsCount
+		}
+		return replayDelivered
+	})
+	if first == 0 {
+		t.Fatalf("expected the oldest reports to be dropped")
+	}
+}
+
+func TestReportSpoolsNetworkErrorsAndReplaysThem(t *testing.T) {
+	var (
+		down      atomic.Bool
+		delivered atomic.Int64
+	)
+	down.Store(true)
+	addr := startTestServer(t, func(ctx *contract.RequestCtx) {
+		if down.Load() {
+			time.Sleep(100 * time.Millisecond)
+		} else if ctx.Request.GetName() == contract.Report {
+			delivered.Add(1)
+		}
+		ctx.Response.SetStatusCode(base.RPCServerResponseCode_OK)
+	})
+
+	spool, err := OpenSpool(SpoolConfig{
+		Dir:              filepath.Join(t.TempDir(), "spool"),
+		ReplayInterval:   10 * time.Millisecond,
+		MaxReplayBackoff: 20 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("open spool: %v", err)
+	}
+	defer spool.Close()
+
+	sc := NewClient(&Configuration{
+		Addrs:                          addr,
+		DisableAuth:                    true,
+		MaxRequestDuration:             20 * time.Millisecond,
+		MaximumSimultaneousConnections: 1,
+		DNSRefreshInterval:             -1,
+		ReportSpool:                    spool,
+	}, nil)
+	setTestServerID(sc.shardSet().clients[0], testReporterServerID)
+
+	statusCode, err := sc.ReportContext(context.Background(), testReportRequest())
+	if statusCode != base.RPCServerResponseCode_NETWORK_ERROR {
+		t.Fatalf("expected NETWORK_ERROR status, got %s", statusCode)
+	}
+	if !errors.Is(err, ErrReportSpooled) {
+		t.Fatalf("expected ErrReportSpooled, got %v", err)
+	}
+
+	down.Store(false)
+	deadline := time.Now().Add(2 * time.Second)
+	for delivered.Load() == 0 || spool.Size() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected spooled report to be replayed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSpoolReplaysPastUnroutableReports(t *testing.T) {
+	var delivered atomic.Int64
+	addr := startTestServer(t, func(ctx *contract.RequestCtx) {
+		if ctx.Request.GetName() == contract.Report {
+			delivered.Add(1)
+		}
+		ctx.Response.SetStatusCode(base.RPCServerResponseCode_OK)
+	})
+
+	spool, err := OpenSpool(SpoolConfig{
+		Dir:               filepath.Join(t.TempDir(), "spool"),
+		ReplayInterval:    10 * time.Millisecond,
+		MaxReplayBackoff:  20 * time.Millisecond,
+		MaxReplayAttempts: 2,
+	})
+	if err != nil {
+		t.Fatalf("open spool: %v", err)
+	}
+	defer spool.Close()
+
+	// An invalid tracking id and one issued by a server the client doesn't know precede the routable reports.
+	invalid := testSpoolReport(0)
+	invalid.TrackingId = []byte("not a tracking id")
+	unknown := testSpoolReport(0)
+	unknown.TrackingId = []byte("0500000000000001")
+	for _, req := range []*base.ReportRequest{invalid, unknown, testSpoolReport(1), testSpoolReport(2)} {
+		if err := spool.Append(req); err != nil {
+			t.Fatalf("append report: %v", err)
+		}
+	}
+	discarded := spool.metrics.Load().discarded.Get()
+
+	sc := NewClient(&Configuration{
+		Addrs:                          addr,
+		DisableAuth:                    true,
+		MaximumSimultaneousConnections: 1,
+		DNSRefreshInterval:             -1,
+		ReportSpool:                    spool,
+	}, nil)
+	defer sc.Close()
+	setTestServerID(sc.shardSet().clients[0], testReporterServerID)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for delivered.Load() < 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the routable reports to be replayed, got %d", delivered.Load())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := spool.metrics.Load().discarded.Get(); got != discarded+1 {
+		t.Fatalf("expected the report with the invalid tracking id to be discarded, got %d discarded", got-discarded)
+	}
+}
+
+func TestSpoolMetricsMoveToTheClient(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "spool")
+	spool, err := OpenSpool(SpoolConfig{Dir: dir, ReplayInterval: time.Hour})
+	if err != nil {
+		t.Fatalf("open spool: %v", err)
+	}
+	defer spool.Close()
+	if err := spool.Append(testSpoolReport(1)); err != nil {
+		t.Fatalf("append report: %v", err)
+	}
+
+	sc := NewClient(&Configuration{
+		Addrs:              "127.0.0.1:1",
+		DisableAuth:        true,
+		DNSRefreshInterval: -1,
+		ReportSpool:        spool,
+		Metrics: MetricsConfig{
+			Labels:                    map[string]string{"client": "spool-metrics"},
+			DisableGlobalRegistration: true,
+		},
+	}, nil)
+	defer sc.Close()
+	if err := spool.Append(testSpoolReport(2)); err != nil {
+		t.Fatalf("append report: %v", err)
+	}
+
+	var out bytes.Buffer
+	sc.WritePrometheus(&out)
+	want := fmt.Sprintf(`dcrRPCClientSpool{client="spool-metrics",dir=%q,event="spooled"} 2`, dir)
+	if !strings.Contains(out.String(), want) {
+		t.Fatalf("expected %s in\n%s", want, out.String())
+	}
+}
+
+func testSpoolReport(n int) *base.ReportRequest {
+	return &base.ReportRequest{
+		TrackingId: []byte("0400000000000001"),
+		Event:      base.EventType_EVENT_TYPE_IMPRESSION,
+		Rules: []*base.ReportRequest_Rule{
+			{EventsCount: uint32(n)},
+		},
+	}
+}
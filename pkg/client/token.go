@@ -0,0 +1,85 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// TokenSource provides the JWT token sent in the contract.Auth exchange.
+//
+// Token is called for every contract.Auth exchange: when a connection is dialed and when a request
+// is rejected with UNAUTHORIZED, which means the token has expired or was revoked since the connection
+// was authenticated. A TokenSource that caches the token should make sure it is still valid.
+// Token is called concurrently, and the returned slice must not be modified afterwards.
+type TokenSource interface {
+	Token(ctx context.Context) ([]byte, error)
+}
+
+// TokenSourceFunc is an adapter to use an ordinary function as a TokenSource.
+type TokenSourceFunc func(ctx context.Context) ([]byte, error)
+
+// Token returns f(ctx).
+func (f TokenSourceFunc) Token(ctx context.Context) ([]byte, error) {
+	return f(ctx)
+}
+
+// StaticTokenSource returns a TokenSource that always returns token. It is used for Configuration.JwtToken.
+func StaticTokenSource(token []byte) TokenSource {
+	return TokenSourceFunc(func(context.Context) ([]byte, error) {
+		return token, nil
+	})
+}
+
+// tokenSource returns cfg.TokenSource, or a StaticTokenSource for cfg.JwtToken if it is nil.
+func (cfg *Configuration) tokenSource() TokenSource {
+	if cfg.TokenSource != nil {
+		return cfg.TokenSource
+	}
+	return StaticTokenSource(cfg.JwtToken)
+}
+
+// FileTokenSource is a TokenSource that reads the token from a file and reads it again whenever
+// the file is changed, so a token rotated by rewriting the file is picked up without a restart.
+// Leading and trailing whitespace is trimmed.
+type FileTokenSource struct {
+	path string
+
+	mu      sync.Mutex
+	token   []byte
+	modTime time.Time
+	size    int64
+}
+
+// NewFileTokenSource returns a FileTokenSource for the file at path. The file is read on the first Token call.
+func NewFileTokenSource(path string) *FileTokenSource {
+	return &FileTokenSource{path: path}
+}
+
+// Token returns the token in the file, reading the file again if its modification time or size has changed.
+func (s *FileTokenSource) Token(context.Context) ([]byte, error) {
+	fi, err := os.Stat(s.path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot stat token file: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.token != nil && fi.ModTime().Equal(s.modTime) && fi.Size() == s.size {
+		return s.token, nil
+	}
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read token file: %w", err)
+	}
+	token := bytes.TrimSpace(data)
+	if len(token) == 0 {
+		return nil, fmt.Errorf("token file %q is empty", s.path)
+	}
+	s.token, s.modTime, s.size = token, fi.ModTime(), fi.Size()
+	return s.token, nil
+}
@@ -0,0 +1,90 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/uuid"
+	base "github.com/mygaru/dcr-sdk/gen/base1"
+	"github.com/mygaru/dcr-sdk/internal/testcloud"
+)
+
+func TestRotatedTokenIsUsedToReauthenticate(t *testing.T) {
+	server, err := testcloud.Start(testcloud.Config{ServerID: testReporterServerID})
+	if err != nil {
+		t.Fatalf("start test-cloud: %v", err)
+	}
+	t.Cleanup(func() { _ = server.Close() })
+
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	token1 := uuid.New()
+	writeTestToken(t, tokenFile, token1.String()+"\n")
+
+	sc := newTestShardedClient(Configuration{Addrs: server.Addr(), TokenSource: NewFileTokenSource(tokenFile)})
+	defer sc.Close()
+	cl := sc.shardSet().clients[0].clients[0]
+
+	if _, _, err := sc.Target(testTargetRequest()); err != nil {
+		t.Fatalf("target: %v", err)
+	}
+	if cl.GetUUID() != token1 || cl.Reconnects() != 1 {
+		t.Fatalf("expected a single connection authenticated with the first token, got %s after %d dials", cl.GetUUID(), cl.Reconnects())
+	}
+
+	// The token is rotated: the file is rewritten and the old token is revoked. The server doesn't accept
+	// another contract.Auth over the live connection, so the client has to redial to reauthenticate.
+	token2 := uuid.New()
+	writeTestToken(t, tokenFile, token2.String()+"\n")
+	if err := server.RevokeToken(token1.String()); err != nil {
+		t.Fatalf("revoke token: %v", err)
+	}
+	if _, _, err := sc.Target(testTargetRequest()); err != nil {
+		t.Fatalf("expected the target to succeed after reauthentication, got %v", err)
+	}
+	if cl.GetUUID() != token2 || cl.Reconnects() != 2 {
+		t.Fatalf("expected a new connection authenticated with the rotated token, got %s after %d dials", cl.GetUUID(), cl.Reconnects())
+	}
+
+	// The token is revoked, but the file isn't updated: the error is surfaced after a single reauthentication.
+	if err := server.RevokeToken(token2.String()); err != nil {
+		t.Fatalf("revoke token: %v", err)
+	}
+	_, statusCode, err := sc.Target(testTargetRequest())
+	if statusCode != base.RPCServerResponseCode_UNAUTHORIZED || !errors.Is(err, ErrUnauthorized) {
+		t.Fatalf("expected UNAUTHORIZED, got %s, %v", statusCode, err)
+	}
+	if got := cl.Reconnects(); got != 3 {
+		t.Fatalf("expected a single reauthentication over a new connection, got %d dials", got)
+	}
+}
+
+func TestFileTokenSource(t *testing.T) {
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	tokens := NewFileTokenSource(tokenFile)
+	if _, err := tokens.Token(context.Background()); err == nil {
+		t.Fatalf("expected a missing file to be reported")
+	}
+
+	writeTestToken(t, tokenFile, " token-1\n")
+	if token, err := tokens.Token(context.Background()); err != nil || string(token) != "token-1" {
+		t.Fatalf("expected token-1, got %q, %v", token, err)
+	}
+	writeTestToken(t, tokenFile, "token-two\n")
+	if token, err := tokens.Token(context.Background()); err != nil || string(token) != "token-two" {
+		t.Fatalf("expected the rewritten token, got %q, %v", token, err)
+	}
+	writeTestToken(t, tokenFile, "\n")
+	if _, err := tokens.Token(context.Background()); err == nil {
+		t.Fatalf("expected an empty file to be reported")
+	}
+}
+
+func writeTestToken(t *testing.T, path, token string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(token), 0o600); err != nil {
+		t.Fatalf("write token: %v", err)
+	}
+}
@@ -0,0 +1,185 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"sync"
+	"sync/atomic"
+
+	base "github.com/mygaru/dcr-sdk/gen/base1"
+	"github.com/mygaru/dcr-sdk/pkg/contract"
+)
+
+// ClientTrace is a set of hooks run at the stages of a single request, like net/http/httptrace.ClientTrace.
+// Attach it to a call with WithClientTrace. Any hook may be nil.
+//
+// Every attempt of a call runs the hooks: retries and hedged requests call them again, possibly concurrently.
+// Connections are dialed by a background worker of each connection, so the DNSDone, DialStart, DialDone and
+// TLSHandshakeDone hooks are run for every traced request waiting on the connection while it is dialed.
+type ClientTrace struct {
+	// GotConn is called when a connection is picked for the request, before it is authenticated.
+	GotConn func(GotConnInfo)
+
+	// DNSDone is called when a dial picks one of the addresses resolved for the shard host.
+	// Hosts are resolved in the background, every DNSRefreshInterval, so no time is spent between DialStart and DNSDone.
+	DNSDone func(DNSDoneInfo)
+
+	// DialStart is called when a new connection to addr is being dialed.
+	DialStart func(addr string)
+
+	// DialDone is called when the dial of addr completes with err.
+	DialDone func(addr string, err error)
+
+	// TLSHandshakeDone is called after the server certificate is verified, with the error of
+	// tls.Config.VerifyConnection if any. Handshakes that fail earlier are not reported.
+	TLSHandshakeDone func(tls.ConnectionState, error)
+
+	// AuthStart is called when the request has to wait for the connection handshake: the contract.Hello
+	// exchange followed by contract.Auth unless DisableAuth is set.
+	AuthStart func()
+
+	// AuthDone is called when the connection handshake completes or the request gives up waiting for it.
+	AuthDone func(serverID uint16, err error)
+
+	// WroteRequest is called when the request is written to the connection buffer.
+	// The time since GotConn or AuthDone is spent queueing behind other requests of the connection.
+	// It may be called after the call has returned if the call gave up waiting for the response.
+	WroteRequest func(WroteRequestInfo)
+
+	// GotResponse is called when the request completes, successfully or not.
+	GotResponse func(GotResponseInfo)
+}
+
+// GotConnInfo is the argument of ClientTrace.GotConn.
+type GotConnInfo struct {
+	// Addr is the address of the shard the connection belongs to.
+	Addr string
+
+	// RemoteAddr is the resolved address the connection was last dialed to. It is empty before the first dial.
+	RemoteAddr string
+
+	// ServerID is the ID of the server behind the connection, or zero if it is not known yet.
+	ServerID uint16
+
+	// Reused reports whether the connection has been dialed before.
+	Reused bool
+
+	// PendingRequests is the number of requests already in flight over the connection.
+	PendingRequests int
+}
+
+// DNSDoneInfo is the argument of ClientTrace.DNSDone.
+type DNSDoneInfo struct {
+	// Host is the host of the shard address.
+	Host string
+
+	// Addrs are the addresses currently resolved for Host.
+	Addrs []string
+}
+
+// WroteRequestInfo is the argument of ClientTrace.WroteRequest.
+type WroteRequestInfo struct {
+	Method contract.RPCRegister
+	Err    error
+}
+
+// GotResponseInfo is the argument of ClientTrace.GotResponse.
+type GotResponseInfo struct {
+	Method     contract.RPCRegister
+	ServerID   uint16
+	StatusCode base.RPCServerResponseCode
+	Err        error
+}
+
+type clientTraceKey struct{}
+
+// WithClientTrace returns a copy of ctx that runs trace for every request issued with it.
+// It replaces a trace already attached to ctx.
+func WithClientTrace(ctx context.Context, trace *ClientTrace) context.Context {
+	return context.WithValue(ctx, clientTraceKey{}, trace)
+}
+
+// ContextClientTrace returns the ClientTrace attached to ctx, or nil.
+func ContextClientTrace(ctx context.Context) *ClientTrace {
+	trace, _ := ctx.Value(clientTraceKey{}).(*ClientTrace)
+	return trace
+}
+
+// traceSet holds the traces of requests in flight over a connection, so the hooks of
+// the connection worker can reach them.
+type traceSet struct {
+	n      atomic.Int32
+	mu     sync.Mutex
+	traces map[*ClientTrace]int
+}
+
+func (s *traceSet) add(trace *ClientTrace) {
+	s.mu.Lock()
+	if s.traces == nil {
+		s.traces = make(map[*ClientTrace]int)
+	}
+	s.traces[trace]++
+	s.mu.Unlock()
+	s.n.Add(1)
+}
+
+func (s *traceSet) remove(trace *ClientTrace) {
+	s.n.Add(-1)
+	s.mu.Lock()
+	if s.traces[trace]--; s.traces[trace] <= 0 {
+		delete(s.traces, trace)
+	}
+	s.mu.Unlock()
+}
+
+// each calls fn for every trace in s. It is cheap when nothing is traced.
+func (s *traceSet) each(fn func(trace *ClientTrace)) {
+	if s == nil || s.n.Load() == 0 {
+		return
+	}
+	s.mu.Lock()
+	traces := make([]*ClientTrace, 0, len(s.traces))
+	for trace := range s.traces {
+		traces = append(traces, trace)
+	}
+	s.mu.Unlock()
+
+	for _, trace := range traces {
+		fn(trace)
+	}
+}
+
+// tracedTLSConfig returns a copy of cfg that reports verified handshakes to the traces of c.
+func (c *client) tracedTLSConfig(cfg *tls.Config) *tls.Config {
+	if cfg == nil {
+		return nil
+	}
+	cfg = cfg.Clone()
+	verify := cfg.VerifyConnection
+	cfg.VerifyConnection = func(cs tls.ConnectionState) error {
+		var err error
+		if verify != nil {
+			err = verify(cs)
+		}
+		c.traces.each(func(trace *ClientTrace) {
+			if trace.TLSHandshakeDone != nil {
+				trace.TLSHandshakeDone(cs, err)
+			}
+		})
+		return err
+	}
+	return cfg
+}
+
+// tracedRequest reports to trace when req is written to the connection.
+type tracedRequest struct {
+	*contract.Request
+	trace *ClientTrace
+}
+
+func (r *tracedRequest) WriteRequest(bw *bufio.Writer) error {
+	err := r.Request.WriteRequest(bw)
+	r.trace.WroteRequest(WroteRequestInfo{Method: r.GetName(), Err: err})
+	return err
+}
@@ -0,0 +1,88 @@
+package client
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/google/uuid"
+	base "github.com/mygaru/dcr-sdk/gen/base1"
+	"github.com/mygaru/dcr-sdk/pkg/contract"
+)
+
+func TestClientTraceReportsRequestPhases(t *testing.T) {
+	addr := startTestServer(t, func(ctx *contract.RequestCtx) {
+		if ctx.Request.GetName() == contract.Auth {
+			writeTestAuthResponse(ctx, testReporterServerID)
+			return
+		}
+		writeTestTargetResponse(ctx, "0400000000000001")
+	})
+	sc := NewClient(&Configuration{
+		Addrs:                          addr,
+		JwtToken:                       []byte(uuid.NewString()),
+		MaximumSimultaneousConnections: 1,
+		DNSRefreshInterval:             -1,
+	}, nil)
+
+	var mu sync.Mutex
+	var events []string
+	record := func(event string) {
+		mu.Lock()
+		events = append(events, event)
+		mu.Unlock()
+	}
+	var gotConn GotConnInfo
+	var gotResp GotResponseInfo
+	var authServerID uint16
+	trace := &ClientTrace{
+		GotConn:      func(info GotConnInfo) { gotConn = info; record("GotConn") },
+		DNSDone:      func(DNSDoneInfo) { record("DNSDone") },
+		DialStart:    func(string) { record("DialStart") },
+		DialDone:     func(string, error) { record("DialDone") },
+		AuthStart:    func() { record("AuthStart") },
+		AuthDone:     func(serverID uint16, err error) { authServerID = serverID; record("AuthDone") },
+		WroteRequest: func(WroteRequestInfo) { record("WroteRequest") },
+		GotResponse:  func(info GotResponseInfo) { gotResp = info; record("GotResponse") },
+	}
+
+	ctx := WithClientTrace(context.Background(), trace)
+	if _, _, err := sc.TargetContext(ctx, testTargetRequest()); err != nil {
+		t.Fatalf("target: %v", err)
+	}
+
+	mu.Lock()
+	got := strings.Join(events, ",")
+	mu.Unlock()
+	want := "GotConn,AuthStart,DNSDone,DialStart,DialDone,AuthDone,WroteRequest,GotResponse"
+	if got != want {
+		t.Fatalf("expected events %s, got %s", want, got)
+	}
+	if gotConn.Addr != addr || gotConn.Reused {
+		t.Fatalf("expected a fresh connection of shard %s, got %+v", addr, gotConn)
+	}
+	if authServerID != testReporterServerID {
+		t.Fatalf("expected auth to report server ID %d, got %d", testReporterServerID, authServerID)
+	}
+	if gotResp.StatusCode != base.RPCServerResponseCode_OK || gotResp.ServerID != testReporterServerID || gotResp.Err != nil {
+		t.Fatalf("unexpected response info %+v", gotResp)
+	}
+
+	// The second call reuses the authenticated connection.
+	mu.Lock()
+	events = nil
+	mu.Unlock()
+	if _, _, err := sc.TargetContext(ctx, testTargetRequest()); err != nil {
+		t.Fatalf("target: %v", err)
+	}
+	mu.Lock()
+	got = strings.Join(events, ",")
+	mu.Unlock()
+	if want := "GotConn,WroteRequest,GotResponse"; got != want {
+		t.Fatalf("expected events %s, got %s", want, got)
+	}
+	if !gotConn.Reused || gotConn.RemoteAddr != addr || gotConn.ServerID != testReporterServerID {
+		t.Fatalf("expected the dialed connection to be reused, got %+v", gotConn)
+	}
+}
@@ -0,0 +1,130 @@
+package client
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+
+	"github.com/mygaru/dcr-sdk/pkg/contract"
+)
+
+// Validate reports the problems in cfg that NewClient would otherwise silently replace with defaults
+// or only surface later as dial errors and timeouts. Zero values are valid and mean the defaults.
+//
+// Validate doesn't check authentication, because it depends on the TLS configuration;
+// NewValidatedClient checks it as well.
+func (cfg *Configuration) Validate() error {
+	if cfg == nil {
+		return nil
+	}
+
+	var errs []error
+	if len(cfg.Shards) > 0 {
+		for _, shard := range cfg.Shards {
+			if err := shard.validate(); err != nil {
+				errs = append(errs, fmt.Errorf("Shards: invalid shard %q: %w", shard.Addr, err))
+			}
+		}
+	} else if _, err := ParseShards(cfg.Addrs); err != nil {
+		errs = append(errs, fmt.Errorf("Addrs: %w", err))
+	}
+
+	if len(cfg.JwtToken) > 0 && cfg.TokenSource != nil {
+		errs = append(errs, fmt.Errorf("JwtToken and TokenSource must not be set together"))
+	}
+
+	if cfg.MaxRequestDuration < 0 {
+		errs = append(errs, fmt.Errorf("MaxRequestDuration must be positive, got %s", cfg.MaxRequestDuration))
+	}
+	if cfg.MaxDialDuration < 0 {
+		errs = append(errs, fmt.Errorf("MaxDialDuration must be positive, got %s", cfg.MaxDialDuration))
+	}
+	if cfg.DrainGracePeriod < 0 {
+		errs = append(errs, fmt.Errorf("DrainGracePeriod must be positive, got %s", cfg.DrainGracePeriod))
+	}
+	requestDuration := cfg.MaxRequestDuration
+	if requestDuration <= 0 {
+		requestDuration = defaultMaxRequestDuration
+	}
+	if cfg.MaxDialDuration > requestDuration {
+		errs = append(errs, fmt.Errorf("MaxDialDuration %s exceeds MaxRequestDuration %s; a connection is dialed "+
+			"within the request that needs it, so the request times out first", cfg.MaxDialDuration, requestDuration))
+	}
+
+	if cfg.MaximumSimultaneousConnections < 0 {
+		errs = append(errs, fmt.Errorf("MaximumSimultaneousConnections must not be negative, got %d", cfg.MaximumSimultaneousConnections))
+	}
+	if cfg.MaxPendingRequests < 0 {
+		errs = append(errs, fmt.Errorf("MaxPendingRequests must not be negative, got %d", cfg.MaxPendingRequests))
+	}
+	errs = append(errs, validateBufferSize("ReadBufferSize", cfg.ReadBufferSize))
+	errs = append(errs, validateBufferSize("WriteBufferSize", cfg.WriteBufferSize))
+
+	if cfg.Retry.MaxAttempts >= 2 {
+		if cfg.Retry.Jitter < 0 || cfg.Retry.Jitter > 1 {
+			errs = append(errs, fmt.Errorf("Retry.Jitter must be in [0, 1], got %g", cfg.Retry.Jitter))
+		}
+		if cfg.Retry.MaxBackoff > 0 && cfg.Retry.MaxBackoff < cfg.Retry.InitialBackoff {
+			errs = append(errs, fmt.Errorf("Retry.MaxBackoff %s is below Retry.InitialBackoff %s",
+				cfg.Retry.MaxBackoff, cfg.Retry.InitialBackoff))
+		}
+	}
+	if cfg.CertificateReload.Interval < 0 || cfg.CertificateReload.RecycleDuration < 0 {
+		errs = append(errs, fmt.Errorf("CertificateReload.Interval and CertificateReload.RecycleDuration must be positive, got %s and %s",
+			cfg.CertificateReload.Interval, cfg.CertificateReload.RecycleDuration))
+	}
+	if cfg.Hedge.Percentile < 0 || cfg.Hedge.Percentile >= 1 {
+		errs = append(errs, fmt.Errorf("Hedge.Percentile must be in [0, 1), e.g. 0.95, got %g", cfg.Hedge.Percentile))
+	}
+	if cfg.Breaker.FailureRatio < 0 || cfg.Breaker.FailureRatio > 1 {
+		errs = append(errs, fmt.Errorf("Breaker.FailureRatio must be in [0, 1], got %g", cfg.Breaker.FailureRatio))
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidConfiguration, err)
+	}
+	return nil
+}
+
+func validateBufferSize(name string, size int) error {
+	if size < 0 {
+		return fmt.Errorf("%s must not be negative, got %d", name, size)
+	}
+	if size > contract.MaxPayloadSize {
+		return fmt.Errorf("%s %d exceeds the %d bytes frame limit; larger buffers are never filled", name, size,
+			contract.MaxPayloadSize)
+	}
+	return nil
+}
+
+// validateAuth checks that the client will authenticate itself either with JwtToken or with the client
+// certificate of tlsConfig.
+func (cfg *Configuration) validateAuth(tlsConfig *tls.Config) error {
+	hasClientCert := tlsConfig != nil && (len(tlsConfig.Certificates) > 0 || tlsConfig.GetClientCertificate != nil)
+	switch {
+	case cfg.DisableAuth && tlsConfig == nil:
+		return fmt.Errorf("%w: DisableAuth requires TLS with a client certificate; set JwtToken instead or use NewWithMTLS",
+			ErrInvalidConfiguration)
+	case cfg.DisableAuth && !hasClientCert:
+		return fmt.Errorf("%w: DisableAuth requires a client certificate in the TLS configuration", ErrInvalidConfiguration)
+	case !cfg.DisableAuth && len(cfg.JwtToken) == 0 && cfg.TokenSource == nil:
+		return fmt.Errorf("%w: JwtToken or TokenSource is required unless DisableAuth is set with an mTLS client certificate",
+			ErrInvalidConfiguration)
+	}
+	return nil
+}
+
+// NewValidatedClient is like NewClient, but it validates cfg and its consistency with tlsConfig first
+// and returns an error instead of replacing invalid values with defaults.
+func NewValidatedClient(cfg *Configuration, tlsConfig *tls.Config) (*ShardedClient, error) {
+	if cfg == nil {
+		cfg = &Configuration{}
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	if err := cfg.validateAuth(tlsConfig); err != nil {
+		return nil, err
+	}
+	return NewClient(cfg, tlsConfig), nil
+}
@@ -0,0 +1,103 @@
+package client
+
+import (
+	"crypto/tls"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mygaru/dcr-sdk/pkg/contract"
+)
+
+func TestConfigurationValidate(t *testing.T) {
+	valid := []*Configuration{
+		nil,
+		{},
+		{Addrs: "dcr-a:7937,1024@dcr-b:7937;weight=2", MaxRequestDuration: 80 * time.Millisecond, MaxDialDuration: 50 * time.Millisecond},
+		{DNSRefreshInterval: -1, ReadBufferSize: contract.MaxPayloadSize},
+		{Shards: []ShardConfig{{Addr: "dcr-a:7937", Weight: 2}}},
+	}
+	for _, cfg := range valid {
+		if err := cfg.Validate(); err != nil {
+			t.Fatalf("unexpected error for %+v: %s", cfg, err)
+		}
+	}
+
+	invalid := map[string]*Configuration{
+		"missing port":            {Addrs: "dcr-a"},
+		"missing host":            {Addrs: ":7937"},
+		"invalid structured addr": {Shards: []ShardConfig{{Addr: "dcr-a"}}},
+		"negative timeout":        {MaxRequestDuration: -time.Second},
+		"dial exceeds request":    {MaxRequestDuration: 80 * time.Millisecond, MaxDialDuration: time.Second},
+		"dial exceeds default":    {MaxDialDuration: 2 * defaultMaxRequestDuration},
+		"negative conns":          {MaximumSimultaneousConnections: -1},
+		"read buffer over limit":  {ReadBufferSize: contract.MaxPayloadSize + 1},
+		"negative write buffer":   {WriteBufferSize: -1},
+		"jitter out of range":     {Retry: RetryPolicy{MaxAttempts: 2, Jitter: 2}},
+		"hedge percentile":        {Hedge: HedgePolicy{Percentile: 95}},
+		"breaker failure ratio":   {Breaker: BreakerPolicy{FailureRatio: 50}},
+	}
+	for name, cfg := range invalid {
+		if err := cfg.Validate(); !errors.Is(err, ErrInvalidConfiguration) {
+			t.Fatalf("%s: expected ErrInvalidConfiguration, got %v", name, err)
+		}
+	}
+
+	err := (&Configuration{Addrs: "dcr-a", MaximumSimultaneousConnections: -1}).Validate()
+	if err == nil || !strings.Contains(err.Error(), "Addrs") || !strings.Contains(err.Error(), "MaximumSimultaneousConnections") {
+		t.Fatalf("expected every problem to be reported, got %v", err)
+	}
+}
+
+func TestNewValidatedClientChecksAuth(t *testing.T) {
+	withCert := &tls.Config{Certificates: []tls.Certificate{{}}}
+	for name, tc := range map[string]struct {
+		cfg       *Configuration
+		tlsConfig *tls.Config
+	}{
+		"no token":                     {cfg: &Configuration{Addrs: "dcr-a:7937"}},
+		"disabled auth without tls":    {cfg: &Configuration{Addrs: "dcr-a:7937", DisableAuth: true}},
+		"disabled auth without a cert": {cfg: &Configuration{Addrs: "dcr-a:7937", DisableAuth: true}, tlsConfig: &tls.Config{}},
+		"invalid addrs":                {cfg: &Configuration{Addrs: "dcr-a", JwtToken: []byte("token")}},
+	} {
+		if _, err := NewValidatedClient(tc.cfg, tc.tlsConfig); !errors.Is(err, ErrInvalidConfiguration) {
+			t.Fatalf("%s: expected ErrInvalidConfiguration, got %v", name, err)
+		}
+	}
+
+	for _, tc := range []struct {
+		cfg       *Configuration
+		tlsConfig *tls.Config
+	}{
+		{cfg: &Configuration{Addrs: "127.0.0.1:1", JwtToken: []byte("token")}},
+		{cfg: &Configuration{Addrs: "127.0.0.1:1", DisableAuth: true}, tlsConfig: withCert},
+	} {
+		sc, err := NewValidatedClient(tc.cfg, tc.tlsConfig)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		sc.Close()
+	}
+}
+
+func TestClientConfigurationStaysValid(t *testing.T) {
+	sc, err := NewValidatedClient(&Configuration{Addrs: "127.0.0.1:1", JwtToken: []byte("token")}, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer sc.Close()
+
+	if sc.TokenSource != nil {
+		t.Fatal("expected the token source derived from JwtToken to stay out of the configuration")
+	}
+	cfg := sc.Configuration
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("expected the configuration of the client to stay valid, got %s", err)
+	}
+	again, err := NewValidatedClient(&cfg, nil)
+	if err != nil {
+		t.Fatalf("expected a client to be created from the configuration of another one, got %s", err)
+	}
+	again.Close()
+}
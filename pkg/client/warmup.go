@@ -0,0 +1,94 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+// Warmup dials every connection of every shard and runs the connection handshake over it, so the first
+// Target and Report calls don't pay for the dial, the fastrpc handshake, contract.Hello and contract.Auth.
+// The server ID of every shard is learned from the handshake, so Report can be routed before the first Target.
+//
+// With DisableAuth only contract.Hello is sent. For servers that predate contract.Hello the server ID
+// of a shard is then learned from its first Target response.
+//
+// Warmup waits until all connections are ready or ctx is done. It returns an error per failed shard.
+func (sc *ShardedClient) Warmup(ctx context.Context) error {
+	if !sc.acquire() {
+		return ErrClosed
+	}
+	defer sc.release()
+
+	shards := sc.shardSet().clients
+	errs := make([]error, len(shards))
+	var wg sync.WaitGroup
+	for i, shard := range shards {
+		var once sync.Once
+		for _, cl := range shard.clients {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if err := cl.warmup(ctx); err != nil {
+					once.Do(func() {
+						errs[i] = fmt.Errorf("warmup of shard %q is failed: %w", shard.addr, err)
+					})
+				}
+			}()
+		}
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// warmup establishes the current connection and runs the connection handshake over it, waiting
+// for a handshake that is already in progress instead of failing fast.
+func (c *client) warmup(ctx context.Context) error {
+	if c.isAuthForCurrentConn() {
+		return nil
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		errCh <- c.ensureAuthForCurrentConnLocked(context.WithoutCancel(ctx))
+	}()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// authAfterDial runs the connection handshake right after a connection is dialed when Configuration.AuthOnDial is set.
+// fastrpc sends the handshake requests as soon as its protocol handshake over the new connection completes.
+func (c *client) authAfterDial() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_ = c.ensureAuthForCurrentConnLocked(context.Background())
+}
+
+// getReadyClient is like getClient, but skips connections that are dialed and still run the connection
+// handshake. Connections that have never been dialed stay in rotation, since fastrpc dials a connection
+// only for a request. If every connection runs the handshake, the client picked by the balancer is returned.
+func (sh *clientsGroup) getReadyClient() *client {
+	idx := sh.balancer.Pick(sh.endpoints)
+	for i := 0; i < len(sh.clients); i++ {
+		cl := sh.clients[(idx+i)%len(sh.clients)]
+		if !cl.isHandshaking() {
+			return cl
+		}
+	}
+	return sh.clients[idx]
+}
+
+// isHandshaking reports whether the current connection is dialed but hasn't completed the connection handshake.
+func (c *client) isHandshaking() bool {
+	gen := c.connGen.Load()
+	return gen > 0 && gen != atomic.LoadUint64(&c.authedGen)
+}
@@ -0,0 +1,182 @@
+package client
+
+import (
+	"context"
+	"encoding/binary"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	base "github.com/mygaru/dcr-sdk/gen/base1"
+	"github.com/mygaru/dcr-sdk/pkg/contract"
+)
+
+func TestWarmupAuthenticatesConnectionsAndLearnsServerID(t *testing.T) {
+	var auths atomic.Int64
+	addr := startTestServer(t, func(ctx *contract.RequestCtx) {
+		if ctx.Request.GetName() == contract.Auth {
+			auths.Add(1)
+			writeTestAuthResponse(ctx, testReporterServerID)
+			return
+		}
+		ctx.Response.SetStatusCode(base.RPCServerResponseCode_OK)
+	})
+	sc := NewClient(&Configuration{
+		Addrs:                          addr,
+		JwtToken:                       []byte(uuid.NewString()),
+		MaximumSimultaneousConnections: 3,
+		DNSRefreshInterval:             -1,
+	}, nil)
+
+	if err := sc.Warmup(context.Background()); err != nil {
+		t.Fatalf("warmup: %v", err)
+	}
+	if got := auths.Load(); got != 3 {
+		t.Fatalf("expected every connection to authenticate, got %d auth requests", got)
+	}
+	for i, cl := range sc.shardSet().clients[0].clients {
+		if !cl.isAuthForCurrentConn() {
+			t.Fatalf("expected connection %d to be authenticated", i)
+		}
+	}
+	if !sc.IsValidTrackingID([]byte("0400000000000001")) {
+		t.Fatalf("expected the shard server ID to be known after warmup")
+	}
+	if _, err := sc.Report(testReportRequest()); err != nil {
+		t.Fatalf("expected report to be routed after warmup, got %v", err)
+	}
+}
+
+func TestWarmupDialsConnectionsWithoutAuth(t *testing.T) {
+	var hellos atomic.Int64
+	addr := startRawTestServer(t, func(ctx *contract.RequestCtx) {
+		if ctx.Request.GetName() == contract.Hello {
+			hellos.Add(1)
+		}
+		ctx.Response.SetStatusCode(base.RPCServerResponseCode_INVALID_REQUEST)
+	})
+	sc := NewClient(&Configuration{
+		Addrs:                          addr,
+		DisableAuth:                    true,
+		MaximumSimultaneousConnections: 2,
+		DNSRefreshInterval:             -1,
+	}, nil)
+
+	if err := sc.Warmup(context.Background()); err != nil {
+		t.Fatalf("warmup: %v", err)
+	}
+	if got := hellos.Load(); got != 2 {
+		t.Fatalf("expected a hello per connection, got %d", got)
+	}
+	for i, cl := range sc.shardSet().clients[0].clients {
+		if cl.Reconnects() != 1 {
+			t.Fatalf("expected connection %d to be dialed once, got %d", i, cl.Reconnects())
+		}
+	}
+}
+
+func TestWarmupReportsFailedShard(t *testing.T) {
+	addr := startTestServer(t, func(ctx *contract.RequestCtx) {
+		ctx.Response.SetStatusCode(base.RPCServerResponseCode_UNAUTHORIZED)
+	})
+	sc := NewClient(&Configuration{
+		Addrs:                          addr,
+		JwtToken:                       []byte(uuid.NewString()),
+		MaximumSimultaneousConnections: 2,
+		DNSRefreshInterval:             -1,
+	}, nil)
+
+	if err := sc.Warmup(context.Background()); err == nil {
+		t.Fatalf("expected warmup to fail for unauthorized shard")
+	}
+}
+
+func TestAuthOnDialKeepsHandshakingConnectionsOutOfRotation(t *testing.T) {
+	authStarted := make(chan struct{}, 1)
+	releaseAuth := make(chan struct{})
+	addr := startTestServer(t, func(ctx *contract.RequestCtx) {
+		if ctx.Request.GetName() == contract.Auth {
+			authStarted <- struct{}{}
+			<-releaseAuth
+			writeTestAuthResponse(ctx, testReporterServerID)
+			return
+		}
+		writeTestTargetResponse(ctx, "0400000000000001")
+	})
+	defer close(releaseAuth)
+	sc := NewClient(&Configuration{
+		Addrs:                          addr,
+		JwtToken:                       []byte(uuid.NewString()),
+		MaximumSimultaneousConnections: 2,
+		DNSRefreshInterval:             -1,
+		AuthOnDial:                     true,
+	}, nil)
+	defer sc.Close()
+	shard := sc.shardSet().clients[0]
+	ready, handshaking := shard.clients[0], shard.clients[1]
+	ready.connGen.Store(1)
+	atomic.StoreUint64(&ready.authedGen, 1)
+
+	// Dial the second connection and hold its handshake.
+	go handshaking.warmup(context.Background())
+	<-authStarted
+	for i := 0; i < 10; i++ {
+		if cl := shard.getClient(); cl != ready {
+			t.Fatalf("expected the handshaking connection to be skipped")
+		}
+	}
+}
+
+func TestAuthOnDialSpreadsRequestsWithoutWarmup(t *testing.T) {
+	var auths atomic.Int64
+	addr := startTestServer(t, func(ctx *contract.RequestCtx) {
+		if ctx.Request.GetName() == contract.Auth {
+			auths.Add(1)
+			writeTestAuthResponse(ctx, testReporterServerID)
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+		writeTestTargetResponse(ctx, "0400000000000001")
+	})
+	sc := NewClient(&Configuration{
+		Addrs:                          addr,
+		JwtToken:                       []byte(uuid.NewString()),
+		MaxRequestDuration:             time.Second,
+		MaximumSimultaneousConnections: 8,
+		MaxPendingRequests:             4,
+		DNSRefreshInterval:             -1,
+		AuthOnDial:                     true,
+	}, nil)
+	defer sc.Close()
+
+	if _, _, err := sc.Target(testTargetRequest()); err != nil {
+		t.Fatalf("first target: %v", err)
+	}
+
+	// More concurrent Targets than a single connection takes must be spread over the connections
+	// that haven't been dialed yet instead of piling up on the authenticated one.
+	const targets = 12
+	errs := make(chan error, targets)
+	for i := 0; i < targets; i++ {
+		go func() {
+			_, _, err := sc.Target(testTargetRequest())
+			errs <- err
+		}()
+	}
+	for i := 0; i < targets; i++ {
+		if err := <-errs; err != nil {
+			t.Fatalf("target: %v", err)
+		}
+	}
+	if got := auths.Load(); got < 2 {
+		t.Fatalf("expected more connections to be dialed, got %d auth requests", got)
+	}
+}
+
+func writeTestAuthResponse(ctx *contract.RequestCtx, serverID uint16) {
+	ctx.Response.SetStatusCode(base.RPCServerResponseCode_OK)
+	buf := binary.LittleEndian.AppendUint16(nil, serverID)
+	id := uuid.New()
+	ctx.Response.Append(append(buf, id[:]...))
+}
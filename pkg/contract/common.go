@@ -6,12 +6,13 @@ import (
 	"io"
 )
 
-const maxBytesSize = 1024 * 1024
+// MaxPayloadSize is the maximum size of a request or response body. Larger bodies are rejected by both peers.
+const MaxPayloadSize = 1024 * 1024
 
 func writeBytes(bw *bufio.Writer, b, sizeBuf []byte) error {
 	size := len(b)
-	if size > maxBytesSize {
-		return fmt.Errorf("too big size=%d. Must not exceed %d", size, maxBytesSize)
+	if size > MaxPayloadSize {
+		return fmt.Errorf("too big size=%d. Must not exceed %d", size, MaxPayloadSize)
 	}
 	buf := appendUint32(sizeBuf[:0], uint32(size))
 	_, err := bw.Write(buf)
@@ -31,8 +32,8 @@ func readBytes(br *bufio.Reader, b, sizeBuf []byte) ([]byte, error) {
 		return b, fmt.Errorf("cannot read size: %s", err)
 	}
 	size := int(bytes2Uint32(sizeBuf))
-	if size > maxBytesSize {
-		return b, fmt.Errorf("too big size=%d. Must not exceed %d", size, maxBytesSize)
+	if size > MaxPayloadSize {
+		return b, fmt.Errorf("too big size=%d. Must not exceed %d", size, MaxPayloadSize)
 	}
 	if cap(b) < size {
 		b = make([]byte, size)
@@ -7,6 +7,7 @@ const (
 	Target  RPCRegister = 1
 	Report  RPCRegister = 2
 	Auth    RPCRegister = 4
+	Hello   RPCRegister = 8
 
 	MaxRequestIdentifier = Report
 )
@@ -19,6 +20,8 @@ func (r RPCRegister) String() string {
 		return "report"
 	case Auth:
 		return "auth"
+	case Hello:
+		return "hello"
 	default:
 		panic("unknown")
 	}
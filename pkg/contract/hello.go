@@ -0,0 +1,86 @@
+package contract
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// SDKVersion is the version of this SDK, sent to the server in every Hello request.
+const SDKVersion = "1.0.0"
+
+// Capability is a protocol feature supported by the server, announced in HelloResponse.
+type Capability uint32
+
+const (
+	// CapabilityJWTAuth means the server accepts connections authenticated with the contract.Auth exchange.
+	CapabilityJWTAuth Capability = 1 << iota
+
+	// CapabilityMTLSAuth means the server authenticates connections by their mTLS client certificate.
+	CapabilityMTLSAuth
+)
+
+// Has reports whether c includes all of the capabilities in other.
+func (c Capability) Has(other Capability) bool {
+	return c&other == other
+}
+
+// The Hello exchange is the first request over every new connection. The request value is the
+// SDK version. The server answers with OK and a HelloResponse, or with OUTDATED and a HelloResponse
+// if the SDK version is below HelloResponse.MinSDKVersion. Servers that predate Hello answer INVALID_REQUEST.
+
+// HelloResponse is the value of a Hello response.
+type HelloResponse struct {
+	// ServerID is the ID of the server, the one encoded into the tracking ids it issues.
+	ServerID uint16
+
+	// Capabilities are the protocol features supported by the server.
+	Capabilities Capability
+
+	// MinSDKVersion is the minimum SDK version accepted by the server. It may be empty.
+	MinSDKVersion string
+}
+
+const helloResponseHeaderSize = 6
+
+// AppendTo appends the wire encoding of r to dst: the server ID and the capabilities in little-endian
+// byte order followed by the minimum SDK version.
+func (r *HelloResponse) AppendTo(dst []byte) []byte {
+	dst = binary.LittleEndian.AppendUint16(dst, r.ServerID)
+	dst = binary.LittleEndian.AppendUint32(dst, uint32(r.Capabilities))
+	return append(dst, r.MinSDKVersion...)
+}
+
+// Unmarshal decodes r from b.
+func (r *HelloResponse) Unmarshal(b []byte) error {
+	if len(b) < helloResponseHeaderSize {
+		return fmt.Errorf("hello response is too short: %d bytes, want at least %d", len(b), helloResponseHeaderSize)
+	}
+	r.ServerID = binary.LittleEndian.Uint16(b)
+	r.Capabilities = Capability(binary.LittleEndian.Uint32(b[2:]))
+	r.MinSDKVersion = string(b[helloResponseHeaderSize:])
+	return nil
+}
+
+// CompareVersions compares two dotted numeric versions such as "1.2.0" and returns -1, 0 or +1.
+// Missing components are treated as zero and non-numeric components as zero.
+func CompareVersions(a, b string) int {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < max(len(as), len(bs)); i++ {
+		var x, y int
+		if i < len(as) {
+			x, _ = strconv.Atoi(as[i])
+		}
+		if i < len(bs) {
+			y, _ = strconv.Atoi(bs[i])
+		}
+		switch {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+	}
+	return 0
+}
@@ -0,0 +1,43 @@
+package contract
+
+import "testing"
+
+func TestHelloResponseRoundTrip(t *testing.T) {
+	want := HelloResponse{
+		ServerID:      1024,
+		Capabilities:  CapabilityJWTAuth | CapabilityMTLSAuth,
+		MinSDKVersion: "1.2.0",
+	}
+
+	var got HelloResponse
+	if err := got.Unmarshal(want.AppendTo(nil)); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if got != want {
+		t.Fatalf("unexpected hello response: %+v, want %+v", got, want)
+	}
+	if !got.Capabilities.Has(CapabilityMTLSAuth) {
+		t.Fatalf("expected the mTLS capability")
+	}
+
+	if err := got.Unmarshal([]byte{1, 2, 3}); err == nil {
+		t.Fatalf("expected a short hello response to be rejected")
+	}
+}
+
+func TestCompareVersions(t *testing.T) {
+	for _, tc := range []struct {
+		a, b string
+		want int
+	}{
+		{"1.0.0", "1.0.0", 0},
+		{"1.0", "1.0.0", 0},
+		{"1.0.0", "1.2.0", -1},
+		{"1.10.0", "1.9.0", 1},
+		{"2", "1.99.99", 1},
+	} {
+		if got := CompareVersions(tc.a, tc.b); got != tc.want {
+			t.Fatalf("CompareVersions(%q, %q) = %d, want %d", tc.a, tc.b, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package contract
+
+import (
+	"errors"
+	"fmt"
+)
+
+// TrackingIDLen is the length of a tracking id in bytes. ParseTrackingID ignores anything past it.
+const TrackingIDLen = 16
+
+const (
+	// trackingIDServerIDLen is the number of leading hex digits holding the server ID.
+	trackingIDServerIDLen = 4
+
+	trackingIDSequenceMask = 1<<48 - 1
+)
+
+// ErrInvalidTrackingID matches the errors returned by ParseTrackingID.
+var ErrInvalidTrackingID = errors.New("invalid tracking id")
+
+// TrackingID is the tracking id issued by a DCR server in TargetResponse.TrackingId.
+//
+// It is encoded as 16 hex digits: the first 4 are the ID of the server that issued it, which
+// routes the Report back to the same server, and the other 12 are a per-server sequence number.
+// The format doesn't carry the time the id was issued.
+type TrackingID uint64
+
+// NewTrackingID returns the tracking id with the given server ID and the low 48 bits of seq.
+func NewTrackingID(serverID uint16, seq uint64) TrackingID {
+	return TrackingID(uint64(serverID)<<48 | seq&trackingIDSequenceMask)
+}
+
+// ParseTrackingID parses s as a tracking id. It accepts the same ids as the uniqid package it replaces:
+// s must be at least TrackingIDLen characters long and start with the 4 hex digits of the server ID.
+// The following 12 characters are the sequence number, with a character that is not a hex digit
+// decoded as 0, and the rest is ignored. An id with server ID 0 parses, but no server issues it,
+// so Report can't be routed for it. The returned error is a *TrackingIDError explaining why s is malformed.
+func ParseTrackingID(s string) (TrackingID, error) {
+	return parseTrackingID(s)
+}
+
+// ParseTrackingIDBytes is like ParseTrackingID, but doesn't allocate for a valid b.
+func ParseTrackingIDBytes(b []byte) (TrackingID, error) {
+	return parseTrackingID(b)
+}
+
+func parseTrackingID[T string | []byte](s T) (TrackingID, error) {
+	if len(s) == 0 {
+		return 0, &TrackingIDError{ID: string(s), Reason: "empty"}
+	}
+	if len(s) < TrackingIDLen {
+		return 0, &TrackingIDError{ID: string(s), Reason: fmt.Sprintf("length is %d, want at least %d", len(s), TrackingIDLen)}
+	}
+
+	var n uint64
+	for i := 0; i < TrackingIDLen; i++ {
+		d := fromHex(s[i])
+		if d == 0xff {
+			if i < trackingIDServerIDLen {
+				return 0, &TrackingIDError{ID: string(s), Reason: fmt.Sprintf("invalid hex digit %q at position %d", s[i], i)}
+			}
+			d = 0
+		}
+		n = n<<4 | uint64(d)
+	}
+
+	return TrackingID(n), nil
+}
+
+// ServerID returns the ID of the server that issued id.
+func (id TrackingID) ServerID() uint16 {
+	return uint16(id >> 48)
+}
+
+// Sequence returns the per-server sequence number of id.
+func (id TrackingID) Sequence() uint64 {
+	return uint64(id) & trackingIDSequenceMask
+}
+
+// String returns id encoded as 16 upper-case hex digits, the way DCR servers issue it.
+func (id TrackingID) String() string {
+	return string(id.AppendTo(nil))
+}
+
+// AppendTo appends id encoded as 16 upper-case hex digits to dst.
+func (id TrackingID) AppendTo(dst []byte) []byte {
+	const digits = "0123456789ABCDEF"
+	for shift := 60; shift >= 0; shift -= 4 {
+		dst = append(dst, digits[(uint64(id)>>shift)&0xf])
+	}
+	return dst
+}
+
+// TrackingIDError describes a malformed tracking id.
+type TrackingIDError struct {
+	// ID is the malformed tracking id.
+	ID string
+
+	// Reason explains why ID is malformed.
+	Reason string
+}
+
+func (e *TrackingIDError) Error() string {
+	return fmt.Sprintf("invalid tracking id %q: %s", e.ID, e.Reason)
+}
+
+// Is reports whether target is ErrInvalidTrackingID.
+func (e *TrackingIDError) Is(target error) bool {
+	return target == ErrInvalidTrackingID
+}
+
+func fromHex(b byte) byte {
+	switch {
+	case '0' <= b && b <= '9':
+		return b - '0'
+	case 'a' <= b && b <= 'f':
+		return b - 'a' + 10
+	case 'A' <= b && b <= 'F':
+		return b - 'A' + 10
+	default:
+		return 0xff
+	}
+}
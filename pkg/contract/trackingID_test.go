@@ -0,0 +1,106 @@
+package contract
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/aradilov/uniqid"
+)
+
+func TestParseTrackingID(t *testing.T) {
+	id, err := ParseTrackingID("0400000000ABCDEF")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if id.ServerID() != 1024 {
+		t.Fatalf("unexpected server id: %d", id.ServerID())
+	}
+	if id.Sequence() != 0xABCDEF {
+		t.Fatalf("unexpected sequence: %X", id.Sequence())
+	}
+	if id.String() != "0400000000ABCDEF" {
+		t.Fatalf("unexpected string: %s", id)
+	}
+
+	lower, err := ParseTrackingIDBytes([]byte("0400000000abcdef"))
+	if err != nil || lower != id {
+		t.Fatalf("expected lower-case hex to parse to %s, got %s, %v", id, lower, err)
+	}
+	if got := NewTrackingID(1024, 0xABCDEF); got != id {
+		t.Fatalf("unexpected tracking id: %s", got)
+	}
+}
+
+func TestParseTrackingIDIgnoresTrailingCharacters(t *testing.T) {
+	for _, s := range []string{"0400000000ABCDEF1", "0400000000ABCDEF-suffix", "0400000000ABCDEF0400000000ABCDEF"} {
+		id, err := ParseTrackingID(s)
+		if err != nil {
+			t.Fatalf("%q: unexpected error: %s", s, err)
+		}
+		if id != NewTrackingID(1024, 0xABCDEF) {
+			t.Fatalf("%q: unexpected tracking id: %s", s, id)
+		}
+	}
+}
+
+func TestParseTrackingIDAcceptsLegacyIDs(t *testing.T) {
+	for _, s := range []string{
+		"0400000000ABCDEF",
+		"0400000000abcdef",
+		"0400000000ABCDEF-suffix",
+		"04000000000000X1",
+		"0400-legacy-id-with-any-sequence",
+		"0000000000000001",
+		"FFFFzzzzzzzzzzzz",
+		"04X0000000000001",
+		"0400",
+		"",
+	} {
+		id, err := ParseTrackingID(s)
+		want := uniqid.GetServerID([]byte(s))
+		// uniqid returns 0 for malformed ids, which no server issues.
+		if err != nil && want != 0 {
+			t.Fatalf("%q: uniqid accepts server id %d, got %v", s, want, err)
+		}
+		if err == nil && id.ServerID() != want {
+			t.Fatalf("%q: expected server id %d like uniqid, got %d", s, want, id.ServerID())
+		}
+	}
+
+	id, err := ParseTrackingID("04000000000000X1")
+	if err != nil || id != NewTrackingID(1024, 1) {
+		t.Fatalf("expected a non-hex sequence digit to be decoded as 0, got %s, %v", id, err)
+	}
+}
+
+func TestParseTrackingIDAcceptsServerIDZero(t *testing.T) {
+	id, err := ParseTrackingID("0000000000000001")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if id.ServerID() != 0 || id.Sequence() != 1 {
+		t.Fatalf("unexpected tracking id: %s", id)
+	}
+}
+
+func TestParseTrackingIDExplainsMalformedID(t *testing.T) {
+	for s, reason := range map[string]string{
+		"":                  "empty",
+		"0400":              "length is 4, want at least 16",
+		"040000000000001":   "length is 15, want at least 16",
+		"04X0000000000001":  `invalid hex digit 'X' at position 2`,
+		"04X00000000000011": `invalid hex digit 'X' at position 2`,
+	} {
+		_, err := ParseTrackingID(s)
+		var idErr *TrackingIDError
+		if !errors.As(err, &idErr) {
+			t.Fatalf("%q: expected a TrackingIDError, got %v", s, err)
+		}
+		if idErr.Reason != reason {
+			t.Fatalf("%q: expected reason %q, got %q", s, reason, idErr.Reason)
+		}
+		if !errors.Is(err, ErrInvalidTrackingID) {
+			t.Fatalf("%q: expected the error to match ErrInvalidTrackingID", s)
+		}
+	}
+}
@@ -0,0 +1,295 @@
+// Package dcrfake provides an in-memory DCR client for application unit tests.
+//
+// Client implements the dcr.Client interface without any network. It records every call and lets
+// tests script the status codes and the outcome of every Match and Frequency rule:
+//
+//	fake := dcrfake.New()
+//	fake.SetSegmentStatus(42, base.Match_MISS)
+//	fake.PushTargetStatus(base.RPCServerResponseCode_SERVICE_UNAVAILABLE)
+//
+//	app := NewApp(fake) // NewApp accepts dcr.Client
+package dcrfake
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	base "github.com/mygaru/dcr-sdk/gen/base1"
+	"github.com/mygaru/dcr-sdk/pkg/client"
+	"github.com/mygaru/dcr-sdk/pkg/contract"
+	"google.golang.org/protobuf/proto"
+)
+
+// DefaultServerID is the server ID encoded into the tracking ids issued by a Client.
+const DefaultServerID uint16 = 1024
+
+// Client is a programmable in-memory DCR client. It is safe for concurrent use.
+//
+// By default every Target succeeds with a fresh tracking id, every Match rule is OK,
+// every Frequency rule is STATUS_PASSED and every Report of an issued tracking id succeeds.
+type Client struct {
+	mu sync.Mutex
+
+	serverID  uint16
+	counter   uint64
+	closed    bool
+	targets   []*base.TargetRequest
+	reports   []*base.ReportRequest
+	targetErr []base.RPCServerResponseCode
+	reportErr []base.RPCServerResponseCode
+
+	segments    map[uint32]base.Match_ResponseStatus
+	keys        map[uint64]base.Frequency_ResponseStatus
+	matchFn     func(req *base.TargetRequest, rule *base.Match_Rule) base.Match_ResponseStatus
+	frequencyFn func(req *base.TargetRequest, rule *base.Frequency_Rule) base.Frequency_ResponseStatus
+}
+
+// New returns a Client that issues tracking ids for DefaultServerID.
+func New() *Client {
+	return NewWithServerID(DefaultServerID)
+}
+
+// NewWithServerID returns a Client that issues tracking ids for serverID.
+func NewWithServerID(serverID uint16) *Client {
+	return &Client{
+		serverID: serverID,
+		segments: make(map[uint32]base.Match_ResponseStatus),
+		keys:     make(map[uint64]base.Frequency_ResponseStatus),
+	}
+}
+
+// SetSegmentStatus makes every Match rule that contains segmentID answer with status.
+// If a rule contains several scripted segments, the first one in the rule wins.
+func (c *Client) SetSegmentStatus(segmentID uint32, status base.Match_ResponseStatus) {
+	c.mu.Lock()
+	c.segments[segmentID] = status
+	c.mu.Unlock()
+}
+
+// SetFrequencyStatus makes every Frequency rule with key answer with status.
+func (c *Client) SetFrequencyStatus(key uint64, status base.Frequency_ResponseStatus) {
+	c.mu.Lock()
+	c.keys[key] = status
+	c.mu.Unlock()
+}
+
+// SetMatchFunc makes fn decide the outcome of every Match rule. It overrides SetSegmentStatus.
+// fn is called with c locked and must not call c methods.
+func (c *Client) SetMatchFunc(fn func(req *base.TargetRequest, rule *base.Match_Rule) base.Match_ResponseStatus) {
+	c.mu.Lock()
+	c.matchFn = fn
+	c.mu.Unlock()
+}
+
+// SetFrequencyFunc makes fn decide the outcome of every Frequency rule. It overrides SetFrequencyStatus.
+// fn is called with c locked and must not call c methods.
+func (c *Client) SetFrequencyFunc(fn func(req *base.TargetRequest, rule *base.Frequency_Rule) base.Frequency_ResponseStatus) {
+	c.mu.Lock()
+	c.frequencyFn = fn
+	c.mu.Unlock()
+}
+
+// PushTargetStatus queues status codes for the next Target calls, one per call.
+// A non-OK status code makes the call fail the way the real client does.
+func (c *Client) PushTargetStatus(codes ...base.RPCServerResponseCode) {
+	c.mu.Lock()
+	c.targetErr = append(c.targetErr, codes...)
+	c.mu.Unlock()
+}
+
+// PushReportStatus queues status codes for the next Report calls, one per call.
+func (c *Client) PushReportStatus(codes ...base.RPCServerResponseCode) {
+	c.mu.Lock()
+	c.reportErr = append(c.reportErr, codes...)
+	c.mu.Unlock()
+}
+
+// TargetRequests returns copies of the Target requests received so far.
+func (c *Client) TargetRequests() []*base.TargetRequest {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return cloneAll(c.targets)
+}
+
+// ReportRequests returns copies of the Report requests received so far.
+func (c *Client) ReportRequests() []*base.ReportRequest {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return cloneAll(c.reports)
+}
+
+// Reset forgets the recorded calls and the scripted outcomes and reopens a closed Client.
+func (c *Client) Reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = false
+	c.targets = nil
+	c.reports = nil
+	c.targetErr = nil
+	c.reportErr = nil
+	c.segments = make(map[uint32]base.Match_ResponseStatus)
+	c.keys = make(map[uint64]base.Frequency_ResponseStatus)
+	c.matchFn = nil
+	c.frequencyFn = nil
+}
+
+// Target implements dcr.Client.
+func (c *Client) Target(req *base.TargetRequest) (*base.TargetResponse, base.RPCServerResponseCode, error) {
+	return c.TargetContext(context.Background(), req)
+}
+
+// TargetContext implements dcr.Client.
+func (c *Client) TargetContext(ctx context.Context, req *base.TargetRequest) (*base.TargetResponse, base.RPCServerResponseCode, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, base.RPCServerResponseCode_NETWORK_ERROR, &client.RPCError{Method: contract.Target, Code: base.RPCServerResponseCode_NETWORK_ERROR, Err: err}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return nil, base.RPCServerResponseCode_UNKNOWN, client.ErrClosed
+	}
+	c.targets = append(c.targets, proto.Clone(req).(*base.TargetRequest))
+
+	if statusCode := pop(&c.targetErr); statusCode != base.RPCServerResponseCode_OK {
+		return nil, statusCode, statusError(contract.Target, statusCode)
+	}
+
+	c.counter++
+	resp := &base.TargetResponse{
+		TrackingId: contract.NewTrackingID(c.serverID, c.counter).AppendTo(nil),
+		StatusCode: base.RPCServerResponseCode_OK,
+		Match:      make([]base.Match_ResponseStatus, len(req.GetMatch())),
+		Frequency:  make([]base.Frequency_ResponseStatus, len(req.GetFrequency())),
+	}
+	for i, rule := range req.GetMatch() {
+		resp.Match[i] = c.matchStatus(req, rule)
+	}
+	for i, rule := range req.GetFrequency() {
+		resp.Frequency[i] = c.frequencyStatus(req, rule)
+	}
+	return resp, base.RPCServerResponseCode_OK, nil
+}
+
+// Report implements dcr.Client.
+func (c *Client) Report(req *base.ReportRequest) (base.RPCServerResponseCode, error) {
+	return c.ReportContext(context.Background(), req)
+}
+
+// ReportContext implements dcr.Client.
+// Like the real client, it fails without a status code if the tracking id wasn't issued for the server ID of c.
+func (c *Client) ReportContext(ctx context.Context, req *base.ReportRequest) (base.RPCServerResponseCode, error) {
+	if err := ctx.Err(); err != nil {
+		return base.RPCServerResponseCode_NETWORK_ERROR, &client.RPCError{Method: contract.Report, Code: base.RPCServerResponseCode_NETWORK_ERROR, Err: err}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return base.RPCServerResponseCode_UNKNOWN, client.ErrClosed
+	}
+	if err := c.validateTrackingIDLocked(req.TrackingId); err != nil {
+		return base.RPCServerResponseCode_UNKNOWN, err
+	}
+	c.reports = append(c.reports, proto.Clone(req).(*base.ReportRequest))
+
+	if statusCode := pop(&c.reportErr); statusCode != base.RPCServerResponseCode_OK {
+		return statusCode, statusError(contract.Report, statusCode)
+	}
+	return base.RPCServerResponseCode_OK, nil
+}
+
+// IsValidTrackingID implements dcr.Client.
+func (c *Client) IsValidTrackingID(trackingId []byte) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.validateTrackingIDLocked(trackingId) == nil
+}
+
+// ValidateTrackingID is like client.ShardedClient.ValidateTrackingID.
+func (c *Client) ValidateTrackingID(trackingId []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.validateTrackingIDLocked(trackingId)
+}
+
+func (c *Client) validateTrackingIDLocked(trackingId []byte) error {
+	if nil == trackingId {
+		return client.ErrMissingTrackingID
+	}
+	id, err := contract.ParseTrackingIDBytes(trackingId)
+	if err != nil {
+		return fmt.Errorf("%w: %w", client.ErrUnknownShard, err)
+	}
+	if id.ServerID() != c.serverID {
+		return fmt.Errorf("%w: %q", client.ErrUnknownShard, trackingId)
+	}
+	return nil
+}
+
+// PendingRequests implements dcr.Client. It always returns 0.
+func (c *Client) PendingRequests() int {
+	return 0
+}
+
+// Reconnects implements dcr.Client. It always returns 0.
+func (c *Client) Reconnects() int {
+	return 0
+}
+
+// Close implements dcr.Client. Calls made after Close fail with client.ErrClosed.
+func (c *Client) Close() error {
+	c.mu.Lock()
+	c.closed = true
+	c.mu.Unlock()
+	return nil
+}
+
+// Shutdown implements dcr.Client. It is equivalent to Close.
+func (c *Client) Shutdown(ctx context.Context) error {
+	return c.Close()
+}
+
+func (c *Client) matchStatus(req *base.TargetRequest, rule *base.Match_Rule) base.Match_ResponseStatus {
+	if c.matchFn != nil {
+		return c.matchFn(req, rule)
+	}
+	for _, id := range rule.GetSegmentIds() {
+		if status, ok := c.segments[id]; ok {
+			return status
+		}
+	}
+	return base.Match_OK
+}
+
+func (c *Client) frequencyStatus(req *base.TargetRequest, rule *base.Frequency_Rule) base.Frequency_ResponseStatus {
+	if c.frequencyFn != nil {
+		return c.frequencyFn(req, rule)
+	}
+	if status, ok := c.keys[rule.GetKey()]; ok {
+		return status
+	}
+	return base.Frequency_STATUS_PASSED
+}
+
+func pop(codes *[]base.RPCServerResponseCode) base.RPCServerResponseCode {
+	if len(*codes) == 0 {
+		return base.RPCServerResponseCode_OK
+	}
+	code := (*codes)[0]
+	*codes = (*codes)[1:]
+	return code
+}
+
+func statusError(method contract.RPCRegister, statusCode base.RPCServerResponseCode) error {
+	return &client.RPCError{Method: method, Code: statusCode, Message: "scripted by dcrfake"}
+}
+
+func cloneAll[T proto.Message](msgs []T) []T {
+	out := make([]T, len(msgs))
+	for i, msg := range msgs {
+		out[i] = proto.Clone(msg).(T)
+	}
+	return out
+}
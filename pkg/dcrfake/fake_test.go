@@ -0,0 +1,84 @@
+package dcrfake
+
+import (
+	"errors"
+	"testing"
+
+	dcr "github.com/mygaru/dcr-sdk"
+	base "github.com/mygaru/dcr-sdk/gen/base1"
+	"github.com/mygaru/dcr-sdk/pkg/client"
+)
+
+var _ dcr.Client = (*Client)(nil)
+
+func TestFakeScriptsRuleOutcomes(t *testing.T) {
+	fake := New()
+	fake.SetSegmentStatus(2, base.Match_MISS)
+	fake.SetFrequencyStatus(7, base.Frequency_STATUS_EXCEED)
+
+	resp, statusCode, err := fake.Target(&base.TargetRequest{
+		Match: []*base.Match_Rule{
+			{SegmentIds: []uint32{1}},
+			{SegmentIds: []uint32{2, 3}},
+		},
+		Frequency: []*base.Frequency_Rule{
+			{Key: 7},
+			{Key: 8},
+		},
+	})
+	if err != nil || statusCode != base.RPCServerResponseCode_OK {
+		t.Fatalf("expected OK, got %s: %v", statusCode, err)
+	}
+	if resp.Match[0] != base.Match_OK || resp.Match[1] != base.Match_MISS {
+		t.Fatalf("unexpected match statuses %v", resp.Match)
+	}
+	if resp.Frequency[0] != base.Frequency_STATUS_EXCEED || resp.Frequency[1] != base.Frequency_STATUS_PASSED {
+		t.Fatalf("unexpected frequency statuses %v", resp.Frequency)
+	}
+	if !fake.IsValidTrackingID(resp.TrackingId) {
+		t.Fatalf("expected issued tracking id %q to be valid", resp.TrackingId)
+	}
+}
+
+func TestFakeRecordsCallsAndScriptsStatusCodes(t *testing.T) {
+	fake := New()
+	fake.PushTargetStatus(base.RPCServerResponseCode_SERVICE_UNAVAILABLE)
+	fake.PushReportStatus(base.RPCServerResponseCode_OUTDATED)
+
+	if _, statusCode, err := fake.Target(&base.TargetRequest{}); err == nil || statusCode != base.RPCServerResponseCode_SERVICE_UNAVAILABLE {
+		t.Fatalf("expected scripted SERVICE_UNAVAILABLE, got %s: %v", statusCode, err)
+	}
+	resp, _, err := fake.Target(&base.TargetRequest{})
+	if err != nil {
+		t.Fatalf("expected the second target to succeed, got %v", err)
+	}
+
+	report := &base.ReportRequest{TrackingId: resp.TrackingId, Event: base.EventType_EVENT_TYPE_IMPRESSION}
+	if statusCode, err := fake.Report(report); err == nil || statusCode != base.RPCServerResponseCode_OUTDATED {
+		t.Fatalf("expected scripted OUTDATED, got %s: %v", statusCode, err)
+	}
+	if _, err := fake.Report(report); err != nil {
+		t.Fatalf("expected the second report to succeed, got %v", err)
+	}
+	if _, err := fake.Report(&base.ReportRequest{TrackingId: []byte("0401000000000001")}); err == nil {
+		t.Fatalf("expected report with a foreign tracking id to fail")
+	}
+
+	if got := len(fake.TargetRequests()); got != 2 {
+		t.Fatalf("expected 2 recorded targets, got %d", got)
+	}
+	reports := fake.ReportRequests()
+	if len(reports) != 2 || string(reports[1].TrackingId) != string(resp.TrackingId) {
+		t.Fatalf("unexpected recorded reports %v", reports)
+	}
+}
+
+func TestFakeClose(t *testing.T) {
+	fake := New()
+	if err := fake.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	if _, _, err := fake.Target(&base.TargetRequest{}); !errors.Is(err, client.ErrClosed) {
+		t.Fatalf("expected ErrClosed, got %v", err)
+	}
+}
@@ -29,6 +29,9 @@ type MTLSConfig struct {
 	ClientCertIntermediates *x509.CertPool
 	// CurrentTime optionally overrides certificate validity checks. When zero, the current time is used.
 	CurrentTime time.Time
+	// CertificateSource optionally provides the certificate and key instead of CertPEM and KeyPEM,
+	// so a renewed certificate is picked up without a restart. See CertificateReloader.
+	CertificateSource CertificateSource
 }
 
 // NewWithTLS creates an RPC client that can communicate with the DCR cloud
@@ -42,8 +45,21 @@ func NewWithTLS(cfg *client.Configuration, tls *tls.Config) *client.ShardedClien
 }
 
 // NewWithMTLS creates an RPC client from PEM-encoded mTLS certificate material.
+// It returns an error if the certificate is invalid. Like NewWithTLS, it replaces invalid values of cfg
+// with defaults; NewFromConfig validates cfg as well.
+//
+// If mtlsCfg.CertificateSource is set, the certificate is reloaded according to cfg.CertificateReload,
+// and the connections are recycled once it has changed.
 func NewWithMTLS(cfg *client.Configuration, mtlsCfg MTLSConfig) (*client.ShardedClient, error) {
-	tlsConfig, err := NewMTLSClientConfig(mtlsCfg)
+	return newWithMTLS(cfg, mtlsCfg, func(cfg *client.Configuration, tlsConfig *tls.Config) (*client.ShardedClient, error) {
+		return NewWithTLS(cfg, tlsConfig), nil
+	})
+}
+
+// newWithMTLS builds the TLS configuration from mtlsCfg and creates the client with newClient.
+func newWithMTLS(cfg *client.Configuration, mtlsCfg MTLSConfig,
+	newClient func(*client.Configuration, *tls.Config) (*client.ShardedClient, error)) (*client.ShardedClient, error) {
+	tlsConfig, reloader, err := newMTLSClientConfig(mtlsCfg)
 	if err != nil {
 		return nil, err
 	}
@@ -51,13 +67,54 @@ func NewWithMTLS(cfg *client.Configuration, mtlsCfg MTLSConfig) (*client.Sharded
 	if cfg != nil {
 		*cfgCopy = *cfg
 	}
+	if reloader != nil && cfgCopy.CertificateReload.Reloader == nil {
+		cfgCopy.CertificateReload.Reloader = reloader
+	}
 	cfgCopy.DisableAuth = true
-	return NewWithTLS(cfgCopy, tlsConfig), nil
+	return newClient(cfgCopy, tlsConfig)
 }
 
 // NewMTLSClientConfig builds a TLS client config and validates the client certificate with mtls.CheckTLS.
+//
+// If cfg.CertificateSource is set, the certificate is served with tls.Config.GetClientCertificate instead,
+// so it can be replaced with CertificateReloader.Reload. NewWithMTLS reloads it periodically.
 func NewMTLSClientConfig(cfg MTLSConfig) (*tls.Config, error) {
-	cert, err := tls.X509KeyPair(cfg.CertPEM, cfg.KeyPEM)
+	tlsConfig, _, err := newMTLSClientConfig(cfg)
+	return tlsConfig, err
+}
+
+func newMTLSClientConfig(cfg MTLSConfig) (*tls.Config, *CertificateReloader, error) {
+	minVersion := cfg.MinVersion
+	if minVersion == 0 {
+		minVersion = tls.VersionTLS12
+	}
+	tlsConfig := &tls.Config{
+		RootCAs:    cfg.ServerRootCAs,
+		ServerName: cfg.ServerName,
+		MinVersion: minVersion,
+	}
+
+	if cfg.CertificateSource != nil {
+		reloader, err := NewCertificateReloader(cfg)
+		if err != nil {
+			return nil, nil, err
+		}
+		tlsConfig.GetClientCertificate = reloader.GetClientCertificate
+		return tlsConfig, reloader, nil
+	}
+
+	cert, err := loadClientCertificate(cfg.CertPEM, cfg.KeyPEM, cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+	tlsConfig.Certificates = []tls.Certificate{*cert}
+	return tlsConfig, nil, nil
+}
+
+// loadClientCertificate parses the mTLS client certificate and validates it with mtls.CheckTLS
+// according to cfg.
+func loadClientCertificate(certPEM, keyPEM []byte, cfg MTLSConfig) (*tls.Certificate, error) {
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
 	if err != nil {
 		return nil, fmt.Errorf("parse mTLS client certificate: %w", err)
 	}
@@ -94,18 +151,7 @@ func NewMTLSClientConfig(cfg MTLSConfig) (*tls.Config, error) {
 	}); err != nil {
 		return nil, fmt.Errorf("validate mTLS client certificate: %w", err)
 	}
-
-	minVersion := cfg.MinVersion
-	if minVersion == 0 {
-		minVersion = tls.VersionTLS12
-	}
-
-	return &tls.Config{
-		Certificates: []tls.Certificate{cert},
-		RootCAs:      cfg.ServerRootCAs,
-		ServerName:   cfg.ServerName,
-		MinVersion:   minVersion,
-	}, nil
+	return &cert, nil
 }
 
 // New creates a test RPC client that can communicate with a non-TLS RPC server.
@@ -116,3 +162,9 @@ func New(cfg *client.Configuration) *client.ShardedClient {
 	}
 	return client.NewClient(cfg, nil)
 }
+
+// NewValidated is like New, or NewWithTLS if tlsConfig is set, but it returns an error instead of
+// silently replacing invalid values with defaults. See client.NewValidatedClient.
+func NewValidated(cfg *client.Configuration, tlsConfig *tls.Config) (*client.ShardedClient, error) {
+	return client.NewValidatedClient(cfg, tlsConfig)
+}
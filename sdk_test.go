@@ -1,6 +1,7 @@
 package dcr_sdk
 
 import (
+	"context"
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/rand"
@@ -17,6 +18,7 @@ import (
 	"github.com/google/uuid"
 	base "github.com/mygaru/dcr-sdk/gen/base1"
 	"github.com/mygaru/dcr-sdk/internal/testcloud"
+	"github.com/mygaru/dcr-sdk/pkg/contract"
 	"github.com/mygaru/dcr-sdk/pkg/serverauth"
 	"gitlab.adtelligent.com/awesome/mtls"
 
@@ -93,6 +95,41 @@ func TestTargetReturnsTrackingIDAndReportRoutesByIt(t *testing.T) {
 	}
 }
 
+func TestTargetContextAndReportContext(t *testing.T) {
+	server := startTestCloud(t, testcloud.Config{})
+	rpc := newTestClient(server.Addr(), 1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	resp, sc, err := rpc.TargetContext(ctx, testTargetRequest())
+	if err != nil {
+		t.Fatalf("expected nil, got err %v", err)
+	}
+	if sc != base.RPCServerResponseCode_OK {
+		t.Fatalf("expected status code to be %d, got %d", base.RPCServerResponseCode_OK, sc)
+	}
+
+	sc, err = rpc.ReportContext(ctx, &base.ReportRequest{TrackingId: resp.TrackingId})
+	if err != nil {
+		t.Fatalf("expected nil, got err %v", err)
+	}
+	if sc != base.RPCServerResponseCode_OK {
+		t.Fatalf("expected status code to be %d, got %d", base.RPCServerResponseCode_OK, sc)
+	}
+	nextReport(t, server)
+
+	canceled, cancelNow := context.WithCancel(context.Background())
+	cancelNow()
+	_, sc, err = rpc.TargetContext(canceled, testTargetRequest())
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if sc != base.RPCServerResponseCode_NETWORK_ERROR {
+		t.Fatalf("expected status code to be %d, got %d", base.RPCServerResponseCode_NETWORK_ERROR, sc)
+	}
+}
+
 func TestAuth(t *testing.T) {
 	rpc := getTestClient(t)
 	for i := 0; i < 100; i++ {
@@ -126,6 +163,52 @@ func TestAuthFailureReturnsUnauthorized(t *testing.T) {
 	}
 }
 
+func TestReportIsRoutedBeforeFirstTarget(t *testing.T) {
+	server := startTestCloud(t, testcloud.Config{ServerID: 2048})
+	rpc := newTestClient(server.Addr(), 1)
+
+	sc, err := rpc.Report(&base.ReportRequest{
+		TrackingId: []byte("0800000000000001"),
+		Event:      base.EventType_EVENT_TYPE_CLICK,
+	})
+	if nil != err {
+		t.Fatalf("expected the server id to be learned from hello, got %v", err)
+	}
+	if sc != base.RPCServerResponseCode_OK {
+		t.Fatalf("expected status code to be %d, got %d", base.RPCServerResponseCode_OK, sc)
+	}
+
+	infos := rpc.ServerInfo()
+	if len(infos) != 1 || infos[0].ServerID != 2048 || !infos[0].Capabilities.Has(contract.CapabilityJWTAuth) {
+		t.Fatalf("unexpected server info: %+v", infos)
+	}
+}
+
+func TestOutdatedSDKReturnsErrSDKOutdated(t *testing.T) {
+	server := startTestCloud(t, testcloud.Config{MinSDKVersion: "99.0.0"})
+	rpc := newTestClient(server.Addr(), 1)
+
+	_, sc, err := rpc.Target(testTargetRequest())
+	if !errors.Is(err, client.ErrSDKOutdated) {
+		t.Fatalf("expected ErrSDKOutdated, got %v", err)
+	}
+	if sc != base.RPCServerResponseCode_OUTDATED {
+		t.Fatalf("expected status code to be %d, got %d", base.RPCServerResponseCode_OUTDATED, sc)
+	}
+}
+
+func TestLegacyServerWithoutHello(t *testing.T) {
+	server := startTestCloud(t, testcloud.Config{DisableHello: true})
+	rpc := newTestClient(server.Addr(), 1)
+
+	if _, _, err := rpc.Target(testTargetRequest()); err != nil {
+		t.Fatalf("expected target to succeed, got %v", err)
+	}
+	if infos := rpc.ServerInfo(); len(infos) != 0 {
+		t.Fatalf("expected no server info from a legacy server, got %+v", infos)
+	}
+}
+
 func TestNewWithMTLSUsesClientCertificate(t *testing.T) {
 	ca, err := mtls.GenerateCA(mtls.GenerateCAConfig{CN: "dcr-sdk-test-client-ca"})
 	if err != nil {
@@ -184,6 +267,40 @@ func TestNewWithMTLSUsesClientCertificate(t *testing.T) {
 	}
 }
 
+func TestNewWithMTLSReplacesInvalidValuesWithDefaults(t *testing.T) {
+	ca, err := mtls.GenerateCA(mtls.GenerateCAConfig{CN: "dcr-sdk-test-client-ca"})
+	if err != nil {
+		t.Fatalf("generate client CA: %v", err)
+	}
+	clientCert, err := mtls.Generate(mtls.GenerateConfig{
+		CN:   "dcr-sdk-client",
+		UUID: uuid.NewString(),
+		CA:   ca,
+	})
+	if err != nil {
+		t.Fatalf("generate client certificate: %v", err)
+	}
+	clientRoots := x509.NewCertPool()
+	clientRoots.AddCert(ca.Cert)
+
+	rpc, err := NewWithMTLS(&client.Configuration{
+		Addrs:                          "127.0.0.1:1",
+		MaxRequestDuration:             -time.Second,
+		MaximumSimultaneousConnections: -1,
+	}, MTLSConfig{
+		CertPEM:         clientCert.CertPEM,
+		KeyPEM:          clientCert.KeyPEM,
+		ClientCertRoots: clientRoots,
+	})
+	if err != nil {
+		t.Fatalf("expected invalid values to be replaced with defaults, got %v", err)
+	}
+	defer rpc.Close()
+	if rpc.MaxRequestDuration <= 0 || rpc.MaximumSimultaneousConnections <= 0 {
+		t.Fatalf("expected the defaults, got %s and %d", rpc.MaxRequestDuration, rpc.MaximumSimultaneousConnections)
+	}
+}
+
 func TestNewWithMTLSRejectsInvalidClientCertificate(t *testing.T) {
 	cert, err := mtls.GenerateSelfSigned(mtls.GenerateConfig{
 		CN:   "dcr-sdk-client",